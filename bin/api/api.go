@@ -2,13 +2,19 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"grocery/config"
 	"grocery/database"
 	"grocery/models"
 	"grocery/server"
+	"grocery/shared"
 
 	"github.com/gocraft/web"
 )
@@ -22,20 +28,61 @@ type (
 		*server.Context
 		*server.Server
 	}
+
+	// searchResponse is the search result when facets are requested.
+	searchResponse struct {
+		Products []*database.Match `json:"products"`
+		Facets   *database.Facets  `json:"facets"`
+	}
 )
 
 func NewGroceryAPI() *server.Server {
 	api := server.NewServer(config.APIPORT)
+	registerRoutes()
+
+	return api
+}
 
+func registerRoutes() {
 	server.Router.Subrouter(GroceryAPI{}, "/status").
 		Get("/", (*GroceryAPI).Status)
 	server.Router.Subrouter(GroceryAPI{}, "/products").
+		Get("/", (*GroceryAPI).List).
 		Get("/search", (*GroceryAPI).Search).
+		Get("/suggest", (*GroceryAPI).Suggest).
+		Get("/export", (*GroceryAPI).Export).
+		Get("/trash", (*GroceryAPI).Trash).
 		Get("/:id", (*GroceryAPI).Get).
+		Get("/:id/stock", (*GroceryAPI).GetStock).
+		Get("/:id/history", (*GroceryAPI).History).
+		Get("/:id/prices", (*GroceryAPI).Prices).
 		Post("/", (*GroceryAPI).Create).
-		Delete("/:id", (*GroceryAPI).Delete)
-
-	return api
+		Post("/import", (*GroceryAPI).Import).
+		Post("/batch", (*GroceryAPI).Batch).
+		Post("/:id/stock", (*GroceryAPI).MoveStock).
+		Post("/:id/restore", (*GroceryAPI).Restore).
+		Post("/:id/prices", (*GroceryAPI).SchedulePrice).
+		Put("/:id", (*GroceryAPI).Replace).
+		Patch("/:id", (*GroceryAPI).Patch).
+		Delete("/:id", (*GroceryAPI).Delete).
+		Delete("/:id/prices/:price", (*GroceryAPI).CancelPrice)
+	server.Router.Subrouter(GroceryAPI{}, "/admin/keys").
+		Get("/", (*GroceryAPI).ListKeys).
+		Post("/", (*GroceryAPI).CreateKey).
+		Delete("/:key", (*GroceryAPI).RevokeKey)
+	server.Router.Subrouter(GroceryAPI{}, "/audit").
+		Get("/", (*GroceryAPI).Audit)
+	server.Router.Subrouter(GroceryAPI{}, "/stores").
+		Get("/", (*GroceryAPI).ListStores).
+		Get("/:store", (*GroceryAPI).GetStore).
+		Get("/:store/products/:id", (*GroceryAPI).GetStoreProduct).
+		Get("/:store/products/:id/stock", (*GroceryAPI).GetStoreStock).
+		Post("/", (*GroceryAPI).CreateStore).
+		Post("/:store/products/:id/stock", (*GroceryAPI).MoveStoreStock).
+		Put("/:store", (*GroceryAPI).ReplaceStore).
+		Put("/:store/products/:id/price", (*GroceryAPI).SetStorePrice).
+		Delete("/:store", (*GroceryAPI).DeleteStore).
+		Delete("/:store/products/:id/price", (*GroceryAPI).ClearStorePrice)
 }
 
 func (api *GroceryAPI) Status(rw web.ResponseWriter, req *web.Request) {
@@ -44,20 +91,191 @@ func (api *GroceryAPI) Status(rw web.ResponseWriter, req *web.Request) {
 	api.Respond(rw, 200, "Running")
 }
 
+// List pages through the catalog. It accepts limit, after (the cursor from
+// the previous page), sort (name, price or code) and order (asc or desc).
+func (api *GroceryAPI) List(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+
+	opts := database.ListOptions{
+		After: query.Get("after"),
+		Sort:  query.Get("sort"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			api.Respond(rw, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		opts.Limit = n
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		api.Respond(rw, http.StatusBadRequest, "invalid order")
+		return
+	}
+
+	products, next, err := database.Paginate(database.DB.List(), opts)
+	if err != nil {
+		api.Respond(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	api.RespondPage(rw, http.StatusOK, _successfulMsg, next, products)
+}
+
+// Search finds products matching keyword, most relevant first, each with
+// its score, and/or the filter expression q; in_stock=true limits the
+// results to products with stock on hand. With facets=true the data holds
+// the products alongside their category, brand and price band counts.
 func (api *GroceryAPI) Search(rw web.ResponseWriter, req *web.Request) {
 	log.Print("searching products")
 
-	if keyword := req.URL.Query().Get("keyword"); keyword != "" {
-		products := database.DB.Search(keyword)
-		api.Respond(rw, http.StatusOK, _successfulMsg, products)
-	} else {
+	query := req.URL.Query()
+
+	facets, err := parseBool(query.Get("facets"))
+	if err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid facets")
+		return
+	}
+
+	if query.Get("keyword") == "" && query.Get("q") == "" {
 		api.Respond(rw, http.StatusBadRequest, "invalid search")
+		return
+	}
+
+	products, err := search(query)
+	if err != nil {
+		api.invalidSearch(rw, err)
+		return
+	}
+
+	if facets {
+		matched := make([]*models.Product, len(products))
+		for i, match := range products {
+			matched[i] = match.Product
+		}
+		if products == nil {
+			products = []*database.Match{}
+		}
+		api.Respond(rw, http.StatusOK, _successfulMsg, &searchResponse{
+			Products: products,
+			Facets:   database.ComputeFacets(matched),
+		})
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, products)
+}
+
+// search runs the keyword, q and in_stock parameters of a search. Without a
+// keyword every product is a candidate, in catalog order.
+func search(query url.Values) ([]*database.Match, error) {
+	inStock, err := parseBool(query.Get("in_stock"))
+	if err != nil {
+		return nil, errors.New("invalid in_stock")
+	}
+
+	var filter *database.Query
+	if q := query.Get("q"); q != "" {
+		if filter, err = database.ParseQuery(q); err != nil {
+			return nil, err
+		}
+	}
+
+	var products []*database.Match
+	if keyword := query.Get("keyword"); keyword != "" {
+		products = database.DB.Search(keyword)
+	} else {
+		for _, item := range database.DB.List() {
+			products = append(products, &database.Match{Product: item})
+		}
+	}
+	if filter != nil {
+		products = filterQuery(products, filter)
+	}
+	if inStock {
+		products = filterInStock(products)
+	}
+
+	return products, nil
+}
+
+// invalidSearch responds to a search that couldn't run, pointing at the
+// offending token when the filter expression didn't parse.
+func (api *GroceryAPI) invalidSearch(rw web.ResponseWriter, err error) {
+	var queryErr *database.QueryError
+	if errors.As(err, &queryErr) {
+		api.Respond(rw, http.StatusBadRequest, err.Error(), queryErr)
+		return
+	}
+
+	api.Respond(rw, http.StatusBadRequest, err.Error())
+}
+
+// filterQuery keeps the matches that satisfy the filter expression.
+func filterQuery(matches []*database.Match, filter *database.Query) []*database.Match {
+	filtered := make([]*database.Match, 0, len(matches))
+	for _, match := range matches {
+		if filter.Match(match.Product) {
+			filtered = append(filtered, match)
+		}
+	}
+
+	return filtered
+}
+
+// Suggest completes a partly typed product name for type-ahead. It returns
+// up to limit (default 10, at most 50) names starting with prefix, then
+// names with a later word starting with it.
+func (api *GroceryAPI) Suggest(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+
+	prefix := strings.TrimSpace(query.Get("prefix"))
+	if prefix == "" {
+		api.Respond(rw, http.StatusBadRequest, "invalid prefix")
+		return
+	}
+
+	limit := database.DefaultSuggestLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			api.Respond(rw, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > database.MaxSuggestLimit {
+			n = database.MaxSuggestLimit
+		}
+		limit = n
+	}
+
+	names := database.DB.Suggest(prefix, limit)
+	if names == nil {
+		names = []string{}
 	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, names)
 }
 
+// Get returns a product by code. With at, an RFC 3339 time, it returns the
+// product as it stood or is scheduled to stand then.
 func (api *GroceryAPI) Get(rw web.ResponseWriter, req *web.Request) {
 	if code, ok := req.PathParams["id"]; ok {
+		if at := req.URL.Query().Get("at"); at != "" {
+			api.getAt(rw, code, at)
+			return
+		}
 		if product := database.DB.Get(code); product != nil {
+			rw.Header().Set("ETag", etag(product))
+			if ifNoneMatch(req, product) {
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
 			api.Respond(rw, http.StatusOK, _successfulMsg, product)
 		} else {
 			api.Respond(rw, http.StatusNoContent, _successfulMsg)
@@ -81,7 +299,11 @@ func (api *GroceryAPI) Create(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
-	createdProducts, errs := database.DB.Put(products...)
+	createdProducts, errs := api.store().Put(products...)
+	if len(errs) > 0 && errors.Is(errs[0], database.ErrInvalidProduct) {
+		api.invalid(rw, errs[0])
+		return
+	}
 	if len(errs) > 0 {
 		api.Respond(rw, http.StatusInternalServerError, "unable to create product", errs)
 		log.Print("error creating products [ERR: ]", errs)
@@ -91,9 +313,121 @@ func (api *GroceryAPI) Create(rw web.ResponseWriter, req *web.Request) {
 	api.Respond(rw, http.StatusOK, _successfulMsg, createdProducts)
 }
 
+// Replace overwrites an existing product with the one in the request body,
+// keeping its code. An If-Match header makes the write conditional on the
+// product's current ETag.
+func (api *GroceryAPI) Replace(rw web.ResponseWriter, req *web.Request) {
+	code := req.PathParams["id"]
+
+	version, ok := ifMatchVersion(req, database.DB.Get(code))
+	if !ok {
+		api.Respond(rw, http.StatusPreconditionFailed, database.ErrVersionMismatch.Error())
+		return
+	}
+
+	var product *models.Product
+	if err := json.NewDecoder(req.Body).Decode(&product); err != nil || product == nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid product data")
+		return
+	}
+
+	api.update(rw, code, product, version)
+}
+
+// Patch applies a JSON merge patch (RFC 7386) to an existing product.
+func (api *GroceryAPI) Patch(rw web.ResponseWriter, req *web.Request) {
+	code := req.PathParams["id"]
+
+	existing := database.DB.Get(code)
+
+	version, ok := ifMatchVersion(req, existing)
+	if !ok {
+		api.Respond(rw, http.StatusPreconditionFailed, database.ErrVersionMismatch.Error())
+		return
+	}
+	if existing == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	patch, err := io.ReadAll(req.Body)
+	if err != nil || len(patch) == 0 {
+		api.Respond(rw, http.StatusBadRequest, "invalid patch data")
+		return
+	}
+
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		api.Respond(rw, http.StatusInternalServerError, "unable to patch product")
+		return
+	}
+	if doc, err = shared.MergePatch(doc, patch); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid patch data")
+		return
+	}
+
+	var product *models.Product
+	if err := json.Unmarshal(doc, &product); err != nil || product == nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid product data")
+		return
+	}
+
+	api.update(rw, code, product, version)
+}
+
+func (api *GroceryAPI) update(rw web.ResponseWriter, code string, product *models.Product, version int64) {
+	updated, err := api.store().Update(code, product, version)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrVersionMismatch):
+		api.Respond(rw, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, database.ErrInvalidProduct):
+		api.invalid(rw, err)
+	case err != nil:
+		api.Respond(rw, http.StatusInternalServerError, "unable to update product")
+		log.Print("error updating product [ERR: ]", err)
+	default:
+		rw.Header().Set("ETag", etag(updated))
+		api.Respond(rw, http.StatusOK, _successfulMsg, updated)
+	}
+}
+
+// invalid responds to a product that failed validation, including the
+// offending field when the database named one.
+func (api *GroceryAPI) invalid(rw web.ResponseWriter, err error) {
+	var fieldErr *database.FieldError
+	if errors.As(err, &fieldErr) {
+		api.Respond(rw, http.StatusBadRequest, err.Error(), fieldErr)
+		return
+	}
+
+	api.Respond(rw, http.StatusBadRequest, err.Error())
+}
+
+// Delete moves a product to the trash, where it can be restored until the
+// sweeper purges it. An If-Match header makes the delete conditional on the
+// product's current ETag.
 func (api *GroceryAPI) Delete(rw web.ResponseWriter, req *web.Request) {
 	if code, ok := req.PathParams["id"]; ok {
-		database.DB.Del(code)
+		version, ok := ifMatchVersion(req, database.DB.Get(code))
+		if !ok {
+			api.Respond(rw, http.StatusPreconditionFailed, database.ErrVersionMismatch.Error())
+			return
+		}
+
+		if err := api.store().Del(code, version); err != nil {
+			switch {
+			case errors.Is(err, database.ErrNotFound):
+				api.Respond(rw, http.StatusNotFound, err.Error())
+			case errors.Is(err, database.ErrVersionMismatch):
+				api.Respond(rw, http.StatusPreconditionFailed, err.Error())
+			default:
+				api.Respond(rw, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+
 		api.Respond(rw, http.StatusOK, _successfulMsg)
 		return
 	}
@@ -3,33 +3,62 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"grocery/auth"
 	"grocery/config"
 	"grocery/database"
+	"grocery/models"
 	"grocery/server"
 )
 
+var (
+	// _testKey is a manager key the tests make their requests with.
+	_testKey string
+	// _testServer is the server the routes are registered on, for the tests
+	// that need its handler rather than the bare router.
+	_testServer *server.Server
+)
+
 func testAPISetup() {
 	if server.Router == nil {
 		database.Connect()
+		auth.Connect()
+
+		secret, key, err := auth.Keys.Create("tester", auth.RoleManager)
+		if err != nil {
+			panic(err)
+		}
+		// the tests make requests faster than any client should
+		_testKey, config.KEYRATELIMITS[key.ID] = secret, config.RatePolicy{}
+		config.ROUTERATELIMITS = map[string]config.RatePolicy{}
+		config.ADDRESSRATELIMIT = config.RatePolicy{}
 
-		server.NewServer(config.APIPORT)
+		_testServer = server.NewServer(config.APIPORT)
+		registerRoutes()
+	}
+}
 
-		server.Router.Subrouter(GroceryAPI{}, "/status").
-			Get("/", (*GroceryAPI).Status)
-		server.Router.Subrouter(GroceryAPI{}, "/products").
-			Get("/search", (*GroceryAPI).Search).
-			Get("/:id", (*GroceryAPI).Get).
-			Post("/", (*GroceryAPI).Create).
-			Delete("/:id", (*GroceryAPI).Delete)
+// serve routes req as the tests' manager unless it brings its own key.
+func serve(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("X-API-Key") == "" {
+		req.Header.Set("X-API-Key", _testKey)
 	}
+
+	server.Router.ServeHTTP(w, req)
 }
 
 func TestStatus(t *testing.T) {
@@ -42,13 +71,96 @@ func TestStatus(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	server.Router.ServeHTTP(w, req)
+	serve(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
 	}
 }
 
+func TestList(t *testing.T) {
+	testAPISetup()
+
+	var (
+		seen  = map[string]bool{}
+		after string
+		pages int
+	)
+
+	for {
+		values := url.Values{}
+		values.Add("limit", "2")
+		values.Add("sort", "price")
+		values.Add("order", "desc")
+		if after != "" {
+			values.Add("after", after)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/products?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data []*models.Product `json:"data"`
+			Next string            `json:"next"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+		if len(msg.Data) > 2 {
+			t.Fatalf("wanted at most 2 products per page but got %d", len(msg.Data))
+		}
+
+		for _, product := range msg.Data {
+			if seen[product.Code] {
+				t.Fatalf("product %s listed twice", product.Code)
+			}
+			seen[product.Code] = true
+		}
+
+		pages++
+		if after = msg.Next; after == "" {
+			break
+		}
+	}
+
+	if want := len(database.DB.List()); len(seen) != want {
+		t.Errorf("wanted %d products across %d pages but got %d", want, pages, len(seen))
+	}
+
+	for _, query := range []string{"limit=0", "limit=x", "sort=colour", "order=up", "after=nope"} {
+		req, err := http.NewRequest(http.MethodGet, "/products?"+query, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d but got %d\n", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
 func TestSearch(t *testing.T) {
 	testAPISetup()
 
@@ -65,7 +177,7 @@ func TestSearch(t *testing.T) {
 
 	w := httptest.NewRecorder()
 
-	server.Router.ServeHTTP(w, req)
+	serve(w, req)
 	respBody := w.Result().Body
 
 	reader, err := gzip.NewReader(respBody)
@@ -92,6 +204,205 @@ func TestSearch(t *testing.T) {
 
 }
 
+func TestSearchRanking(t *testing.T) {
+	testAPISetup()
+
+	req, err := http.NewRequest(http.MethodGet, "/products/search?keyword=apples", nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	reader, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+	defer reader.Close()
+
+	var msg struct {
+		Data []*database.Match `json:"data"`
+	}
+
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+	if len(msg.Data) == 0 || msg.Data[0].Name != "Gala Apple" || msg.Data[0].Score <= 0 {
+		t.Fatalf("wanted Gala Apple ranked first with a score but got %+v", msg.Data)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	testAPISetup()
+
+	var queryTable = []struct {
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{`price<3 AND name:"*p*"`, http.StatusOK, []string{"Peach", "Green Pepper"}},
+		{`name:green OR name:lettuce`, http.StatusOK, []string{"Lettuce", "Green Pepper"}},
+		{`price<3 AND colour:red`, http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range queryTable {
+		values := url.Values{}
+		values.Add("q", tc.query)
+
+		req, err := http.NewRequest(http.MethodGet, "/products/search?"+values.Encode(), nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d but got %d\n", tc.query, tc.wantCode, w.Code)
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data json.RawMessage `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if tc.wantCode != http.StatusOK {
+			var qerr database.QueryError
+			if err := json.Unmarshal(msg.Data, &qerr); err != nil || qerr.Column != 13 {
+				t.Errorf("%s: wanted the error to point at column 13 but got %s", tc.query, msg.Data)
+			}
+			continue
+		}
+
+		var matches []*database.Match
+		if err := json.Unmarshal(msg.Data, &matches); err != nil {
+			t.Fatalf("failed decoding matches [ERR: %s]", err)
+		}
+
+		var got []string
+		for _, match := range matches {
+			got = append(got, match.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: wanted %v but got %v", tc.query, tc.want, got)
+		}
+	}
+}
+
+func TestSearchFacets(t *testing.T) {
+	testAPISetup()
+
+	values := url.Values{}
+	values.Add("q", "price<3")
+	values.Add("facets", "true")
+
+	req, err := http.NewRequest(http.MethodGet, "/products/search?"+values.Encode(), nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+
+	reader, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+	defer reader.Close()
+
+	var msg struct {
+		Data searchResponse `json:"data"`
+	}
+
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+
+	if len(msg.Data.Products) != 2 {
+		t.Fatalf("wanted 2 products but got %d", len(msg.Data.Products))
+	}
+
+	var total int
+	for _, band := range msg.Data.Facets.Price {
+		total += band.Count
+	}
+	if total != len(msg.Data.Products) {
+		t.Errorf("wanted price bands to count %d products but counted %d", len(msg.Data.Products), total)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	testAPISetup()
+
+	var suggestTable = []struct {
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{"prefix=gr", http.StatusOK, []string{"Green Pepper"}},
+		{"prefix=p", http.StatusOK, []string{"Peach", "Green Pepper"}},
+		{"prefix=p&limit=1", http.StatusOK, []string{"Peach"}},
+		{"prefix=zz", http.StatusOK, []string{}},
+		{"prefix=", http.StatusBadRequest, nil},
+		{"prefix=p&limit=0", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range suggestTable {
+		req, err := http.NewRequest(http.MethodGet, "/products/suggest?"+tc.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d but got %d\n", tc.query, tc.wantCode, w.Code)
+		}
+		if tc.wantCode != http.StatusOK {
+			continue
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data []string `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if msg.Data == nil || strings.Join(msg.Data, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: wanted %v but got %v", tc.query, tc.want, msg.Data)
+		}
+	}
+}
+
 func TestGet(t *testing.T) {
 	testAPISetup()
 
@@ -111,7 +422,7 @@ func TestGet(t *testing.T) {
 
 		w := httptest.NewRecorder()
 
-		server.Router.ServeHTTP(w, req)
+		serve(w, req)
 		respBody := w.Result().Body
 
 		reader, err := gzip.NewReader(respBody)
@@ -162,7 +473,7 @@ func TestCreate(t *testing.T) {
 
 		w := httptest.NewRecorder()
 
-		server.Router.ServeHTTP(w, req)
+		serve(w, req)
 		respBody := w.Result().Body
 
 		reader, err := gzip.NewReader(respBody)
@@ -190,48 +501,1346 @@ func TestCreate(t *testing.T) {
 	}
 }
 
-func TestDelete(t *testing.T) {
+func TestCreateInvalid(t *testing.T) {
 	testAPISetup()
 
-	var delTable = map[int]string{
-		http.StatusOK: database.DummyData[0].Code,
+	body := `[{"name": "Cola", "barcode": "036000291453", "unit": "oz", "size": 12}]`
+	req, err := http.NewRequest(http.MethodPost, "/products", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("failed to create the request: %v\n", err)
 	}
 
-	for expectedStatusCode, productCode := range delTable {
-		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf(
-			"/products/%s",
-			productCode,
-		), nil)
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusBadRequest, w.Code)
+	}
+
+	reader, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+	defer reader.Close()
+
+	var msg struct {
+		Data *database.FieldError `json:"data"`
+	}
+
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+	if msg.Data == nil || msg.Data.Field != "barcode" {
+		t.Errorf("wanted error naming the barcode field but got %+v", msg.Data)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	testAPISetup()
+
+	code := database.DummyData[2].Code
+
+	var replaceTable = []struct {
+		code     string
+		body     string
+		wantCode int
+	}{
+		{code, `{"name": "Red Pepper", "price": 1.2549}`, http.StatusOK},
+		{code, `{"name": "Red Pepper!", "price": 1.25}`, http.StatusBadRequest},
+		{code, `not json`, http.StatusBadRequest},
+		{"this-isnt-real-code", `{"name": "Plum", "price": 1}`, http.StatusNotFound},
+	}
+
+	for _, tc := range replaceTable {
+		req, err := http.NewRequest(http.MethodPut, "/products/"+tc.code, bytes.NewBufferString(tc.body))
 		if err != nil {
 			t.Fatalf("failed to create the request: %v\n", err)
 		}
 
 		w := httptest.NewRecorder()
 
-		server.Router.ServeHTTP(w, req)
-		respBody := w.Result().Body
+		serve(w, req)
 
-		reader, err := gzip.NewReader(respBody)
-		if err != nil {
-			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		if w.Code != tc.wantCode {
+			t.Fatalf("PUT %s: expected status code %d but got %d\n", tc.body, tc.wantCode, w.Code)
 		}
+	}
 
-		body, err := io.ReadAll(reader)
+	product := database.DB.Get(code)
+	if product == nil || product.Code != code || product.Name != "Red Pepper" || product.Price.Amount != 125 {
+		t.Errorf("product not replaced in place; got %s", product)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	testAPISetup()
+
+	code := database.DummyData[3].Code
+	name := database.DB.Get(code).Name
+
+	var patchTable = []struct {
+		code     string
+		body     string
+		wantCode int
+	}{
+		{code, `{"price": 4.19}`, http.StatusOK},
+		{code, `{"name": "Fuji Apple?"}`, http.StatusBadRequest},
+		{code, ``, http.StatusBadRequest},
+		{"this-isnt-real-code", `{"price": 1}`, http.StatusNotFound},
+	}
+
+	for _, tc := range patchTable {
+		req, err := http.NewRequest(http.MethodPatch, "/products/"+tc.code, bytes.NewBufferString(tc.body))
 		if err != nil {
-			reader.Close()
-			t.Fatalf("failed to read gzip reader [ERR: %s]", err)
+			t.Fatalf("failed to create the request: %v\n", err)
 		}
-		reader.Close()
+		req.Header.Set("Content-Type", "application/merge-patch+json")
 
-		var msg *server.Message
+		w := httptest.NewRecorder()
 
-		err = json.Unmarshal(body, &msg)
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("PATCH %s: expected status code %d but got %d\n", tc.body, tc.wantCode, w.Code)
+		}
+	}
+
+	product := database.DB.Get(code)
+	if product == nil || product.Code != code || product.Name != name || product.Price.Amount != 419 {
+		t.Errorf("product not patched in place; got %s", product)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	testAPISetup()
+
+	products, errs := database.DB.Put(&models.Product{Name: "Oat Milk", Price: models.NewMoney(399, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	path := "/products/" + products[0].Code
+
+	do := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
 		if err != nil {
-			t.Fatalf("failed decoding response body [ERR: %s]", err)
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
 		}
 
-		if w.Code != expectedStatusCode {
-			t.Fatalf("expected status code %d but got %d\n", expectedStatusCode, w.Code)
+		w := httptest.NewRecorder()
+		serve(w, req)
+
+		return w
+	}
+
+	w := do(http.MethodGet, "", nil)
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || tag != `"1"` {
+		t.Fatalf("wanted 200 with ETag \"1\" but got %d with %q", w.Code, tag)
+	}
+
+	if w := do(http.MethodGet, "", map[string]string{"If-None-Match": tag}); w.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match current: expected status code %d but got %d", http.StatusNotModified, w.Code)
+	}
+
+	w = do(http.MethodPatch, `{"price": 4.29}`, map[string]string{"If-Match": tag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("PATCH If-Match current: wanted 200 with ETag \"2\" but got %d with %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	var staleTable = []struct {
+		method, body string
+	}{
+		{http.MethodPatch, `{"price": 4.49}`},
+		{http.MethodPut, `{"name": "Oat Milk", "price": 4.49}`},
+		{http.MethodDelete, ""},
+	}
+
+	for _, tc := range staleTable {
+		if w := do(tc.method, tc.body, map[string]string{"If-Match": tag}); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s If-Match stale: expected status code %d but got %d", tc.method, http.StatusPreconditionFailed, w.Code)
+		}
+	}
+
+	if w := do(http.MethodGet, "", map[string]string{"If-None-Match": tag}); w.Code != http.StatusOK {
+		t.Errorf("GET If-None-Match stale: expected status code %d but got %d", http.StatusOK, w.Code)
+	}
+
+	if w := do(http.MethodDelete, "", map[string]string{"If-Match": `W/"2", "2"`}); w.Code != http.StatusOK {
+		t.Errorf("DELETE If-Match current: expected status code %d but got %d", http.StatusOK, w.Code)
+	}
+	if database.DB.Get(products[0].Code) != nil {
+		t.Error("product still present after conditional delete")
+	}
+}
+
+func TestStock(t *testing.T) {
+	testAPISetup()
+
+	products, errs := database.DB.Put(&models.Product{Name: "Blueberries", Price: models.NewMoney(499, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	path := "/products/" + products[0].Code + "/stock"
+
+	var stockTable = []struct {
+		body     string
+		wantCode int
+	}{
+		{`{"op": "receive", "quantity": 12, "reason": "delivery"}`, http.StatusOK},
+		{`{"op": "sell", "quantity": 5}`, http.StatusOK},
+		{`{"op": "sell", "quantity": 8}`, http.StatusConflict},
+		{`{"op": "adjust", "quantity": -1}`, http.StatusBadRequest},
+		{`{"op": "steal", "quantity": 1}`, http.StatusBadRequest},
+		{`{"op": "adjust", "quantity": -1, "reason": "moldy"}`, http.StatusOK},
+	}
+
+	for _, tc := range stockTable {
+		req, err := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("POST %s: expected status code %d but got %d\n", tc.body, tc.wantCode, w.Code)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	reader, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+	defer reader.Close()
+
+	var msg struct {
+		Data stockResponse `json:"data"`
+	}
+
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+	if msg.Data.OnHand != 6 || len(msg.Data.Movements) != 3 {
+		t.Errorf("wanted 6 on hand after 3 movements but got %+v", msg.Data)
+	}
+
+	for query, want := range map[string]int{"keyword=blueberr": 1, "keyword=blueberr&in_stock=true": 1, "keyword=peach&in_stock=true": 0} {
+		req, err := http.NewRequest(http.MethodGet, "/products/search?"+query, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data []*models.Product `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+		if len(msg.Data) != want {
+			t.Errorf("%s: wanted %d results but got %d", query, want, len(msg.Data))
+		}
+	}
+}
+
+func TestStores(t *testing.T) {
+	testAPISetup()
+
+	code := database.DummyData[1].Code
+
+	var storeTable = []struct {
+		method, path, body string
+		wantCode           int
+	}{
+		{http.MethodPost, "/stores", `{"id": "eastside", "name": "Eastside Market"}`, http.StatusOK},
+		{http.MethodPost, "/stores", `{"id": "eastside", "name": "Again"}`, http.StatusConflict},
+		{http.MethodPost, "/stores", `{"id": "west side", "name": "Westside"}`, http.StatusBadRequest},
+		{http.MethodGet, "/stores/eastside", "", http.StatusOK},
+		{http.MethodGet, "/stores/nowhere/products/" + code, "", http.StatusNotFound},
+		{http.MethodPut, "/stores/eastside/products/" + code + "/price", `"2.49"`, http.StatusOK},
+		{http.MethodPut, "/stores/eastside/products/" + code + "/price", `{"amount": 100, "currency": "XYZ"}`, http.StatusBadRequest},
+		{http.MethodPut, "/stores/eastside/products/" + code + "/price", `{"amount": 100, "currency": "EUR"}`, http.StatusBadRequest},
+		{http.MethodPost, "/stores/eastside/products/" + code + "/stock", `{"op": "receive", "quantity": 3}`, http.StatusOK},
+		{http.MethodGet, "/stores/eastside/products/" + code + "/stock", "", http.StatusOK},
+	}
+
+	for _, tc := range storeTable {
+		req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s %s: expected status code %d but got %d\n", tc.method, tc.path, tc.wantCode, w.Code)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/stores/eastside/products/"+code, nil)
+	if err != nil {
+		t.Fatalf("failed to create the request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	reader, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+	defer reader.Close()
+
+	var msg struct {
+		Data *models.StoreProduct `json:"data"`
+	}
+
+	if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+	if msg.Data == nil || msg.Data.Price.Amount != 249 || !msg.Data.StorePrice || msg.Data.OnHand != 3 {
+		t.Errorf("wanted store price 2.49 with 3 on hand but got %+v", msg.Data)
+	}
+
+	var deleteTable = []struct {
+		path     string
+		wantCode int
+	}{
+		{"/stores/eastside/products/" + code + "/price", http.StatusOK},
+		{"/stores/eastside/products/" + code + "/price", http.StatusNotFound},
+		{"/stores/nowhere/products/" + code + "/price", http.StatusNotFound},
+		{"/stores/eastside", http.StatusOK},
+		{"/stores/eastside", http.StatusNotFound},
+	}
+
+	for _, tc := range deleteTable {
+		req, err := http.NewRequest(http.MethodDelete, tc.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("DELETE %s: expected status code %d but got %d\n", tc.path, tc.wantCode, w.Code)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	testAPISetup()
+
+	var delTable = map[int]string{
+		http.StatusOK: database.DummyData[0].Code,
+	}
+
+	for expectedStatusCode, productCode := range delTable {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf(
+			"/products/%s",
+			productCode,
+		), nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+		respBody := w.Result().Body
+
+		reader, err := gzip.NewReader(respBody)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			reader.Close()
+			t.Fatalf("failed to read gzip reader [ERR: %s]", err)
+		}
+		reader.Close()
+
+		var msg *server.Message
+
+		err = json.Unmarshal(body, &msg)
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if w.Code != expectedStatusCode {
+			t.Fatalf("expected status code %d but got %d\n", expectedStatusCode, w.Code)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	testAPISetup()
+
+	var importTable = []struct {
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+		wantCreated int
+	}{
+		{"/products/import", "text/csv; charset=utf-8", "name,price\nBasil,2.49\n,1.00\n", http.StatusOK, 1},
+		{"/products/import?mode=atomic", "text/csv", "name,price\nChives,1.99\n,1.00\n", http.StatusBadRequest, 0},
+		{"/products/import?mode=atomic", "application/x-ndjson", `{"name":"Dill","price":"1.49"}` + "\n", http.StatusOK, 1},
+		{"/products/import?format=ndjson", "text/plain", `{"name":"Fennel","price":"2.99"}`, http.StatusOK, 1},
+		{"/products/import", "application/xml", "<products/>", http.StatusUnsupportedMediaType, 0},
+		{"/products/import", "text/csv", "colour\ngreen\n", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range importTable {
+		req, err := http.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s %s: expected status code %d but got %d\n", tc.path, tc.contentType, tc.wantCode, w.Code)
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data *database.ImportReport `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if msg.Data == nil {
+			if tc.wantCreated > 0 {
+				t.Errorf("%s: wanted a report", tc.path)
+			}
+			continue
+		}
+		if msg.Data.Created != tc.wantCreated {
+			t.Errorf("%s: wanted %d created but got %d", tc.path, tc.wantCreated, msg.Data.Created)
+		}
+		for _, row := range msg.Data.Rows {
+			if row.Status == database.ImportCreated {
+				database.DB.Del(row.Code, 0)
+			}
+		}
+	}
+}
+
+func TestImportBodyLimit(t *testing.T) {
+	testAPISetup()
+
+	limit := config.ROUTEBODYLIMITS["POST /products/import"]
+	config.ROUTEBODYLIMITS["POST /products/import"] = 100
+	defer func() { config.ROUTEBODYLIMITS["POST /products/import"] = limit }()
+
+	body := strings.Repeat(`{"name":"Sorrel","price":"1.25"}`+"\n", 10)
+
+	var limitTable = []struct {
+		path        string
+		wantCreated int
+	}{
+		// the first three rows fit within the limit
+		{"/products/import", 3},
+		{"/products/import?mode=atomic", 0},
+	}
+
+	for _, tc := range limitTable {
+		req, err := http.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("X-API-Key", _testKey)
+
+		w := httptest.NewRecorder()
+
+		_testServer.Handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s: expected status code %d but got %d\n", tc.path, http.StatusRequestEntityTooLarge, w.Code)
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data *database.ImportReport `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if msg.Data == nil || msg.Data.Created != tc.wantCreated {
+			t.Fatalf("%s: wanted a report of %d created but got %+v", tc.path, tc.wantCreated, msg.Data)
+		}
+		for _, row := range msg.Data.Rows {
+			if row.Status == database.ImportCreated {
+				database.DB.Del(row.Code, 0)
+			}
+		}
+	}
+
+	// other routes keep the default limit, which doesn't apply to imports
+	config.ROUTEBODYLIMITS["POST /products/import"] = limit
+	maxBody := config.MAXBODYBYTES
+	config.MAXBODYBYTES = 100
+	defer func() { config.MAXBODYBYTES = maxBody }()
+
+	var routeTable = []struct {
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"/products", `{"name":"Sorrel","price":"1.25","description":"` + strings.Repeat("x", 200) + `"}`, http.StatusBadRequest},
+		{"/products/import", body, http.StatusOK},
+	}
+
+	for _, tc := range routeTable {
+		req, err := http.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("X-API-Key", _testKey)
+
+		w := httptest.NewRecorder()
+
+		_testServer.Handler.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: expected status code %d but got %d\n", tc.path, tc.wantCode, w.Code)
+		}
+	}
+	for _, item := range database.DB.List() {
+		if item.Name == "Sorrel" {
+			database.DB.Del(item.Code, 0)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	var encodingTable = []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"br, *;q=0.1", true},
+		{"notgzip", false},
+	}
+
+	for _, tc := range encodingTable {
+		if got := acceptsGzip(tc.acceptEncoding); got != tc.want {
+			t.Errorf("%q: wanted %t but got %t", tc.acceptEncoding, tc.want, got)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	testAPISetup()
+
+	var exportTable = []struct {
+		path        string
+		accept      string
+		gzip        bool
+		wantCode    int
+		wantType    string
+		wantPrefix  string
+		wantRecords int
+	}{
+		{"/products/export", "text/csv", false, http.StatusOK, "text/csv; charset=utf-8", "code,name,price", len(database.DB.List()) + 1},
+		{"/products/export", "text/csv;q=0.5, application/x-ndjson;q=0.9", false, http.StatusOK, "application/x-ndjson", "{", len(database.DB.List())},
+		{"/products/export?format=json", "text/csv", true, http.StatusOK, "application/json", "[", -1},
+		{"/products/export?format=ndjson&q=" + url.QueryEscape(`name="green pepper"`), "", false, http.StatusOK, "application/x-ndjson", `{"code":`, 1},
+		{"/products/export", "application/xml", false, http.StatusNotAcceptable, "", "", -1},
+		{"/products/export?format=xlsx", "", false, http.StatusBadRequest, "", "", -1},
+	}
+
+	for _, tc := range exportTable {
+		req, err := http.NewRequest(http.MethodGet, tc.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		req.Header.Set("Accept", tc.accept)
+		if tc.gzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s %s: expected status code %d but got %d\n", tc.path, tc.accept, tc.wantCode, w.Code)
+		}
+		if tc.wantCode != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.wantType {
+			t.Errorf("%s %s: wanted Content-Type %s but got %s", tc.path, tc.accept, tc.wantType, got)
+		}
+
+		var body io.Reader = w.Body
+		if tc.gzip {
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("failed to read gzip body [ERR: %s]", err)
+			}
+			defer reader.Close()
+			body = reader
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("failed to read body [ERR: %s]", err)
+		}
+
+		if !strings.HasPrefix(string(b), tc.wantPrefix) {
+			t.Errorf("%s %s: wanted body starting %q but got %q", tc.path, tc.accept, tc.wantPrefix, b)
+		}
+		if lines := strings.Count(string(b), "\n"); tc.wantRecords >= 0 && lines != tc.wantRecords {
+			t.Errorf("%s %s: wanted %d lines but got %d", tc.path, tc.accept, tc.wantRecords, lines)
+		}
+	}
+}
+
+func TestNegotiateExport(t *testing.T) {
+	var acceptTable = map[string]string{
+		"":                                    database.ExportJSON,
+		"*/*":                                 database.ExportJSON,
+		"text/*":                              database.ExportCSV,
+		"text/html, text/csv":                 database.ExportCSV,
+		"application/ndjson":                  database.ExportNDJSON,
+		"text/csv;q=0.2, application/*;q=0.8": database.ExportJSON,
+		"text/csv;q=0":                        "",
+		"image/png":                           "",
+	}
+
+	for accept, want := range acceptTable {
+		if _, got := negotiateExport(accept); got != want {
+			t.Errorf("%q: wanted %q but got %q", accept, want, got)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	testAPISetup()
+
+	products, errs := database.DB.Put(&models.Product{Name: "Rhubarb", Price: models.NewMoney(399, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	code := products[0].Code
+
+	var batchTable = []struct {
+		body       string
+		wantCode   int
+		wantStatus []string
+	}{
+		{`[{"op": "create", "product": {"name": "Sorrel", "price": "2.99"}}, {"op": "update", "code": "` + code + `", "version": 2, "product": {"name": "Red Rhubarb"}}]`,
+			http.StatusPreconditionFailed, []string{database.BatchRolledBack, database.BatchFailed}},
+		{`[{"op": "create", "product": {"name": ""}}, {"op": "delete", "code": "` + code + `"}]`,
+			http.StatusBadRequest, []string{database.BatchFailed, database.BatchRolledBack}},
+		{`[{"op": "update", "code": "nope", "product": {"name": "Nope"}}]`,
+			http.StatusNotFound, []string{database.BatchFailed}},
+		{`[]`, http.StatusBadRequest, nil},
+		{`{"op": "create"}`, http.StatusBadRequest, nil},
+		{`[{"op": "update", "code": "` + code + `", "version": 1, "product": {"name": "Red Rhubarb", "price": "4.29"}}, {"op": "delete", "code": "` + code + `", "version": 2}]`,
+			http.StatusOK, []string{database.BatchOK, database.BatchOK}},
+	}
+
+	for _, tc := range batchTable {
+		req, err := http.NewRequest(http.MethodPost, "/products/batch", bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d but got %d\n", tc.body, tc.wantCode, w.Code)
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data []*database.BatchResult `json:"data"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if len(msg.Data) != len(tc.wantStatus) {
+			t.Fatalf("%s: wanted %d results but got %d", tc.body, len(tc.wantStatus), len(msg.Data))
+		}
+		for i, status := range tc.wantStatus {
+			if msg.Data[i].Status != status {
+				t.Errorf("%s: operation %d: wanted %s but got %s", tc.body, i, status, msg.Data[i].Status)
+			}
+		}
+	}
+
+	if database.DB.Get(code) != nil {
+		t.Error("wanted the product deleted by the last batch")
+	}
+	if matches := database.DB.Search("sorrel"); len(matches) != 0 {
+		t.Error("wanted the rolled back create left out of the catalog")
+	}
+}
+
+func TestTrash(t *testing.T) {
+	testAPISetup()
+
+	products, errs := database.DB.Put(&models.Product{Name: "Quince", Price: models.NewMoney(199, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	code := products[0].Code
+
+	var trashTable = []struct {
+		method   string
+		path     string
+		ifMatch  string
+		wantCode int
+	}{
+		{http.MethodDelete, "/products/" + code, "", http.StatusOK},
+		{http.MethodDelete, "/products/" + code, "", http.StatusNotFound},
+		{http.MethodGet, "/products/" + code, "", http.StatusNoContent},
+		{http.MethodPost, "/products/" + code + "/restore", `"1"`, http.StatusPreconditionFailed},
+		{http.MethodPost, "/products/" + code + "/restore", `"2"`, http.StatusOK},
+		{http.MethodGet, "/products/" + code, "", http.StatusOK},
+		{http.MethodPost, "/products/" + code + "/restore", "", http.StatusNotFound},
+		{http.MethodDelete, "/products/this-isnt-real-code", "", http.StatusNotFound},
+	}
+
+	for _, tc := range trashTable {
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create the request: %v\n", err)
+		}
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s %s: expected status code %d but got %d\n", tc.method, tc.path, tc.wantCode, w.Code)
+		}
+
+		// the trash is listed between the delete and the restore
+		if tc.method == http.MethodDelete && tc.wantCode == http.StatusOK {
+			req, _ := http.NewRequest(http.MethodGet, "/products/trash", nil)
+			w := httptest.NewRecorder()
+
+			serve(w, req)
+
+			reader, err := gzip.NewReader(w.Result().Body)
+			if err != nil {
+				t.Fatalf("failed to read gzip body [ERR: %s]", err)
+			}
+
+			var msg struct {
+				Data []*models.Product `json:"data"`
+			}
+
+			err = json.NewDecoder(reader).Decode(&msg)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("failed decoding response body [ERR: %s]", err)
+			}
+
+			if len(msg.Data) == 0 || msg.Data[0].Code != code || msg.Data[0].DeletedAt == nil {
+				t.Errorf("wanted %s at the top of the trash but got %v", code, msg.Data)
+			}
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	testAPISetup()
+
+	req, _ := http.NewRequest(http.MethodPost, "/products", strings.NewReader(`[{"name": "Kumquat", "price": {"amount": 349, "currency": "USD"}}]`))
+	req.Header.Set("X-Request-ID", "history-test")
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "history-test" {
+		t.Errorf("wanted the request ID echoed but got %q", got)
+	}
+
+	created := database.DB.Search("kumquat")
+	if len(created) == 0 {
+		t.Fatal("wanted the product created")
+	}
+	code := created[0].Product.Code
+
+	req, _ = http.NewRequest(http.MethodPatch, "/products/"+code, strings.NewReader(`{"brand": "Orchard"}`))
+	w = httptest.NewRecorder()
+
+	serve(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("wanted a request ID made up for the request")
+	}
+
+	var historyTable = []struct {
+		path     string
+		wantCode int
+		wantLen  int
+	}{
+		{"/products/" + code + "/history", http.StatusOK, 2},
+		{"/products/this-isnt-real-code/history", http.StatusNotFound, 0},
+		{"/audit?code=" + code, http.StatusOK, 2},
+		{"/audit?code=" + code + "&action=update", http.StatusOK, 1},
+		{"/audit?code=" + code + "&limit=1", http.StatusOK, 1},
+		{"/audit?code=" + code + "&since=2000-01-01T00:00:00Z&until=2000-01-02T00:00:00Z", http.StatusOK, 0},
+		{"/audit?since=yesterday", http.StatusBadRequest, 0},
+		{"/audit?limit=0", http.StatusBadRequest, 0},
+		{"/audit?before=x", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range historyTable {
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s: expected status code %d but got %d\n", tc.path, tc.wantCode, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+
+		var msg struct {
+			Data []*database.AuditEntry `json:"data"`
+			Next string                 `json:"next"`
+		}
+
+		err = json.NewDecoder(reader).Decode(&msg)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed decoding response body [ERR: %s]", err)
+		}
+
+		if len(msg.Data) != tc.wantLen {
+			t.Errorf("%s: wanted %d entries but got %d", tc.path, tc.wantLen, len(msg.Data))
+			continue
+		}
+		if strings.Contains(tc.path, "limit=1") && msg.Next == "" {
+			t.Errorf("%s: wanted a cursor to the next page", tc.path)
+		}
+		if tc.wantLen == 2 {
+			update, create := msg.Data[0], msg.Data[1]
+			if update.Action != database.AuditUpdate || len(update.Changes) != 1 || update.Changes[0].Field != "brand" {
+				t.Errorf("%s: wanted the brand update first but got %+v", tc.path, update)
+			}
+			if create.Action != database.AuditCreate || create.Actor.RequestID != "history-test" {
+				t.Errorf("%s: wanted the create from request history-test but got %+v", tc.path, create)
+			}
+		}
+	}
+}
+
+func TestPrices(t *testing.T) {
+	testAPISetup()
+
+	store := database.Audited(database.DB, database.Actor{Name: "tester"})
+	products, errs := store.Put(&models.Product{Name: "Rhubarb", Price: models.NewMoney(450, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	code := products[0].Code
+
+	var (
+		now   = time.Now().UTC()
+		start = now.Add(24 * time.Hour).Format(time.RFC3339)
+		end   = now.Add(8 * 24 * time.Hour).Format(time.RFC3339)
+		sale  = fmt.Sprintf(`{"price": "3.99", "start": %q, "end": %q}`, start, end)
+	)
+
+	var pricesTable = []struct {
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantData string
+	}{
+		{http.MethodPost, "/products/" + code + "/prices", sale, http.StatusOK, `"status":"pending"`},
+		{http.MethodPost, "/products/" + code + "/prices", sale, http.StatusConflict, ""},
+		{http.MethodPost, "/products/" + code + "/prices", `{"price": "3.99"}`, http.StatusBadRequest, `"field":"start"`},
+		{http.MethodPost, "/products/" + code + "/prices", fmt.Sprintf(`{"price": {"amount": 399, "currency": "EUR"}, "start": %q}`, start), http.StatusBadRequest, `"field":"price"`},
+		{http.MethodPost, "/products/" + code + "/prices", `nonsense`, http.StatusBadRequest, ""},
+		{http.MethodPost, "/products/this-isnt-real-code/prices", sale, http.StatusNotFound, ""},
+		{http.MethodGet, "/products/" + code + "/prices", "", http.StatusOK, `"scheduled":[{"id":`},
+		{http.MethodGet, "/products/this-isnt-real-code/prices", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/products/" + code + "?at=" + now.Add(48*time.Hour).Format(time.RFC3339), "", http.StatusOK, `"amount":399`},
+		{http.MethodGet, "/products/" + code + "?at=" + now.Add(9*24*time.Hour).Format(time.RFC3339), "", http.StatusOK, `"amount":450`},
+		{http.MethodGet, "/products/" + code + "?at=2000-01-01T00:00:00Z", "", http.StatusNoContent, ""},
+		{http.MethodGet, "/products/" + code + "?at=tomorrow", "", http.StatusBadRequest, ""},
+		{http.MethodDelete, "/products/" + code + "/prices/99", "", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range pricesTable {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Fatalf("%s %s: expected status code %d but got %d\n", tc.method, tc.path, tc.wantCode, w.Code)
+		}
+		if tc.wantData == "" {
+			continue
+		}
+
+		reader, err := gzip.NewReader(w.Result().Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body [ERR: %s]", err)
+		}
+		body, _ := io.ReadAll(reader)
+		reader.Close()
+
+		if !strings.Contains(string(body), tc.wantData) {
+			t.Errorf("%s %s: wanted %s in %s", tc.method, tc.path, tc.wantData, body)
+		}
+	}
+
+	scheduled := database.Prices.Scheduled(code)
+	if len(scheduled) != 1 {
+		t.Fatalf("wanted one scheduled price but got %d", len(scheduled))
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/products/%s/prices/%d", code, scheduled[0].ID), nil)
+	w := httptest.NewRecorder()
+
+	serve(w, req)
+
+	if w.Code != http.StatusOK || database.Prices.Scheduled(code)[0].Status != database.PriceCancelled {
+		t.Errorf("wanted the sale cancelled but got status code %d", w.Code)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	testAPISetup()
+
+	reader, _, err := auth.Keys.Create("shelf scanner", auth.RoleReader)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+	admin, _, err := auth.Keys.Create("ops", auth.RoleAdmin)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+
+	var authTable = []struct {
+		method   string
+		path     string
+		key      string
+		body     string
+		wantCode int
+	}{
+		{http.MethodGet, "/products/search?keyword=apple", "", "", http.StatusOK},
+		{http.MethodGet, "/products/search?keyword=apple", reader, "", http.StatusOK},
+		{http.MethodGet, "/products/search?keyword=apple", "gk_nonsense", "", http.StatusUnauthorized},
+		{http.MethodPost, "/products", "", `[{"name": "Jackfruit"}]`, http.StatusUnauthorized},
+		{http.MethodPost, "/products", reader, `[{"name": "Jackfruit"}]`, http.StatusForbidden},
+		{http.MethodDelete, "/products/this-isnt-real-code", reader, "", http.StatusForbidden},
+		{http.MethodDelete, "/products/this-isnt-real-code", admin, "", http.StatusNotFound},
+		{http.MethodGet, "/audit", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/audit", reader, "", http.StatusForbidden},
+		{http.MethodGet, "/audit", _testKey, "", http.StatusOK},
+		{http.MethodGet, "/products/" + database.DummyData[0].Code + "/history", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/products/" + database.DummyData[0].Code + "/history", reader, "", http.StatusForbidden},
+		{http.MethodGet, "/products/trash", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/products/trash", _testKey, "", http.StatusOK},
+		{http.MethodGet, "/admin/keys", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/keys", _testKey, "", http.StatusForbidden},
+		{http.MethodGet, "/admin/keys", admin, "", http.StatusOK},
+		{http.MethodPost, "/admin/keys", admin, `{"name": "till 7", "role": "owner"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/keys/missing", admin, "", http.StatusNotFound},
+	}
+
+	for _, tc := range authTable {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		w := httptest.NewRecorder()
+
+		server.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%s %s: expected status code %d but got %d\n", tc.method, tc.path, tc.wantCode, w.Code)
+		}
+	}
+
+	// a key made through the admin routes can write, and is named in the
+	// audit trail, until it is revoked
+	req, _ := http.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(`{"name": "till 7", "role": "manager"}`))
+	req.Header.Set("X-API-Key", admin)
+	w := httptest.NewRecorder()
+
+	server.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+
+	gz, err := gzip.NewReader(w.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read gzip body [ERR: %s]", err)
+	}
+
+	var msg struct {
+		Data struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+			Hash   string `json:"hash"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(gz).Decode(&msg)
+	gz.Close()
+	if err != nil {
+		t.Fatalf("failed decoding response body [ERR: %s]", err)
+	}
+	if msg.Data.Secret == "" || msg.Data.Hash != "" {
+		t.Fatalf("wanted the new key without its hash but got %+v", msg.Data)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/products", strings.NewReader(`[{"name": "Jackfruit"}]`))
+	req.Header.Set("X-API-Key", msg.Data.Secret)
+	w = httptest.NewRecorder()
+
+	server.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+	created, _ := database.Audit.Entries(database.AuditFilter{Action: database.AuditCreate, Limit: 1})
+	if len(created) != 1 || created[0].Actor.Name != "till 7" {
+		t.Errorf("wanted the product created by till 7 in the audit trail but got %+v", created)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "/admin/keys/"+msg.Data.ID, nil)
+	req.Header.Set("X-API-Key", admin)
+	w = httptest.NewRecorder()
+
+	server.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d\n", http.StatusOK, w.Code)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/products", nil)
+	req.Header.Set("X-API-Key", msg.Data.Secret)
+	w = httptest.NewRecorder()
+
+	server.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d for a revoked key but got %d\n", http.StatusUnauthorized, w.Code)
+	}
+
+	for _, item := range database.DB.Search("jackfruit") {
+		database.DB.Del(item.Product.Code, 0)
+	}
+}
+
+func TestBearer(t *testing.T) {
+	testAPISetup()
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "oct", "kid": "test", "k": %q}]}`, base64.RawURLEncoding.EncodeToString(secret))
+	if err := os.WriteFile(path, []byte(jwks), 0o644); err != nil {
+		t.Fatalf("failed to write JWKS [ERR: %s]", err)
+	}
+	keys, err := auth.LoadKeySet(path)
+	if err != nil {
+		t.Fatalf("failed to load JWKS [ERR: %s]", err)
+	}
+
+	tokens := auth.Tokens
+	auth.Tokens = &auth.Verifier{Keys: keys, Audience: "grocery"}
+	defer func() { auth.Tokens = tokens }()
+
+	sign := func(claims string) string {
+		signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"test"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	var (
+		writer  = sign(fmt.Sprintf(`{"sub": "pricing", "aud": "grocery", "exp": %d, "scope": "products:write"}`, exp))
+		reader  = sign(fmt.Sprintf(`{"sub": "scanner", "aud": "grocery", "exp": %d, "scp": ["products:read"]}`, exp))
+		auditor = sign(fmt.Sprintf(`{"sub": "auditor", "aud": "grocery", "exp": %d, "scope": "audit:read"}`, exp))
+		expired = sign(fmt.Sprintf(`{"sub": "pricing", "aud": "grocery", "exp": %d, "scope": "products:write"}`, time.Now().Add(-time.Hour).Unix()))
+		billing = sign(fmt.Sprintf(`{"sub": "pricing", "aud": "billing", "exp": %d, "scope": "products:write"}`, exp))
+	)
+
+	var bearerTable = []struct {
+		method        string
+		path          string
+		token         string
+		body          string
+		wantCode      int
+		wantChallenge string
+	}{
+		{http.MethodGet, "/products/search?keyword=apple", reader, "", http.StatusOK, ""},
+		{http.MethodPost, "/products", writer, `[{"name": "Jackfruit"}]`, http.StatusOK, ""},
+		{http.MethodPost, "/products", reader, `[{"name": "Jackfruit"}]`, http.StatusForbidden, "insufficient_scope"},
+		{http.MethodPost, "/stores", writer, `{"name": "Dockside"}`, http.StatusForbidden, "insufficient_scope"},
+		{http.MethodGet, "/admin/keys", writer, "", http.StatusForbidden, "insufficient_scope"},
+		{http.MethodGet, "/audit", writer, "", http.StatusForbidden, "insufficient_scope"},
+		{http.MethodGet, "/products/" + database.DummyData[0].Code + "/history", reader, "", http.StatusForbidden, "insufficient_scope"},
+		{http.MethodGet, "/products/" + database.DummyData[0].Code + "/history", auditor, "", http.StatusOK, ""},
+		{http.MethodGet, "/products/trash", auditor, "", http.StatusOK, ""},
+		{http.MethodPost, "/products", expired, `[{"name": "Jackfruit"}]`, http.StatusUnauthorized, "invalid_token"},
+		{http.MethodPost, "/products", billing, `[{"name": "Jackfruit"}]`, http.StatusUnauthorized, "invalid_token"},
+		{http.MethodGet, "/products", writer + "x", "", http.StatusUnauthorized, "invalid_token"},
+	}
+
+	for _, tc := range bearerTable {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+
+		server.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%s %s: expected status code %d but got %d\n", tc.method, tc.path, tc.wantCode, w.Code)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, tc.wantChallenge) || (tc.wantChallenge == "") != (challenge == "") {
+			t.Errorf("%s %s: wanted a %q challenge but got %q", tc.method, tc.path, tc.wantChallenge, challenge)
+		}
+	}
+
+	created, _ := database.Audit.Entries(database.AuditFilter{Action: database.AuditCreate, Limit: 1})
+	if len(created) != 1 || created[0].Actor.Name != "pricing" {
+		t.Errorf("wanted the product created by pricing in the audit trail but got %+v", created)
+	}
+
+	for _, item := range database.DB.Search("jackfruit") {
+		database.DB.Del(item.Product.Code, 0)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	testAPISetup()
+
+	secret, key, err := auth.Keys.Create("kiosk", auth.RoleReader)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+	config.KEYRATELIMITS[key.ID] = config.RatePolicy{Rate: 0.5, Burst: 2}
+	defer delete(config.KEYRATELIMITS, key.ID)
+
+	var rateTable = []struct {
+		wantCode       int
+		wantRemaining  string
+		wantRetryAfter string
+	}{
+		{http.StatusOK, "1", ""},
+		{http.StatusOK, "0", ""},
+		{http.StatusTooManyRequests, "0", "2"},
+	}
+
+	for i, tc := range rateTable {
+		req, _ := http.NewRequest(http.MethodGet, "/products", nil)
+		req.Header.Set("X-API-Key", secret)
+		w := httptest.NewRecorder()
+
+		server.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%d: expected status code %d but got %d\n", i, tc.wantCode, w.Code)
+		}
+		if limit := w.Header().Get("RateLimit-Limit"); limit != "2" {
+			t.Errorf("%d: wanted a limit of 2 but got %q", i, limit)
+		}
+		if remaining := w.Header().Get("RateLimit-Remaining"); remaining != tc.wantRemaining {
+			t.Errorf("%d: wanted %s remaining but got %q", i, tc.wantRemaining, remaining)
+		}
+		if retry := w.Header().Get("Retry-After"); retry != tc.wantRetryAfter {
+			t.Errorf("%d: wanted to retry after %q but got %q", i, tc.wantRetryAfter, retry)
+		}
+	}
+
+	// the tests' own key is unlimited, except on a route with a policy
+	config.ROUTERATELIMITS["/stores"] = config.RatePolicy{Rate: 0.5, Burst: 1}
+	defer delete(config.ROUTERATELIMITS, "/stores")
+
+	var routeTable = []struct {
+		path      string
+		wantCode  int
+		wantLimit string
+	}{
+		{"/products", http.StatusOK, ""},
+		{"/stores", http.StatusOK, "1"},
+		{"/stores", http.StatusTooManyRequests, "1"},
+		{"/products", http.StatusOK, ""},
+	}
+
+	for _, tc := range routeTable {
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		w := httptest.NewRecorder()
+
+		serve(w, req)
+
+		if w.Code != tc.wantCode || w.Header().Get("RateLimit-Limit") != tc.wantLimit {
+			t.Errorf("%s: expected status code %d and limit %q but got %d and %q\n", tc.path, tc.wantCode, tc.wantLimit, w.Code, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+}
+
+func TestRateLimitAddress(t *testing.T) {
+	testAPISetup()
+
+	config.ADDRESSRATELIMIT = config.RatePolicy{Rate: 0.5, Burst: 3}
+	defer func() { config.ADDRESSRATELIMIT = config.RatePolicy{} }()
+
+	// credentials that fail to authenticate still spend the address's tokens
+	var addressTable = []struct {
+		key, token string
+		wantCode   int
+	}{
+		{"gk_nonsense", "", http.StatusUnauthorized},
+		{"", "not.a.token", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+		{"gk_nonsense", "", http.StatusTooManyRequests},
+		{_testKey, "", http.StatusTooManyRequests},
+	}
+
+	for i, tc := range addressTable {
+		req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`[{"name": "Jackfruit"}]`))
+		req.RemoteAddr = "203.0.113.9:40000"
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+
+		server.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.wantCode {
+			t.Errorf("%d: expected status code %d but got %d\n", i, tc.wantCode, w.Code)
+		}
+	}
+
+	// other addresses have their own buckets
+	req := httptest.NewRequest(http.MethodGet, "/products", nil)
+	req.RemoteAddr = "203.0.113.10:40000"
+	w := httptest.NewRecorder()
+
+	server.Router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status code %d from another address but got %d\n", http.StatusOK, w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	testAPISetup()
+
+	limit := config.RATELIMIT
+	config.RATELIMIT = config.RatePolicy{Rate: 0.5, Burst: 1}
+	defer func() { config.RATELIMIT = limit }()
+
+	// without trusted proxies a forwarded address can't dodge the limit
+	for i, wantCode := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/products", nil)
+		req.RemoteAddr = "[2001:db8::24]:41234"
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		w := httptest.NewRecorder()
+
+		server.Router.ServeHTTP(w, req)
+
+		if w.Code != wantCode {
+			t.Errorf("%d: expected status code %d but got %d\n", i, wantCode, w.Code)
 		}
 	}
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"grocery/database"
+
+	"github.com/gocraft/web"
+)
+
+// store returns the database with writes recorded in the audit log as made
+// by the client of this request, named after its API key.
+func (api *GroceryAPI) store() database.Store {
+	actor := database.Actor{
+		Name:      "anonymous",
+		Address:   api.ClientIP,
+		RequestID: api.RequestID,
+	}
+	if api.Principal != nil {
+		actor.Name = api.Principal.Name
+	}
+
+	return database.Audited(database.DB, actor)
+}
+
+// History lists the changes made to a product, newest first. Products that
+// have been purged keep their history.
+func (api *GroceryAPI) History(rw web.ResponseWriter, req *web.Request) {
+	code := req.PathParams["id"]
+
+	entries := database.Audit.History(code)
+	if len(entries) == 0 && database.DB.Get(code) == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, entries)
+}
+
+// Audit pages through the changes made to the catalog, newest first. It
+// accepts since and until (RFC 3339 times bounding the range, until being
+// exclusive), code, action, actor, limit and before (the cursor from the
+// previous page).
+func (api *GroceryAPI) Audit(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+
+	filter := database.AuditFilter{
+		Code:   query.Get("code"),
+		Action: query.Get("action"),
+		Actor:  query.Get("actor"),
+	}
+
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := query.Get(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				api.Respond(rw, http.StatusBadRequest, "invalid "+bound.name)
+				return
+			}
+			*bound.t = t
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > database.MaxAuditLimit {
+			api.Respond(rw, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		filter.Limit = n
+	}
+
+	if before := query.Get("before"); before != "" {
+		id, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || id < 1 {
+			api.Respond(rw, http.StatusBadRequest, "invalid before")
+			return
+		}
+		filter.Before = id
+	}
+
+	entries, more := database.Audit.Entries(filter)
+
+	var next string
+	if more {
+		next = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+	}
+
+	api.RespondPage(rw, http.StatusOK, _successfulMsg, next, entries)
+}
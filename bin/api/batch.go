@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"grocery/database"
+
+	"github.com/gocraft/web"
+)
+
+// Batch applies a JSON array of create, update and delete operations
+// atomically. The response lists the outcome of every operation in order;
+// if any fails nothing is changed and the status reflects the first
+// failure, as it would for the single-product endpoint.
+func (api *GroceryAPI) Batch(rw web.ResponseWriter, req *web.Request) {
+	var ops []*database.BatchOp
+	if err := json.NewDecoder(req.Body).Decode(&ops); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid batch data")
+		return
+	}
+
+	results, err := api.store().Batch(ops...)
+	switch {
+	case err == nil:
+		api.Respond(rw, http.StatusOK, _successfulMsg, results)
+	case errors.Is(err, database.ErrNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error(), results)
+	case errors.Is(err, database.ErrVersionMismatch):
+		api.Respond(rw, http.StatusPreconditionFailed, err.Error(), results)
+	case errors.Is(err, database.ErrInvalidProduct), errors.Is(err, database.ErrInvalidBatch):
+		api.Respond(rw, http.StatusBadRequest, err.Error(), results)
+	default:
+		log.Printf("error applying batch [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to apply batch")
+	}
+}
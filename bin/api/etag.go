@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+// etag is the strong entity tag for the current version of a product.
+func etag(product *models.Product) string {
+	return fmt.Sprintf(`"%d"`, product.Version)
+}
+
+// ifMatchVersion evaluates the request's If-Match header against current,
+// which is nil when the product doesn't exist. It returns the version the
+// write should be made conditional on (zero when there is no header) and
+// false when the precondition has already failed. If-Match uses the strong
+// comparison, so weak tags never match.
+func ifMatchVersion(req *web.Request, current *models.Product) (int64, bool) {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	if current == nil {
+		return 0, false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return 0, true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(current) {
+			return current.Version, true
+		}
+	}
+
+	return 0, false
+}
+
+// ifNoneMatch reports whether the request's If-None-Match header matches
+// current, using the weak comparison.
+func ifNoneMatch(req *web.Request, current *models.Product) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" || current == nil {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag(current) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"grocery/database"
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+var (
+	// exportTypes are the media types an export can be served as, in order
+	// of preference when the client accepts several equally.
+	exportTypes = []struct {
+		mediaType string
+		format    string
+	}{
+		{"application/json", database.ExportJSON},
+		{"text/csv", database.ExportCSV},
+		{"application/x-ndjson", database.ExportNDJSON},
+		{"application/ndjson", database.ExportNDJSON},
+	}
+)
+
+// Export streams the catalog as CSV, NDJSON or a JSON array, chosen by the
+// format parameter or else the Accept header. Given keyword, q or in_stock
+// it exports the search result instead of the whole catalog. The body is
+// gzipped when the client accepts it.
+func (api *GroceryAPI) Export(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+
+	mediaType, format := negotiateExport(req.Header.Get("Accept"))
+	if f := query.Get("format"); f != "" {
+		mediaType, format = "", ""
+		for _, t := range exportTypes {
+			if t.format == f {
+				mediaType, format = t.mediaType, t.format
+				break
+			}
+		}
+		if format == "" {
+			api.Respond(rw, http.StatusBadRequest, "invalid format")
+			return
+		}
+	}
+	if format == "" {
+		api.Respond(rw, http.StatusNotAcceptable, "export is available as application/json, text/csv or application/x-ndjson")
+		return
+	}
+
+	// the whole catalog is read as it is written out
+	products := database.Products(database.DB.Each)
+	if query.Get("keyword") != "" || query.Get("q") != "" || query.Get("in_stock") != "" {
+		matches, err := search(query)
+		if err != nil {
+			api.invalidSearch(rw, err)
+			return
+		}
+		items := make([]*models.Product, len(matches))
+		for i, match := range matches {
+			items[i] = match.Product
+		}
+		products = database.ProductsOf(items)
+	}
+
+	log.Printf("exporting products as %s", format)
+
+	if format == database.ExportCSV {
+		mediaType += "; charset=utf-8"
+	}
+	rw.Header().Set("Content-Type", mediaType)
+	rw.Header().Set("Content-Disposition", `attachment; filename="products.`+format+`"`)
+	rw.Header().Set("Vary", "Accept, Accept-Encoding")
+
+	var w io.Writer = rw
+	if acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		rw.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(rw)
+		defer gz.Close()
+		w = gz
+	}
+
+	rw.WriteHeader(http.StatusOK)
+
+	if err := database.Export(w, format, products); err != nil {
+		log.Printf("error exporting products [ERR: %s]", err)
+	}
+}
+
+// negotiateExport picks the export format the Accept header ranks highest,
+// returning empty strings when none is acceptable. No header means JSON.
+func negotiateExport(accept string) (mediaType, format string) {
+	if strings.TrimSpace(accept) == "" {
+		return exportTypes[0].mediaType, exportTypes[0].format
+	}
+
+	best := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q <= best {
+			continue
+		}
+
+		for _, t := range exportTypes {
+			if accepted == "*/*" || accepted == t.mediaType ||
+				(strings.HasSuffix(accepted, "/*") && strings.HasPrefix(t.mediaType, strings.TrimSuffix(accepted, "*"))) {
+				mediaType, format, best = t.mediaType, t.format, q
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, named
+// or through "*", with a q-value above zero.
+func acceptsGzip(acceptEncoding string) bool {
+	named, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		switch coding {
+		case "gzip", "x-gzip":
+			named = max(named, q)
+		case "*":
+			wildcard = max(wildcard, q)
+		}
+	}
+
+	if named >= 0 {
+		return named > 0
+	}
+
+	return wildcard > 0
+}
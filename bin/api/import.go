@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+
+	"grocery/database"
+
+	"github.com/gocraft/web"
+)
+
+var (
+	// importTypes maps the media types an import accepts to its format.
+	importTypes = map[string]string{
+		"text/csv":             database.ImportCSV,
+		"application/csv":      database.ImportCSV,
+		"application/x-ndjson": database.ImportNDJSON,
+		"application/ndjson":   database.ImportNDJSON,
+		"application/jsonl":    database.ImportNDJSON,
+	}
+)
+
+// Import creates products from a CSV or newline-delimited JSON body, chosen
+// by the format parameter or else the Content-Type. mode=atomic stores all
+// rows or none; the default, best-effort, stores the valid rows. The
+// response carries a report with the outcome of each row, up to
+// database.ImportMaxRows, or of the rows read before the body reached its
+// limit in config.ROUTEBODYLIMITS.
+func (api *GroceryAPI) Import(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if format = importTypes[mediaType]; format == "" {
+			api.Respond(rw, http.StatusUnsupportedMediaType, "import must be text/csv or application/x-ndjson")
+			return
+		}
+	}
+
+	report, err := database.Import(api.store(), req.Body, format, query.Get("mode"))
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, database.ErrInvalidImport):
+		api.Respond(rw, http.StatusBadRequest, err.Error())
+	case errors.As(err, &tooLarge):
+		api.Respond(rw, http.StatusRequestEntityTooLarge, fmt.Sprintf("import is larger than %d bytes", tooLarge.Limit), report)
+	case err != nil:
+		log.Printf("error importing products [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to import products", report)
+	case report.Mode == database.ImportAtomic && report.Rejected > 0:
+		api.Respond(rw, http.StatusBadRequest, "import rejected, no products created", report)
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg, report)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"grocery/auth"
+
+	"github.com/gocraft/web"
+)
+
+type (
+	createKeyRequest struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+
+	createKeyResponse struct {
+		*auth.Key
+		// Secret is the key itself, shown only when it is created.
+		Secret string `json:"secret"`
+	}
+)
+
+// ListKeys lists the API keys, revoked ones included. Keys are never shown
+// after they are created.
+func (api *GroceryAPI) ListKeys(rw web.ResponseWriter, req *web.Request) {
+	api.Respond(rw, http.StatusOK, _successfulMsg, auth.Keys.List())
+}
+
+// CreateKey makes an API key from {"name": ..., "role": ...}, the role
+// being reader, manager or admin.
+func (api *GroceryAPI) CreateKey(rw web.ResponseWriter, req *web.Request) {
+	var body createKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid key data")
+		return
+	}
+
+	secret, key, err := auth.Keys.Create(body.Name, body.Role)
+	switch {
+	case errors.Is(err, auth.ErrInvalidKey):
+		api.Respond(rw, http.StatusBadRequest, err.Error())
+	case err != nil:
+		log.Printf("error creating API key [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to create key")
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg, &createKeyResponse{Key: key, Secret: secret})
+	}
+}
+
+// RevokeKey stops an API key from being used.
+func (api *GroceryAPI) RevokeKey(rw web.ResponseWriter, req *web.Request) {
+	err := auth.Keys.Revoke(req.PathParams["key"])
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error())
+	case err != nil:
+		log.Printf("error revoking API key [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to revoke key")
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grocery/database"
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+type (
+	pricesResponse struct {
+		History   []*database.PricePoint     `json:"history"`
+		Scheduled []*database.ScheduledPrice `json:"scheduled"`
+	}
+
+	scheduleRequest struct {
+		Price models.Money `json:"price"`
+		Start time.Time    `json:"start"`
+		End   *time.Time   `json:"end"`
+	}
+)
+
+// Prices shows the prices a product has had, oldest first, and those
+// scheduled for it.
+func (api *GroceryAPI) Prices(rw web.ResponseWriter, req *web.Request) {
+	code := req.PathParams["id"]
+
+	product := database.DB.Get(code)
+	history := database.PriceHistory(code)
+	scheduled := database.Prices.Scheduled(code)
+
+	if product == nil && len(history) == 0 && len(scheduled) == 0 {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+	if product != nil && len(history) == 0 {
+		// unchanged since before the audit log began
+		history = append(history, &database.PricePoint{Price: product.Price})
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, &pricesResponse{History: history, Scheduled: scheduled})
+}
+
+// SchedulePrice sets a product's price from start until end, when the
+// price it replaced comes back, or for good without an end. The body is
+// {"price": ..., "start": ..., "end": ...} with RFC 3339 times; the price
+// must be in the product's currency, which it may leave out.
+func (api *GroceryAPI) SchedulePrice(rw web.ResponseWriter, req *web.Request) {
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	var body scheduleRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid price schedule")
+		return
+	}
+	if body.Price.Currency == "" {
+		body.Price.Currency = product.Price.Currency
+	}
+
+	scheduled, err := database.Prices.Schedule(product.Code, product.Price.Currency, body.Price, body.Start, body.End)
+
+	var fieldErr *database.FieldError
+	switch {
+	case errors.Is(err, database.ErrPriceOverlap):
+		api.Respond(rw, http.StatusConflict, err.Error())
+	case errors.As(err, &fieldErr):
+		api.invalid(rw, err)
+	case err != nil:
+		log.Printf("error scheduling price of %s [ERR: %s]", product.Code, err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to schedule price")
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg, scheduled)
+	}
+}
+
+// CancelPrice drops a scheduled price, or ends it now if it is in effect.
+func (api *GroceryAPI) CancelPrice(rw web.ResponseWriter, req *web.Request) {
+	id, err := strconv.ParseInt(req.PathParams["price"], 10, 64)
+	if err != nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrPriceNotFound.Error())
+		return
+	}
+
+	err = database.Prices.Cancel(req.PathParams["id"], id)
+	switch {
+	case errors.Is(err, database.ErrPriceNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrPriceDone):
+		api.Respond(rw, http.StatusConflict, err.Error())
+	case err != nil:
+		log.Printf("error cancelling scheduled price %d [ERR: %s]", id, err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to cancel scheduled price")
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg)
+	}
+}
+
+// getAt responds with a product as it stood at a past time, going by the
+// audit log, or as it will be at a future time, going by the price
+// schedule.
+func (api *GroceryAPI) getAt(rw web.ResponseWriter, code, at string) {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid at")
+		return
+	}
+
+	var product *models.Product
+	if t.After(time.Now()) {
+		if product = database.DB.Get(code); product != nil {
+			view := *product
+			view.Price = database.Prices.PriceAt(code, t, product.Price)
+			product = &view
+		}
+	} else {
+		var ok bool
+		if product, ok = database.Audit.At(code, t); !ok {
+			product = database.DB.Get(code)
+		}
+	}
+
+	if product == nil {
+		api.Respond(rw, http.StatusNoContent, _successfulMsg)
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, product)
+}
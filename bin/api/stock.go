@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"grocery/database"
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+type (
+	// stockRequest is the body of POST /products/:id/stock.
+	stockRequest struct {
+		Op       string `json:"op"`
+		Quantity int64  `json:"quantity"`
+		Reason   string `json:"reason"`
+	}
+
+	stockResponse struct {
+		database.StockLevel
+		Movements []*database.StockMovement `json:"movements,omitempty"`
+	}
+)
+
+// GetStock returns the chain-wide quantity on hand for a product along with
+// its recent stock movements.
+func (api *GroceryAPI) GetStock(rw web.ResponseWriter, req *web.Request) {
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	api.getStock(rw, database.Inventory, product)
+}
+
+// MoveStock receives, sells or adjusts the chain-wide stock of a product.
+func (api *GroceryAPI) MoveStock(rw web.ResponseWriter, req *web.Request) {
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	api.moveStock(rw, req, database.Inventory, product)
+}
+
+func (api *GroceryAPI) getStock(rw web.ResponseWriter, stock *database.Stock, product *models.Product) {
+	api.Respond(rw, http.StatusOK, _successfulMsg, &stockResponse{
+		StockLevel: stock.Level(product.Code),
+		Movements:  stock.Movements(product.Code),
+	})
+}
+
+// moveStock applies the movement in the request body to stock. Sales that
+// would take stock below zero are refused with 409 unless back-orders are
+// enabled.
+func (api *GroceryAPI) moveStock(rw web.ResponseWriter, req *web.Request, stock *database.Stock, product *models.Product) {
+	var move stockRequest
+	if err := json.NewDecoder(req.Body).Decode(&move); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid stock data")
+		return
+	}
+
+	var (
+		level database.StockLevel
+		err   error
+	)
+
+	switch move.Op {
+	case database.StockReceive:
+		level, err = stock.Receive(product.Code, move.Quantity, move.Reason)
+	case database.StockSell:
+		level, err = stock.Sell(product.Code, move.Quantity, move.Reason)
+	case database.StockAdjust:
+		level, err = stock.Adjust(product.Code, move.Quantity, move.Reason)
+	default:
+		api.Respond(rw, http.StatusBadRequest, "op must be receive, sell or adjust")
+		return
+	}
+
+	switch {
+	case errors.Is(err, database.ErrInsufficientStock):
+		api.Respond(rw, http.StatusConflict, err.Error(), level)
+	case errors.Is(err, database.ErrInvalidMovement):
+		api.Respond(rw, http.StatusBadRequest, err.Error())
+	case err != nil:
+		api.Respond(rw, http.StatusInternalServerError, "unable to move stock")
+		log.Printf("error moving stock of %s [ERR: %s]", product.Code, err)
+	default:
+		api.Respond(rw, http.StatusOK, _successfulMsg, level)
+	}
+}
+
+func filterInStock(matches []*database.Match) (inStock []*database.Match) {
+	for _, match := range matches {
+		if database.Inventory.InStock(match.Code) {
+			inStock = append(inStock, match)
+		}
+	}
+
+	return
+}
+
+// parseBool parses an optional boolean query parameter.
+func parseBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(s)
+}
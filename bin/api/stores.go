@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"grocery/database"
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+func (api *GroceryAPI) ListStores(rw web.ResponseWriter, req *web.Request) {
+	api.Respond(rw, http.StatusOK, _successfulMsg, database.Stores.ListStores())
+}
+
+func (api *GroceryAPI) GetStore(rw web.ResponseWriter, req *web.Request) {
+	if store := database.Stores.GetStore(req.PathParams["store"]); store != nil {
+		api.Respond(rw, http.StatusOK, _successfulMsg, store)
+		return
+	}
+
+	api.Respond(rw, http.StatusNotFound, database.ErrStoreNotFound.Error())
+}
+
+func (api *GroceryAPI) CreateStore(rw web.ResponseWriter, req *web.Request) {
+	var store *models.Store
+	if err := json.NewDecoder(req.Body).Decode(&store); err != nil || store == nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid store data")
+		return
+	}
+
+	err := database.Stores.CreateStore(store)
+	switch {
+	case errors.Is(err, database.ErrStoreExists):
+		api.Respond(rw, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		api.storeError(rw, err)
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, store)
+}
+
+// ReplaceStore updates the name and address of an existing store.
+func (api *GroceryAPI) ReplaceStore(rw web.ResponseWriter, req *web.Request) {
+	id := req.PathParams["store"]
+	if database.Stores.GetStore(id) == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrStoreNotFound.Error())
+		return
+	}
+
+	var store *models.Store
+	if err := json.NewDecoder(req.Body).Decode(&store); err != nil || store == nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid store data")
+		return
+	}
+	store.ID = id
+
+	api.putStore(rw, store)
+}
+
+func (api *GroceryAPI) putStore(rw web.ResponseWriter, store *models.Store) {
+	if err := database.Stores.PutStore(store); err != nil {
+		api.storeError(rw, err)
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, store)
+}
+
+func (api *GroceryAPI) DeleteStore(rw web.ResponseWriter, req *web.Request) {
+	if err := database.Stores.DelStore(req.PathParams["store"]); err != nil {
+		api.storeError(rw, err)
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg)
+}
+
+// storeError responds to a failed store directory change: 404 for a missing
+// store or price, 400 for invalid input and 500 when it couldn't be saved.
+func (api *GroceryAPI) storeError(rw web.ResponseWriter, err error) {
+	var fieldErr *database.FieldError
+	switch {
+	case errors.Is(err, database.ErrStoreNotFound), errors.Is(err, database.ErrStorePriceNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrInvalidStore), errors.As(err, &fieldErr):
+		api.invalid(rw, err)
+	default:
+		log.Printf("error updating store directory [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to update store")
+	}
+}
+
+// GetStoreProduct returns a product with the store's price, falling back to
+// the product's base price, and the store's stock on hand.
+func (api *GroceryAPI) GetStoreProduct(rw web.ResponseWriter, req *web.Request) {
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	view, err := database.Stores.Product(req.PathParams["store"], product)
+	if err != nil {
+		api.Respond(rw, http.StatusNotFound, err.Error())
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, view)
+}
+
+// SetStorePrice overrides a product's price at one store. The body is a
+// price in any form models.Money accepts, e.g. "2.49".
+func (api *GroceryAPI) SetStorePrice(rw web.ResponseWriter, req *web.Request) {
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return
+	}
+
+	var price models.Money
+	if err := json.NewDecoder(req.Body).Decode(&price); err != nil {
+		api.Respond(rw, http.StatusBadRequest, "invalid price")
+		return
+	}
+
+	if err := database.Stores.SetPrice(req.PathParams["store"], product.Code, product.Price.Currency, price); err != nil {
+		api.storeError(rw, err)
+		return
+	}
+
+	view, _ := database.Stores.Product(req.PathParams["store"], product)
+	api.Respond(rw, http.StatusOK, _successfulMsg, view)
+}
+
+// ClearStorePrice drops a store's price override for a product.
+func (api *GroceryAPI) ClearStorePrice(rw web.ResponseWriter, req *web.Request) {
+	if err := database.Stores.ClearPrice(req.PathParams["store"], req.PathParams["id"]); err != nil {
+		api.storeError(rw, err)
+		return
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg)
+}
+
+func (api *GroceryAPI) GetStoreStock(rw web.ResponseWriter, req *web.Request) {
+	if stock, product := api.storeStock(rw, req); stock != nil {
+		api.getStock(rw, stock, product)
+	}
+}
+
+func (api *GroceryAPI) MoveStoreStock(rw web.ResponseWriter, req *web.Request) {
+	if stock, product := api.storeStock(rw, req); stock != nil {
+		api.moveStock(rw, req, stock, product)
+	}
+}
+
+// storeStock looks up the store and product named in the path, responding
+// with 404 and returning nils when either is missing.
+func (api *GroceryAPI) storeStock(rw web.ResponseWriter, req *web.Request) (*database.Stock, *models.Product) {
+	stock := database.Stores.Stock(req.PathParams["store"])
+	if stock == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrStoreNotFound.Error())
+		return nil, nil
+	}
+
+	product := database.DB.Get(req.PathParams["id"])
+	if product == nil {
+		api.Respond(rw, http.StatusNotFound, database.ErrNotFound.Error())
+		return nil, nil
+	}
+
+	return stock, product
+}
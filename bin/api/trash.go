@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"grocery/database"
+	"grocery/models"
+
+	"github.com/gocraft/web"
+)
+
+// Trash lists the deleted products that can still be restored, most
+// recently deleted first.
+func (api *GroceryAPI) Trash(rw web.ResponseWriter, req *web.Request) {
+	items := database.DB.Trash()
+	if items == nil {
+		items = []*models.Product{}
+	}
+
+	api.Respond(rw, http.StatusOK, _successfulMsg, items)
+}
+
+// Restore brings a product back from the trash. An If-Match header makes
+// the restore conditional on the trashed product's ETag.
+func (api *GroceryAPI) Restore(rw web.ResponseWriter, req *web.Request) {
+	code := req.PathParams["id"]
+
+	var existing *models.Product
+	for _, item := range database.DB.Trash() {
+		if strings.EqualFold(item.Code, code) {
+			existing = item
+			break
+		}
+	}
+
+	version, ok := ifMatchVersion(req, existing)
+	if !ok {
+		api.Respond(rw, http.StatusPreconditionFailed, database.ErrVersionMismatch.Error())
+		return
+	}
+
+	item, err := api.store().Restore(code, version)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		api.Respond(rw, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrVersionMismatch):
+		api.Respond(rw, http.StatusPreconditionFailed, err.Error())
+	case err != nil:
+		log.Printf("error restoring product [ERR: %s]", err)
+		api.Respond(rw, http.StatusInternalServerError, "unable to restore product")
+	default:
+		rw.Header().Set("ETag", etag(item))
+		api.Respond(rw, http.StatusOK, _successfulMsg, item)
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"flag"
+	"io"
 	"log"
 	"os/signal"
 	"syscall"
 
 	"api"
+	"grocery/auth"
+	"grocery/cache"
+	"grocery/config"
 	"grocery/database"
+	"grocery/server"
 	"grocery/shared"
 )
 
@@ -23,10 +28,21 @@ func main() {
 		shared.SetDebug()
 	}
 
+	// the store and the keys are in place before the server takes requests
+	database.Connect()
+	auth.Connect()
+
 	s := api.NewGroceryAPI()
 	go s.Run()
+	go cache.RunJanitor(server.RateLimitCache(), config.CACHEJANITORINTERVAL, shared.ShutdownChan)
 
-	database.Connect()
+	if auth.Tokens != nil {
+		go auth.RunKeySetReloader(auth.Tokens.Keys, config.JWKSRELOADINTERVAL, shared.ShutdownChan)
+	}
+	sweeper := database.Audited(database.DB, database.Actor{Name: "sweeper"})
+	go database.RunSweeper(sweeper, config.TRASHRETENTION, config.TRASHSWEEPINTERVAL, shared.ShutdownChan)
+	scheduler := database.Audited(database.DB, database.Actor{Name: "scheduler"})
+	go database.RunPriceScheduler(scheduler, config.PRICESCHEDULEINTERVAL, shared.ShutdownChan)
 
 	//setup signal handling to respond to ctrl-c
 	signal.Notify(
@@ -41,5 +57,14 @@ func main() {
 	close(shared.ShutdownChan)
 
 	s.ShutDown()
+
+	if closer, ok := database.DB.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("closing database [ERR: %s]", err)
+		}
+	}
+	if err := database.Audit.Close(); err != nil {
+		log.Printf("closing audit log [ERR: %s]", err)
+	}
 	log.Println("DONE")
 }
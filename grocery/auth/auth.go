@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"grocery/config"
+)
+
+const (
+	RoleReader  = "reader"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+
+	// ScopeRead and ScopeWrite qualify a resource in a token scope, as in
+	// products:write.
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// API keys read gk_<id>_<secret>; the ID finds the key and the whole
+	// key is checked against its hash.
+	_keyPrefix = "gk_"
+)
+
+var (
+	Keys *KeyStore
+
+	// Roles are the roles a key can have, each allowed everything the ones
+	// before it are.
+	Roles = []string{RoleReader, RoleManager, RoleAdmin}
+
+	ErrKeyNotFound = errors.New("API key not found")
+	ErrInvalidKey  = errors.New("invalid API key")
+)
+
+type (
+	// Principal is who a request is made on behalf of: the holder of an API
+	// key, with its role, or the subject of a bearer token, with its scopes.
+	Principal struct {
+		Name   string   `json:"name"`
+		Role   string   `json:"role,omitempty"`
+		KeyID  string   `json:"key_id,omitempty"`
+		Scopes []string `json:"scopes,omitempty"`
+	}
+
+	// Key is an API key. Only a hash of the key itself is kept.
+	Key struct {
+		ID      string     `json:"id"`
+		Name    string     `json:"name"`
+		Role    string     `json:"role"`
+		Hash    string     `json:"hash,omitempty"`
+		Created time.Time  `json:"created"`
+		Revoked *time.Time `json:"revoked,omitempty"`
+	}
+
+	// KeyStore holds the API keys, in memory and, when opened from a file,
+	// saved to it on every change.
+	KeyStore struct {
+		sync.RWMutex
+
+		keys []*Key
+		path string
+	}
+)
+
+// Connect opens the key store the first time it is called: in memory
+// alongside the memory database driver, in config.KEYFILE otherwise. A store
+// without keys gets an admin key, shown once by showAdminKey, to create the
+// rest with. With config.JWKSFILE set it also loads the keys that verify
+// bearer tokens.
+func Connect() *KeyStore {
+	if Keys != nil {
+		return Keys
+	}
+
+	if config.JWKSFILE != "" {
+		keys, err := LoadKeySet(config.JWKSFILE)
+		if err != nil {
+			log.Fatalf("loading JWKS [ERR: %s]", err)
+		}
+		Tokens = &Verifier{
+			Keys:     keys,
+			Audience: config.JWTAUDIENCE,
+			Issuer:   config.JWTISSUER,
+			Leeway:   config.JWTLEEWAY,
+		}
+	}
+
+	store := NewKeyStore()
+	if config.DBDRIVER != "memory" {
+		var err error
+		if store, err = OpenKeyStore(filepath.Join(config.DBPATH, config.KEYFILE)); err != nil {
+			log.Fatalf("opening API keys [ERR: %s]", err)
+		}
+	}
+
+	if len(store.List()) == 0 {
+		secret, _, err := store.Create("admin", RoleAdmin)
+		if err != nil {
+			log.Fatalf("creating admin API key [ERR: %s]", err)
+		}
+		if err := showAdminKey(store, secret); err != nil {
+			log.Fatalf("saving admin API key [ERR: %s]", err)
+		}
+	}
+
+	Keys = store
+	return Keys
+}
+
+// showAdminKey hands the secret of a new admin key to the operator without
+// it reaching the logs: in config.ADMINKEYFILE, readable only by its owner,
+// beside a key store kept in a file, or else on stderr.
+func showAdminKey(store *KeyStore, secret string) error {
+	if store.path == "" {
+		_, err := fmt.Fprintf(os.Stderr, "admin API key: %s\n", secret)
+		log.Print("created admin API key; its secret was written to stderr and won't be shown again")
+		return err
+	}
+
+	path := filepath.Join(filepath.Dir(store.path), config.ADMINKEYFILE)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := fmt.Fprintln(f, secret); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("created admin API key; its secret is in %s", path)
+	return nil
+}
+
+func NewKeyStore() *KeyStore {
+	return &KeyStore{}
+}
+
+// OpenKeyStore loads the keys saved at path, creating the file on the first
+// change if it doesn't exist.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.keys); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Create adds a key with the given name and role and returns it along with
+// the key itself, which is not kept and can't be had again.
+func (s *KeyStore) Create(name, role string) (secret string, key *Key, err error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", nil, fmt.Errorf("%w: name is required", ErrInvalidKey)
+	case rank(role) < 0:
+		return "", nil, fmt.Errorf("%w: role must be one of %s", ErrInvalidKey, strings.Join(Roles, ", "))
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	secret = _keyPrefix + id + "_" + token
+
+	key = &Key{
+		ID:      id,
+		Name:    name,
+		Role:    role,
+		Hash:    hash(secret),
+		Created: time.Now().UTC(),
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.keys = append(s.keys, key)
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", nil, err
+	}
+
+	return secret, key.view(), nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func (s *KeyStore) List() []*Key {
+	s.RLock()
+	defer s.RUnlock()
+
+	keys := make([]*Key, len(s.keys))
+	for i, key := range s.keys {
+		keys[i] = key.view()
+	}
+
+	return keys
+}
+
+// Revoke stops a key from authenticating. The key stays listed.
+func (s *KeyStore) Revoke(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key := s.find(id)
+	if key == nil {
+		return ErrKeyNotFound
+	}
+	if key.Revoked != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	key.Revoked = &now
+	if err := s.save(); err != nil {
+		key.Revoked = nil
+		return err
+	}
+
+	return nil
+}
+
+// Authenticate returns the principal a key belongs to, or nil if it isn't
+// a live key.
+func (s *KeyStore) Authenticate(secret string) *Principal {
+	if s == nil || !strings.HasPrefix(secret, _keyPrefix) {
+		return nil
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(secret, _keyPrefix), "_")
+	if !ok {
+		return nil
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	key := s.find(id)
+	if key == nil || key.Revoked != nil {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(secret))) != 1 {
+		return nil
+	}
+
+	return &Principal{Name: key.Name, Role: key.Role, KeyID: key.ID}
+}
+
+// Allows reports whether the principal holds role or one above it.
+func (p *Principal) Allows(role string) bool {
+	return p != nil && rank(p.Role) >= rank(role) && rank(role) >= 0
+}
+
+// Permits reports whether the principal may make a request needing role,
+// for a key, or scope, for a token. A resource's write scope, such as
+// products:write, covers its read scope too.
+func (p *Principal) Permits(role, scope string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Role != "" {
+		return p.Allows(role)
+	}
+
+	resource, access, _ := strings.Cut(scope, ":")
+	for _, s := range p.Scopes {
+		if s == scope || (access == ScopeRead && s == resource+":"+ScopeWrite) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *KeyStore) find(id string) *Key {
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// save writes the keys to a temporary file and renames it into place.
+// Callers hold the lock.
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// view is a copy of the key without its hash.
+func (key *Key) view() *Key {
+	copied := *key
+	copied.Hash = ""
+
+	return &copied
+}
+
+func rank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"grocery/config"
+)
+
+func TestKeyStore(t *testing.T) {
+	s := NewKeyStore()
+
+	secret, key, err := s.Create("Till 3", RoleManager)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+	if key.Hash != "" {
+		t.Error("wanted the hash kept out of the created key")
+	}
+
+	principal := s.Authenticate(secret)
+	if principal == nil || principal.Name != "Till 3" || principal.Role != RoleManager || principal.KeyID != key.ID {
+		t.Fatalf("wanted the key to authenticate as Till 3 but got %+v", principal)
+	}
+
+	for _, bad := range []string{"", "nonsense", secret + "x", "gk_" + key.ID + "_", strings.Replace(secret, key.ID, "0000000000000000", 1)} {
+		if s.Authenticate(bad) != nil {
+			t.Errorf("wanted %q refused", bad)
+		}
+	}
+
+	if _, _, err := s.Create("", RoleReader); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("wanted ErrInvalidKey for a key without a name but got %v", err)
+	}
+	if _, _, err := s.Create("Till 4", "owner"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("wanted ErrInvalidKey for an unknown role but got %v", err)
+	}
+
+	if err := s.Revoke(key.ID); err != nil {
+		t.Fatalf("failed to revoke key [ERR: %s]", err)
+	}
+	if s.Authenticate(secret) != nil {
+		t.Error("wanted a revoked key refused")
+	}
+	if keys := s.List(); len(keys) != 1 || keys[0].Revoked == nil || keys[0].Hash != "" {
+		t.Errorf("wanted the revoked key listed without its hash but got %+v", keys)
+	}
+	if err := s.Revoke("missing"); err != ErrKeyNotFound {
+		t.Errorf("wanted ErrKeyNotFound but got %v", err)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	var allowsTable = []struct {
+		role  string
+		needs string
+		want  bool
+	}{
+		{RoleReader, RoleReader, true},
+		{RoleReader, RoleManager, false},
+		{RoleManager, RoleReader, true},
+		{RoleManager, RoleAdmin, false},
+		{RoleAdmin, RoleManager, true},
+		{RoleAdmin, "owner", false},
+		{"owner", RoleReader, false},
+	}
+
+	for _, tc := range allowsTable {
+		p := &Principal{Name: "test", Role: tc.role}
+		if got := p.Allows(tc.needs); got != tc.want {
+			t.Errorf("%s allows %s: wanted %v but got %v", tc.role, tc.needs, tc.want, got)
+		}
+	}
+
+	var nobody *Principal
+	if nobody.Allows(RoleReader) {
+		t.Error("wanted no principal allowed anything")
+	}
+}
+
+func TestOpenKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	s, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatalf("failed to open key store [ERR: %s]", err)
+	}
+	secret, _, err := s.Create("Till 3", RoleReader)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read key file [ERR: %s]", err)
+	}
+	if strings.Contains(string(b), secret) || !strings.Contains(string(b), hash(secret)) {
+		t.Error("wanted only the key's hash saved")
+	}
+
+	s, err = OpenKeyStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen key store [ERR: %s]", err)
+	}
+	if p := s.Authenticate(secret); p == nil || p.Role != RoleReader {
+		t.Errorf("wanted the key to authenticate after reopening but got %+v", p)
+	}
+}
+
+func TestShowAdminKey(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenKeyStore(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatalf("failed to open key store [ERR: %s]", err)
+	}
+	secret, _, err := s.Create("admin", RoleAdmin)
+	if err != nil {
+		t.Fatalf("failed to create key [ERR: %s]", err)
+	}
+
+	if err := showAdminKey(s, secret); err != nil {
+		t.Fatalf("failed to save admin key [ERR: %s]", err)
+	}
+
+	path := filepath.Join(dir, config.ADMINKEYFILE)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat admin key file [ERR: %s]", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("wanted the admin key file readable only by its owner but got %s", info.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(path); strings.TrimSpace(string(b)) != secret {
+		t.Errorf("wanted the secret in the admin key file but got %q", b)
+	}
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgHS256 = "HS256"
+
+	// _minRSABits is the smallest RSA modulus a key set may hold.
+	_minRSABits = 2048
+)
+
+var (
+	// Tokens verifies bearer tokens; nil unless config.JWKSFILE is set.
+	Tokens *Verifier
+
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+type (
+	// Claims are the registered claims a token is checked against, plus its
+	// scopes from either a space separated scope claim or an scp array.
+	Claims struct {
+		Issuer    string   `json:"iss"`
+		Subject   string   `json:"sub"`
+		Audience  audience `json:"aud"`
+		Expires   *int64   `json:"exp"`
+		NotBefore *int64   `json:"nbf"`
+		IssuedAt  *int64   `json:"iat"`
+		Scope     string   `json:"scope"`
+		Scp       []string `json:"scp"`
+	}
+
+	// audience is the aud claim, which may be one string or several.
+	audience []string
+
+	// jwk is a key in a JSON Web Key Set (RFC 7517).
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		K   string `json:"k"`
+	}
+
+	// verifyingKey is a key from the set ready to check signatures made
+	// with alg.
+	verifyingKey struct {
+		kid string
+		alg string
+		key interface{}
+	}
+
+	// KeySet holds the keys of a JWKS file, reloaded when the file changes.
+	KeySet struct {
+		sync.RWMutex
+
+		path    string
+		modTime time.Time
+		size    int64
+		keys    []*verifyingKey
+	}
+
+	// Verifier checks bearer tokens against a key set and the expected
+	// audience and issuer, either of which may be left empty to accept any.
+	Verifier struct {
+		Keys     *KeySet
+		Audience string
+		Issuer   string
+		// Leeway allows for clock skew between us and the issuer.
+		Leeway time.Duration
+	}
+)
+
+// LoadKeySet reads the JWKS file at path.
+func LoadKeySet(path string) (*KeySet, error) {
+	ks := &KeySet{path: path}
+	if _, err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Reload rereads the key set if its file has changed since it was last
+// read, reporting whether it did. A file that no longer parses leaves the
+// keys as they were.
+func (ks *KeySet) Reload() (bool, error) {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return false, err
+	}
+
+	ks.RLock()
+	unchanged := info.ModTime().Equal(ks.modTime) && info.Size() == ks.size
+	ks.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(ks.path)
+	if err != nil {
+		return false, err
+	}
+	keys, err := parseKeySet(b)
+	if err != nil {
+		return false, err
+	}
+
+	ks.Lock()
+	ks.keys, ks.modTime, ks.size = keys, info.ModTime(), info.Size()
+	ks.Unlock()
+
+	return true, nil
+}
+
+// RunKeySetReloader reloads ks every interval until done is closed.
+func RunKeySetReloader(ks *KeySet, interval time.Duration, done <-chan int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			reloaded, err := ks.Reload()
+			if err != nil {
+				log.Printf("reloading JWKS [ERR: %s]", err)
+			}
+			if reloaded {
+				log.Printf("reloaded JWKS from %s", ks.path)
+			}
+		}
+	}
+}
+
+// candidates returns the keys that may have signed a token with the given
+// header: the one named by kid or, without a kid, every key for alg.
+func (ks *KeySet) candidates(alg, kid string) []*verifyingKey {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	var found []*verifyingKey
+	for _, key := range ks.keys {
+		if key.alg == alg && (kid == "" || key.kid == kid) {
+			found = append(found, key)
+		}
+	}
+
+	return found
+}
+
+// Verify checks token's signature, expiry, start and, when the verifier
+// has them, audience and issuer, returning its claims.
+func (v *Verifier) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+
+	switch header.Alg {
+	case AlgRS256, AlgES256, AlgHS256:
+	default:
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	var (
+		signed   = []byte(parts[0] + "." + parts[1])
+		verified bool
+	)
+	for _, key := range v.Keys.candidates(header.Alg, header.Kid) {
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+
+	switch {
+	case claims.Expires == nil:
+		return nil, fmt.Errorf("%w: no exp", ErrInvalidToken)
+	case !now.Before(time.Unix(*claims.Expires, 0).Add(v.Leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != nil && now.Add(v.Leeway).Before(time.Unix(*claims.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case v.Issuer != "" && claims.Issuer != v.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case v.Audience != "" && !claims.Audience.has(v.Audience):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// Principal is who the token was issued to, with its scopes.
+func (c *Claims) Principal() *Principal {
+	scopes := strings.Fields(c.Scope)
+	scopes = append(scopes, c.Scp...)
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return &Principal{Name: c.Subject, Scopes: scopes}
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+func (a audience) has(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (key *verifyingKey) verify(signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch k := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS carries ES256 signatures as r and s, 32 bytes each
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+
+	return false
+}
+
+// parseKeySet reads the signing keys of a JWKS, skipping encryption keys
+// and key types it doesn't support.
+func parseKeySet(b []byte) ([]*verifyingKey, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+
+	var keys []*verifyingKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.verifyingKey()
+		if err != nil {
+			return nil, fmt.Errorf("parsing JWKS key %d (%q): %w", i, k.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// verifyingKey builds the key, or nil for a key type that isn't supported.
+func (k *jwk) verifyingKey() (*verifyingKey, error) {
+	var (
+		alg string
+		key interface{}
+		err error
+	)
+
+	switch k.Kty {
+	case "RSA":
+		alg = AlgRS256
+		key, err = k.rsaKey()
+	case "EC":
+		alg = AlgES256
+		key, err = k.ecKey()
+	case "oct":
+		alg = AlgHS256
+		key, err = k.secret()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.Alg != "" && k.Alg != alg {
+		// a key used with anything else isn't one of ours to verify
+		return nil, nil
+	}
+
+	return &verifyingKey{kid: k.Kid, alg: alg, key: key}, nil
+}
+
+func (k *jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("e: %w", err)
+	}
+
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	switch {
+	case key.N.BitLen() < _minRSABits:
+		return nil, fmt.Errorf("modulus is shorter than %d bits", _minRSABits)
+	case key.E < 3 || key.E%2 == 0:
+		return nil, errors.New("bad exponent")
+	}
+
+	return key, nil
+}
+
+func (k *jwk) ecKey() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != 32 {
+		return nil, errors.New("bad x")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil || len(y) != 32 {
+		return nil, errors.New("bad y")
+	}
+
+	// ecdh checks the point is on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+func (k *jwk) secret() ([]byte, error) {
+	secret, err := base64.RawURLEncoding.DecodeString(k.K)
+	if err != nil {
+		return nil, fmt.Errorf("k: %w", err)
+	}
+	if len(secret) < sha256.Size {
+		return nil, fmt.Errorf("secret is shorter than %d bytes", sha256.Size)
+	}
+
+	return secret, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	kid    string
+	alg    string
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+}
+
+func newTestSigners(t *testing.T) (rs, es, hs *testSigner) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key [ERR: %s]", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key [ERR: %s]", err)
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return &testSigner{kid: "rs", alg: AlgRS256, rsa: rsaKey},
+		&testSigner{kid: "es", alg: AlgES256, ec: ecKey},
+		&testSigner{kid: "hs", alg: AlgHS256, secret: secret}
+}
+
+// jwk is the signer's public key as a JWKS entry.
+func (s *testSigner) jwk() map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch {
+	case s.rsa != nil:
+		return map[string]string{"kty": "RSA", "kid": s.kid, "use": "sig", "n": b64(s.rsa.N.Bytes()), "e": b64(big.NewInt(int64(s.rsa.E)).Bytes())}
+	case s.ec != nil:
+		x, y := make([]byte, 32), make([]byte, 32)
+		s.ec.X.FillBytes(x)
+		s.ec.Y.FillBytes(y)
+		return map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": b64(x), "y": b64(y)}
+	}
+
+	return map[string]string{"kty": "oct", "kid": s.kid, "alg": AlgHS256, "k": b64(s.secret)}
+}
+
+func (s *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	return s.signAs(t, s.alg, s.kid, claims)
+}
+
+func (s *testSigner) signAs(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to encode claims [ERR: %s]", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch {
+	case s.rsa != nil:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsa, crypto.SHA256, digest[:])
+	case s.ec != nil:
+		var r, ss *big.Int
+		r, ss, err = ecdsa.Sign(rand.Reader, s.ec, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		ss.FillBytes(signature[32:])
+	default:
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token [ERR: %s]", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeKeySet(t *testing.T, path string, signers ...*testSigner) {
+	keys := []map[string]string{}
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("failed to write JWKS [ERR: %s]", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rs, es, hs := newTestSigners(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeySet(t, path, rs, es, hs)
+
+	keys, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatalf("failed to load JWKS [ERR: %s]", err)
+	}
+	v := &Verifier{Keys: keys, Audience: "grocery", Issuer: "https://id.example.com", Leeway: time.Minute}
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://id.example.com",
+			"sub":   "pricing-service",
+			"aud":   "grocery",
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "products:write stores:read",
+		}
+		for k, value := range changes {
+			if value == nil {
+				delete(c, k)
+			} else {
+				c[k] = value
+			}
+		}
+		return c
+	}
+	other, _, _ := newTestSigners(t)
+
+	var verifyTable = []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", rs.sign(t, claims(nil)), true},
+		{"ES256", es.sign(t, claims(nil)), true},
+		{"HS256", hs.sign(t, claims(nil)), true},
+		{"no kid", rs.signAs(t, AlgRS256, "", claims(nil)), true},
+		{"audiences", rs.sign(t, claims(map[string]interface{}{"aud": []string{"billing", "grocery"}})), true},
+		{"within leeway", rs.sign(t, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), true},
+		{"started", rs.sign(t, claims(map[string]interface{}{"nbf": now.Add(-time.Hour).Unix()})), true},
+		{"expired", rs.sign(t, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), false},
+		{"no exp", rs.sign(t, claims(map[string]interface{}{"exp": nil})), false},
+		{"not yet", rs.sign(t, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), false},
+		{"wrong audience", rs.sign(t, claims(map[string]interface{}{"aud": "billing"})), false},
+		{"no audience", rs.sign(t, claims(map[string]interface{}{"aud": nil})), false},
+		{"wrong issuer", rs.sign(t, claims(map[string]interface{}{"iss": "https://evil.example.com"})), false},
+		{"unknown signer", other.sign(t, claims(nil)), false},
+		{"wrong kid", rs.signAs(t, AlgRS256, "es", claims(nil)), false},
+		{"alg none", noneToken(claims(nil)), false},
+		{"alg swapped", hs.signAs(t, AlgRS256, "rs", claims(nil)), false},
+		{"tampered", tamper(rs.sign(t, claims(nil))), false},
+		{"malformed", "not.a-token", false},
+	}
+
+	for _, tc := range verifyTable {
+		got, err := v.Verify(tc.token, now)
+		if tc.valid && err != nil {
+			t.Errorf("%s: wanted a valid token [ERR: %s]", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: wanted ErrInvalidToken but got %v", tc.name, err)
+		}
+		if tc.valid && err == nil && got.Subject != "pricing-service" {
+			t.Errorf("%s: wanted the subject pricing-service but got %q", tc.name, got.Subject)
+		}
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	rs, es, _ := newTestSigners(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeKeySet(t, path, rs)
+
+	keys, err := LoadKeySet(path)
+	if err != nil {
+		t.Fatalf("failed to load JWKS [ERR: %s]", err)
+	}
+	v := &Verifier{Keys: keys}
+	token := es.sign(t, map[string]interface{}{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix()})
+
+	if _, err := v.Verify(token, time.Now()); err == nil {
+		t.Fatal("wanted a token from an unknown key refused")
+	}
+	if reloaded, err := keys.Reload(); reloaded || err != nil {
+		t.Errorf("wanted an unchanged file left alone but got %v [ERR: %v]", reloaded, err)
+	}
+
+	// rotate in the EC key
+	writeKeySet(t, path, rs, es)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	if reloaded, err := keys.Reload(); !reloaded || err != nil {
+		t.Fatalf("wanted the changed file reloaded but got %v [ERR: %v]", reloaded, err)
+	}
+	if _, err := v.Verify(token, time.Now()); err != nil {
+		t.Errorf("wanted the token verified after reloading [ERR: %s]", err)
+	}
+
+	// a broken file keeps the keys already loaded
+	os.WriteFile(path, []byte("{"), 0o644)
+	later = later.Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	if _, err := keys.Reload(); err == nil {
+		t.Error("wanted an error reloading a broken file")
+	}
+	if _, err := v.Verify(token, time.Now()); err != nil {
+		t.Errorf("wanted the loaded keys kept [ERR: %s]", err)
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	var parseTable = []struct {
+		name  string
+		jwks  string
+		keys  int
+		valid bool
+	}{
+		{"empty", `{"keys": []}`, 0, true},
+		{"encryption key", `{"keys": [{"kty": "oct", "use": "enc", "k": "AAAA"}]}`, 0, true},
+		{"unsupported type", `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AAAA"}]}`, 0, true},
+		{"short secret", `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`, 0, false},
+		{"small RSA", `{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`, 0, false},
+		{"off curve", `{"keys": [{"kty": "EC", "crv": "P-256", "x": "` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `", "y": "` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`, 0, false},
+		{"other curve", `{"keys": [{"kty": "EC", "crv": "P-384"}]}`, 0, false},
+		{"not json", `keys`, 0, false},
+	}
+
+	for _, tc := range parseTable {
+		keys, err := parseKeySet([]byte(tc.jwks))
+		if tc.valid != (err == nil) || len(keys) != tc.keys {
+			t.Errorf("%s: wanted %d keys (valid %v) but got %d [ERR: %v]", tc.name, tc.keys, tc.valid, len(keys), err)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	token := (&Claims{Subject: "svc", Scope: "products:write", Scp: []string{"audit:read"}}).Principal()
+
+	var permitsTable = []struct {
+		principal *Principal
+		role      string
+		scope     string
+		want      bool
+	}{
+		{token, RoleManager, "products:write", true},
+		{token, RoleReader, "products:read", true},
+		{token, RoleReader, "audit:read", true},
+		{token, RoleManager, "audit:write", false},
+		{token, RoleManager, "stores:write", false},
+		{token, RoleAdmin, "admin:write", false},
+		{&Principal{Name: "svc", Scopes: []string{}}, RoleReader, "products:read", false},
+		{&Principal{Name: "till", Role: RoleManager}, RoleManager, "stores:write", true},
+		{&Principal{Name: "till", Role: RoleManager}, RoleAdmin, "admin:read", false},
+		{nil, RoleReader, "products:read", false},
+	}
+
+	for _, tc := range permitsTable {
+		if got := tc.principal.Permits(tc.role, tc.scope); got != tc.want {
+			t.Errorf("%+v permits %s/%s: wanted %v but got %v", tc.principal, tc.role, tc.scope, tc.want, got)
+		}
+	}
+}
+
+// noneToken is an unsigned token.
+func noneToken(claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(claims)
+
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// tamper widens a token's scope without re-signing it.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+
+	b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]interface{}
+	json.Unmarshal(b, &claims)
+	claims["scope"] = "admin:write"
+	b, _ = json.Marshal(claims)
+
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(b) + "." + parts[2]
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"container/list"
 	"errors"
 	"sync"
 	"time"
@@ -12,27 +13,62 @@ type (
 		count    float64
 		created  time.Time
 		accessed time.Time
+		// expires is when the item is dropped, zero for never.
+		expires time.Time
+		element *list.Element
+	}
+
+	// Stats count how lookups in a cache have fared and the items it has
+	// dropped, evicted to make room or expired.
+	Stats struct {
+		Hits        uint64 `json:"hits"`
+		Misses      uint64 `json:"misses"`
+		Evictions   uint64 `json:"evictions"`
+		Expirations uint64 `json:"expirations"`
+		Items       int    `json:"items"`
 	}
 
 	Cache struct {
 		sync.RWMutex
 
 		Items map[string]*CacheItem
+
+		// maxItems caps the items kept, the least recently used making way
+		// for new ones; zero for no cap.
+		maxItems int
+		// ttl is how long an item lasts after it was last put, unless given
+		// its own; zero for ever.
+		ttl time.Duration
+		// lru holds the keys, most recently used first.
+		lru   *list.List
+		stats Stats
+		now   func() time.Time
 	}
 )
 
+// NewCache makes a cache whose items last until deleted.
 func NewCache() *Cache {
+	return NewBoundedCache(0, 0)
+}
+
+// NewBoundedCache makes a cache holding at most maxItems, evicting the least
+// recently used, whose items expire ttl after they were last put. Either may
+// be zero for no limit.
+func NewBoundedCache(maxItems int, ttl time.Duration) *Cache {
 	return &Cache{
-		Items: make(map[string]*CacheItem),
+		Items:    make(map[string]*CacheItem),
+		maxItems: maxItems,
+		ttl:      ttl,
+		lru:      list.New(),
+		now:      time.Now,
 	}
 }
 
 func (c *Cache) Has(key string) bool {
-	c.RLock()
-	_, ok := c.Items[key]
-	c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
-	return ok
+	return c.lookup(key) != nil
 }
 
 func (c *Cache) Get(key string) (created, accessed time.Time, count float64) {
@@ -40,74 +76,201 @@ func (c *Cache) Get(key string) (created, accessed time.Time, count float64) {
 		return
 	}
 
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
-	if item, ok := c.Items[key]; ok {
+	if item := c.lookup(key); item != nil {
 		return item.created, item.accessed, item.count
 	}
 
 	return
 }
 
+// Value returns the data kept at key.
+func (c *Cache) Value(key string) (data interface{}, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if item := c.lookup(key); item != nil {
+		return item.Data, true
+	}
+
+	return nil, false
+}
+
 func (c *Cache) Put(key string) (err error) {
 	if key == "" {
 		return errors.New("key required")
 	}
 
 	c.Lock()
+	c.put(key, c.ttl)
+	c.Unlock()
 
-	if _, ok := c.Items[key]; !ok {
-		c.Items[key] = &CacheItem{created: time.Now()}
-	}
+	return
+}
 
-	c.Items[key].accessed = time.Now()
+// Set keeps data at key for ttl, or the cache's own ttl if it is zero.
+func (c *Cache) Set(key string, data interface{}, ttl time.Duration) error {
+	if key == "" {
+		return errors.New("key required")
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
 
+	c.Lock()
+	c.put(key, ttl).Data = data
 	c.Unlock()
 
-	return
+	return nil
 }
 
 func (c *Cache) Inc(key string) (created, accessed time.Time, count float64) {
-	if key == "" {
-		return
+	return c.add(key, 1)
+}
+
+func (c *Cache) Dec(key string) (created, accessed time.Time, count float64) {
+	return c.add(key, -1)
+}
+
+func (c *Cache) Del(key string) {
+	c.Lock()
+	if item, ok := c.Items[key]; ok {
+		c.remove(key, item)
+	}
+	c.Unlock()
+}
+
+// DeleteExpired drops the items that have expired, returning how many.
+func (c *Cache) DeleteExpired() int {
+	c.Lock()
+	defer c.Unlock()
+
+	var (
+		now     = c.now()
+		expired int
+	)
+	for key, item := range c.Items {
+		if item.expired(now) {
+			c.remove(key, item)
+			c.stats.Expirations++
+			expired++
+		}
 	}
 
+	return expired
+}
+
+func (c *Cache) Stats() Stats {
 	c.RLock()
 	defer c.RUnlock()
 
-	if item, ok := c.Items[key]; ok {
-		now := time.Now()
-		c.Items[key].accessed = now
-		c.Items[key].count++
+	stats := c.stats
+	stats.Items = len(c.Items)
+
+	return stats
+}
 
-		return item.created, now, c.Items[key].count
+// RunJanitor drops the cache's expired items every interval until done is
+// closed.
+func RunJanitor(c *Cache, interval time.Duration, done <-chan int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.DeleteExpired()
+		}
 	}
+}
 
-	return
+// lookup finds the live item at key, marking it used and counting the hit
+// or miss. Callers hold the lock.
+func (c *Cache) lookup(key string) *CacheItem {
+	item, ok := c.Items[key]
+	if ok && item.expired(c.now()) {
+		c.remove(key, item)
+		c.stats.Expirations++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil
+	}
+
+	c.stats.Hits++
+	c.lru.MoveToFront(item.element)
+
+	return item
 }
 
-func (c *Cache) Dec(key string) (created, accessed time.Time, count float64) {
+// put touches the item at key, adding it if it isn't there, and sets it to
+// expire after ttl. Callers hold the lock.
+func (c *Cache) put(key string, ttl time.Duration) *CacheItem {
+	now := c.now()
+
+	item, ok := c.Items[key]
+	if ok && item.expired(now) {
+		c.remove(key, item)
+		c.stats.Expirations++
+		ok = false
+	}
+	if !ok {
+		item = &CacheItem{created: now, element: c.lru.PushFront(key)}
+		c.Items[key] = item
+		c.evict()
+	}
+
+	item.accessed = now
+	item.expires = time.Time{}
+	if ttl > 0 {
+		item.expires = now.Add(ttl)
+	}
+	c.lru.MoveToFront(item.element)
+
+	return item
+}
+
+func (c *Cache) add(key string, n float64) (created, accessed time.Time, count float64) {
 	if key == "" {
 		return
 	}
 
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
-	if item, ok := c.Items[key]; ok {
-		now := time.Now()
-		c.Items[key].accessed = now
-		c.Items[key].count--
+	now := c.now()
+	if item, ok := c.Items[key]; ok && !item.expired(now) {
+		item.accessed = now
+		item.count += n
+		c.lru.MoveToFront(item.element)
 
-		return item.created, now, c.Items[key].count
+		return item.created, now, item.count
 	}
 
 	return
 }
 
-func (c *Cache) Del(key string) {
-	c.Lock()
+// evict drops the least recently used items beyond maxItems. Callers hold
+// the lock.
+func (c *Cache) evict() {
+	for c.maxItems > 0 && len(c.Items) > c.maxItems {
+		oldest := c.lru.Back()
+		key := oldest.Value.(string)
+		c.remove(key, c.Items[key])
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(key string, item *CacheItem) {
+	c.lru.Remove(item.element)
 	delete(c.Items, key)
-	c.Unlock()
+}
+
+func (item *CacheItem) expired(now time.Time) bool {
+	return !item.expires.IsZero() && !now.Before(item.expires)
 }
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a cache's time, moved by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newTestCache(maxItems int, ttl time.Duration) (*Cache, *clock) {
+	clk := &clock{now: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)}
+	c := NewBoundedCache(maxItems, ttl)
+	c.now = clk.Now
+
+	return c, clk
+}
+
+func TestCounters(t *testing.T) {
+	c := NewCache()
+
+	if err := c.Put(""); err == nil {
+		t.Error("wanted a key required")
+	}
+	c.Put("client")
+	c.Inc("client")
+	c.Inc("client")
+	c.Dec("client")
+
+	if _, _, count := c.Get("client"); count != 1 {
+		t.Errorf("wanted a count of 1 but got %v", count)
+	}
+	if _, _, count := c.Inc("missing"); count != 0 || c.Has("missing") {
+		t.Error("wanted Inc to leave a missing key alone")
+	}
+
+	c.Del("client")
+	if c.Has("client") {
+		t.Error("wanted the key deleted")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c, clk := newTestCache(0, time.Minute)
+
+	c.Put("default")
+	c.Set("short", "data", 10*time.Second)
+
+	clk.now = clk.now.Add(30 * time.Second)
+	if _, ok := c.Value("short"); ok {
+		t.Error("wanted the item with its own TTL expired")
+	}
+	if !c.Has("default") {
+		t.Error("wanted the item with the cache's TTL kept")
+	}
+
+	// putting an item again keeps it longer
+	c.Put("default")
+	clk.now = clk.now.Add(45 * time.Second)
+	if !c.Has("default") {
+		t.Error("wanted the item put again kept")
+	}
+
+	c.Set("other", nil, 0)
+	clk.now = clk.now.Add(time.Hour)
+	if n := c.DeleteExpired(); n != 2 {
+		t.Errorf("wanted 2 expired items dropped but got %d", n)
+	}
+
+	stats := c.Stats()
+	if stats.Expirations != 3 || stats.Items != 0 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("wanted 3 expirations, 2 hits and a miss but got %+v", stats)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c, _ := newTestCache(2, 0)
+
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	// using a makes b the least recently used
+	c.Value("a")
+	c.Set("c", 3, 0)
+
+	if _, ok := c.Value("b"); ok {
+		t.Error("wanted the least recently used item evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Value(key); !ok {
+			t.Errorf("wanted %s kept", key)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Items != 2 {
+		t.Errorf("wanted 1 eviction and 2 items but got %+v", stats)
+	}
+}
+
+func TestRunJanitor(t *testing.T) {
+	c := NewBoundedCache(0, time.Millisecond)
+	c.Put("client")
+
+	done := make(chan int)
+	go RunJanitor(c, time.Millisecond, done)
+	defer close(done)
+
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Items > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := c.Stats(); stats.Items != 0 || stats.Expirations != 1 {
+		t.Errorf("wanted the janitor to drop the expired item but got %+v", stats)
+	}
+}
@@ -1,6 +1,18 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
+
+type (
+	// RatePolicy is a token bucket: a client may make Burst requests at once
+	// and then Rate a second. A Rate of zero leaves requests unlimited.
+	RatePolicy struct {
+		Rate  float64
+		Burst int
+	}
+)
 
 var (
 	APIHOST = "192.41.48.147"
@@ -8,4 +20,95 @@ var (
 	APIURL  = fmt.Sprintf("https://%s:%d", APIHOST, APIPORT)
 
 	DEVAPIURL = fmt.Sprintf("http://127.0.0.1:%d", APIPORT)
+
+	// DBDRIVER selects the storage backend opened by database.Connect.
+	DBDRIVER = "memory"
+	// DBPATH is the directory the "file" and "sqlite" drivers keep their data in.
+	DBPATH = "data"
+	// DBNAME is the database file opened by the "sqlite" driver.
+	DBNAME = "grocery.db"
+
+	DEVDBNAME = "grocery-dev.db"
+
+	// AUDITFILE is the audit log kept in DBPATH by the persistent drivers.
+	AUDITFILE = "audit.log"
+	// KEYFILE holds the hashed API keys in DBPATH for the persistent drivers.
+	KEYFILE = "keys.json"
+	// ADMINKEYFILE is where, beside KEYFILE, the secret of the admin key made
+	// for an empty key store is written, readable only by its owner.
+	ADMINKEYFILE = "admin.key"
+
+	// PUBLICREADS lets requests without an API key read the catalog. Writes,
+	// and reading the audit trail, product history and trash, always need a
+	// manager key.
+	PUBLICREADS = true
+
+	// JWKSFILE is a JSON Web Key Set whose keys verify bearer tokens; they
+	// aren't accepted without one. It is reread every JWKSRELOADINTERVAL
+	// when it changes.
+	JWKSFILE           = ""
+	JWKSRELOADINTERVAL = 30 * time.Second
+	// JWTISSUER and JWTAUDIENCE are the iss and aud bearer tokens must
+	// carry, if set.
+	JWTISSUER   = ""
+	JWTAUDIENCE = ""
+	// JWTLEEWAY allows for clock skew when checking exp and nbf.
+	JWTLEEWAY = 30 * time.Second
+
+	// TRUSTEDPROXIES are the CIDRs, or addresses, of the proxies in front of
+	// the server. Only they are believed about who a request is for, in the
+	// PROXYHEADER they write.
+	TRUSTEDPROXIES = []string{}
+	// PROXYHEADER is the header the trusted proxies write the client's
+	// address in, "Forwarded" or "X-Forwarded-For"; the other is ignored.
+	PROXYHEADER = "X-Forwarded-For"
+
+	// MAXBODYBYTES caps the size of a request body. ROUTEBODYLIMITS replace
+	// it for the routes starting with a path, optionally after a method; the
+	// longest match wins.
+	MAXBODYBYTES    = int64(10 << 20)
+	ROUTEBODYLIMITS = map[string]int64{
+		"POST /products/import": 1 << 30,
+	}
+
+	// ADDRESSRATELIMIT is the bucket each client address gets before its
+	// credentials are checked, whatever they turn out to be. It is set high
+	// enough for the clients sharing an address behind NAT.
+	ADDRESSRATELIMIT = RatePolicy{Rate: 50, Burst: 100}
+	// RATELIMIT is the bucket each client, by API key, token subject or
+	// address, gets for its requests.
+	RATELIMIT = RatePolicy{Rate: 15, Burst: 30}
+	// ROUTERATELIMITS give the routes starting with a path, optionally after
+	// a method as in "POST /products/import", a bucket of their own; the
+	// longest match wins.
+	ROUTERATELIMITS = map[string]RatePolicy{
+		"POST /products/import": {Rate: 0.2, Burst: 2},
+		"/admin":                {Rate: 1, Burst: 5},
+	}
+	// KEYRATELIMITS replace RATELIMIT for an API key, by its ID, or a token
+	// subject.
+	KEYRATELIMITS = map[string]RatePolicy{}
+	// RATELIMITCLIENTS caps the clients whose buckets are kept, the least
+	// recently seen being forgotten first.
+	RATELIMITCLIENTS = 100000
+
+	// CACHEJANITORINTERVAL is how often expired items are dropped from the
+	// caches.
+	CACHEJANITORINTERVAL = time.Minute
+
+	// CURRENCY is the ISO 4217 code assumed for prices given without one.
+	CURRENCY = "USD"
+
+	// BACKORDERS lets stock on hand go negative instead of refusing a sale.
+	BACKORDERS = false
+
+	// TRASHRETENTION is how long a deleted product stays in the trash, where
+	// it can be restored, before the sweeper purges it.
+	TRASHRETENTION = 30 * 24 * time.Hour
+	// TRASHSWEEPINTERVAL is how often the sweeper looks for products to purge.
+	TRASHSWEEPINTERVAL = time.Hour
+
+	// PRICESCHEDULEINTERVAL is how often the scheduler applies the prices
+	// due to start or end.
+	PRICESCHEDULEINTERVAL = time.Minute
 )
@@ -0,0 +1,447 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+var (
+	// Audit is the change history of the catalog, opened by Connect.
+	Audit *AuditLog
+
+	// auditIgnored are product fields left out of change diffs because
+	// every write changes them.
+	auditIgnored = map[string]bool{"code": true, "version": true}
+)
+
+type (
+	// Actor is who made a change.
+	Actor struct {
+		Name      string `json:"name"`
+		Address   string `json:"address,omitempty"`
+		RequestID string `json:"request_id,omitempty"`
+	}
+
+	// AuditEntry records one change to one product. Before and After are
+	// the product on either side of the change; Changes lists the fields
+	// that differ between them.
+	AuditEntry struct {
+		ID      int64           `json:"id"`
+		Time    time.Time       `json:"time"`
+		Action  string          `json:"action"`
+		Code    string          `json:"code"`
+		Actor   Actor           `json:"actor"`
+		Changes []*FieldChange  `json:"changes,omitempty"`
+		Before  *models.Product `json:"before,omitempty"`
+		After   *models.Product `json:"after,omitempty"`
+	}
+
+	FieldChange struct {
+		Field  string      `json:"field"`
+		Before interface{} `json:"before"`
+		After  interface{} `json:"after"`
+	}
+
+	// AuditFilter narrows Entries. Zero fields match everything; Before
+	// pages backwards from an entry ID.
+	AuditFilter struct {
+		Since  time.Time
+		Until  time.Time
+		Code   string
+		Action string
+		Actor  string
+		Before int64
+		Limit  int
+	}
+
+	// AuditLog is an append-only record of changes. Entries are held in
+	// memory and, when the log was opened from a file, appended to it as
+	// newline-delimited JSON.
+	AuditLog struct {
+		sync.RWMutex
+
+		entries []*AuditEntry
+		file    *os.File
+
+		// writes serializes audited writes so the before state an entry
+		// records is the one the write replaced
+		writes sync.Mutex
+	}
+
+	// auditedStore is a Store whose writes are recorded in an AuditLog.
+	auditedStore struct {
+		Store
+
+		log   *AuditLog
+		actor Actor
+	}
+)
+
+// openAudit opens the audit log for config.DBDRIVER: in memory alongside the
+// memory driver, in config.AUDITFILE otherwise.
+func openAudit() *AuditLog {
+	if config.DBDRIVER == "memory" {
+		return NewAuditLog()
+	}
+
+	if err := os.MkdirAll(config.DBPATH, 0o755); err != nil {
+		log.Fatalf("opening audit log [ERR: %s]", err)
+	}
+	l, err := OpenAuditLog(filepath.Join(config.DBPATH, config.AUDITFILE))
+	if err != nil {
+		log.Fatalf("opening audit log [ERR: %s]", err)
+	}
+
+	return l
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// OpenAuditLog loads the entries kept at path and appends new ones to it.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &AuditLog{file: file}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), _maxRecordLen)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line cut short by a crash; later entries still load
+			continue
+		}
+		l.entries = append(l.entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Close closes the file behind the log, if any.
+func (l *AuditLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+// Record appends an entry for a change to the product with the given code.
+// Failing to write the entry to the file is returned, but the entry is kept
+// in memory regardless since the change itself has already been made.
+func (l *AuditLog) Record(action, code string, actor Actor, before, after *models.Product) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Code:    code,
+		Actor:   actor,
+		Changes: diffProducts(before, after),
+		Before:  snapshot(before),
+		After:   snapshot(after),
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	entry.ID = 1
+	if n := len(l.entries); n > 0 {
+		entry.ID = l.entries[n-1].ID + 1
+	}
+	l.entries = append(l.entries, entry)
+
+	if l.file == nil {
+		return entry, nil
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	_, err = l.file.Write(append(b, '\n'))
+
+	return entry, err
+}
+
+// History returns the entries for the product with the given code, newest
+// first.
+func (l *AuditLog) History(code string) []*AuditEntry {
+	entries, _ := l.Entries(AuditFilter{Code: code, Limit: -1})
+	return entries
+}
+
+// At returns the product with the given code as it stood at time t, nil if
+// it didn't exist or was deleted then. ok is false when the log has no
+// entries for the product, which predates it.
+func (l *AuditLog) At(code string, t time.Time) (item *models.Product, ok bool) {
+	for _, entry := range l.History(code) {
+		if !entry.Time.After(t) {
+			return snapshot(entry.After), true
+		}
+		ok = true
+	}
+
+	return nil, ok
+}
+
+// Entries returns the entries matching filter, newest first, and whether
+// there are more beyond the limit. A negative limit returns them all.
+func (l *AuditLog) Entries(filter AuditFilter) (entries []*AuditEntry, more bool) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = DefaultAuditLimit
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	// entries are in ID order, so page backwards from Before
+	end := len(l.entries)
+	if filter.Before > 0 {
+		end = sort.Search(len(l.entries), func(i int) bool {
+			return l.entries[i].ID >= filter.Before
+		})
+	}
+
+	entries = []*AuditEntry{}
+	for i := end - 1; i >= 0; i-- {
+		entry := l.entries[i]
+		switch {
+		case !filter.Since.IsZero() && entry.Time.Before(filter.Since):
+			continue
+		case !filter.Until.IsZero() && !entry.Time.Before(filter.Until):
+			continue
+		case filter.Code != "" && !strings.EqualFold(entry.Code, filter.Code):
+			continue
+		case filter.Action != "" && entry.Action != filter.Action:
+			continue
+		case filter.Actor != "" && entry.Actor.Name != filter.Actor:
+			continue
+		}
+
+		if limit >= 0 && len(entries) == limit {
+			return entries, true
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, false
+}
+
+// Audited returns store with its writes recorded in Audit as made by actor.
+// Without an audit log store is returned as it is.
+func Audited(store Store, actor Actor) Store {
+	if Audit == nil {
+		return store
+	}
+	if a, ok := store.(*auditedStore); ok {
+		store = a.Store
+	}
+
+	return &auditedStore{Store: store, log: Audit, actor: actor}
+}
+
+func (a *auditedStore) record(action, code string, before, after *models.Product) {
+	if _, err := a.log.Record(action, code, a.actor, before, after); err != nil {
+		log.Printf("recording %s of product %q [ERR: %s]", action, code, err)
+	}
+}
+
+func (a *auditedStore) Put(items ...*models.Product) ([]*models.Product, []error) {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	created, errs := a.Store.Put(items...)
+	if len(errs) == 0 {
+		for _, item := range created {
+			a.record(AuditCreate, item.Code, nil, item)
+		}
+	}
+
+	return created, errs
+}
+
+func (a *auditedStore) Update(code string, item *models.Product, version int64) (*models.Product, error) {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	before := a.Store.Get(code)
+	updated, err := a.Store.Update(code, item, version)
+	if err == nil {
+		a.record(AuditUpdate, updated.Code, before, updated)
+	}
+
+	return updated, err
+}
+
+func (a *auditedStore) Del(code string, version int64) error {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	before := a.Store.Get(code)
+	err := a.Store.Del(code, version)
+	if err == nil && before != nil {
+		a.record(AuditDelete, before.Code, before, nil)
+	}
+
+	return err
+}
+
+func (a *auditedStore) Restore(code string, version int64) (*models.Product, error) {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	restored, err := a.Store.Restore(code, version)
+	if err == nil {
+		a.record(AuditRestore, restored.Code, nil, restored)
+	}
+
+	return restored, err
+}
+
+func (a *auditedStore) Purge(before time.Time) ([]string, error) {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	codes, err := a.Store.Purge(before)
+	for _, code := range codes {
+		a.record(AuditPurge, code, nil, nil)
+	}
+
+	return codes, err
+}
+
+func (a *auditedStore) Batch(ops ...*BatchOp) ([]*BatchResult, error) {
+	a.log.writes.Lock()
+	defer a.log.writes.Unlock()
+
+	// the state before each operation, following earlier ones in the batch
+	current := make(map[string]*models.Product)
+	for _, op := range ops {
+		if op != nil && op.Code != "" {
+			key := strings.ToUpper(op.Code)
+			if _, ok := current[key]; !ok {
+				current[key] = a.Store.Get(op.Code)
+			}
+		}
+	}
+
+	results, err := a.Store.Batch(ops...)
+	if err != nil {
+		return results, err
+	}
+
+	for _, result := range results {
+		key := strings.ToUpper(result.Code)
+		before := current[key]
+
+		switch result.Op {
+		case BatchCreate:
+			a.record(AuditCreate, result.Code, nil, result.Product)
+		case BatchUpdate:
+			a.record(AuditUpdate, result.Code, before, result.Product)
+		case BatchDelete:
+			a.record(AuditDelete, result.Code, before, nil)
+		}
+
+		if result.Product != nil && result.Product.DeletedAt == nil {
+			current[key] = result.Product
+		} else {
+			current[key] = nil
+		}
+	}
+
+	return results, nil
+}
+
+// snapshot copies item so later writes to it don't rewrite history.
+func snapshot(item *models.Product) *models.Product {
+	if item == nil {
+		return nil
+	}
+	copied := *item
+
+	return &copied
+}
+
+// diffProducts lists the fields that differ between before and after, by
+// their JSON names. Either may be nil, in which case there is nothing to
+// compare and no changes are listed.
+func diffProducts(before, after *models.Product) []*FieldChange {
+	if before == nil || after == nil {
+		return nil
+	}
+
+	b, a := productFields(before), productFields(after)
+
+	names := make([]string, 0, len(b)+len(a))
+	for name := range b {
+		names = append(names, name)
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []*FieldChange
+	for _, name := range names {
+		if auditIgnored[name] || reflect.DeepEqual(b[name], a[name]) {
+			continue
+		}
+		changes = append(changes, &FieldChange{Field: name, Before: b[name], After: a[name]})
+	}
+
+	return changes
+}
+
+func productFields(item *models.Product) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	b, err := json.Marshal(item)
+	if err == nil {
+		err = json.Unmarshal(b, &fields)
+	}
+	if err != nil {
+		log.Printf("diffing product %q [ERR: %s]", item.Code, err)
+	}
+
+	return fields
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grocery/models"
+)
+
+// withAudit points Audit at l for the length of the test.
+func withAudit(t *testing.T, l *AuditLog) {
+	saved := Audit
+	Audit = l
+	t.Cleanup(func() { Audit = saved })
+}
+
+func TestAudited(t *testing.T) {
+	withAudit(t, NewAuditLog())
+	store := Audited(NewDatabase(), Actor{Name: "tester", RequestID: "req-1"})
+
+	items, errs := store.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	code := items[0].Code
+
+	if _, err := store.Update(code, &models.Product{Name: "Waffles", Brand: "Eggo", Price: models.NewMoney(499, "USD")}, 1); err != nil {
+		t.Fatalf("failed to update product [ERR: %s]", err)
+	}
+	if _, err := store.Update(code, &models.Product{Name: "Nope"}, 1); err != ErrVersionMismatch {
+		t.Fatalf("wanted ErrVersionMismatch but got %v", err)
+	}
+	if err := store.Del(code, 2); err != nil {
+		t.Fatalf("failed to delete product [ERR: %s]", err)
+	}
+	if _, err := store.Restore(code, 3); err != nil {
+		t.Fatalf("failed to restore product [ERR: %s]", err)
+	}
+
+	history := Audit.History(code)
+	var actions []string
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	wanted := []string{AuditRestore, AuditDelete, AuditUpdate, AuditCreate}
+	if len(actions) != len(wanted) {
+		t.Fatalf("wanted history %v but got %v", wanted, actions)
+	}
+	for i := range wanted {
+		if actions[i] != wanted[i] {
+			t.Errorf("wanted history %v but got %v", wanted, actions)
+			break
+		}
+	}
+
+	update := history[2]
+	if update.Actor.Name != "tester" || update.Actor.RequestID != "req-1" {
+		t.Errorf("wanted the update made by tester in req-1 but got %+v", update.Actor)
+	}
+	if update.Before == nil || update.Before.Version != 1 || update.After == nil || update.After.Version != 2 {
+		t.Errorf("wanted the update to hold versions 1 and 2 but got %v and %v", update.Before, update.After)
+	}
+	changed := map[string]bool{}
+	for _, change := range update.Changes {
+		changed[change.Field] = true
+	}
+	if len(changed) != 2 || !changed["brand"] || !changed["price"] {
+		t.Errorf("wanted brand and price changed but got %v", changed)
+	}
+}
+
+func TestAuditedBatch(t *testing.T) {
+	withAudit(t, NewAuditLog())
+	store := Audited(NewDatabase(), Actor{Name: "tester"})
+
+	items, _ := store.Put(&models.Product{Name: "Lettuce", Price: models.NewMoney(346, "USD")})
+	code := items[0].Code
+
+	_, err := store.Batch(
+		&BatchOp{Op: BatchUpdate, Code: code, Product: &models.Product{Name: "Romaine", Price: models.NewMoney(399, "USD")}},
+		&BatchOp{Op: BatchDelete, Code: code},
+	)
+	if err != nil {
+		t.Fatalf("failed to apply batch [ERR: %s]", err)
+	}
+
+	history := Audit.History(code)
+	if len(history) != 3 {
+		t.Fatalf("wanted 3 entries but got %d", len(history))
+	}
+	if del := history[0]; del.Action != AuditDelete || del.Before == nil || del.Before.Name != "Romaine" {
+		t.Errorf("wanted the delete to follow the batch's update but got %v", del.Before)
+	}
+	if update := history[1]; update.Action != AuditUpdate || update.Before == nil || update.Before.Name != "Lettuce" {
+		t.Errorf("wanted the update to start from Lettuce but got %v", update.Before)
+	}
+
+	if _, err := store.Batch(&BatchOp{Op: BatchDelete, Code: "this-isnt-real-code"}); err == nil {
+		t.Fatal("wanted a failed batch")
+	}
+	if len(Audit.History(code)) != 3 {
+		t.Error("wanted nothing recorded for a failed batch")
+	}
+}
+
+func TestAuditEntries(t *testing.T) {
+	l := NewAuditLog()
+	for i, action := range []string{AuditCreate, AuditUpdate, AuditUpdate, AuditDelete, AuditCreate} {
+		actor := Actor{Name: "alice"}
+		if i%2 == 1 {
+			actor.Name = "bob"
+		}
+		l.Record(action, "CODE", actor, nil, nil)
+	}
+	// pin the times so ranges don't depend on the clock's resolution
+	base := time.Now().Add(-time.Hour)
+	for i, entry := range l.entries {
+		entry.Time = base.Add(time.Duration(i) * time.Minute)
+	}
+	middle := l.entries[2].Time
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		ids    []int64
+		more   bool
+	}{
+		{"all", AuditFilter{}, []int64{5, 4, 3, 2, 1}, false},
+		{"limit", AuditFilter{Limit: 2}, []int64{5, 4}, true},
+		{"before", AuditFilter{Before: 4, Limit: 2}, []int64{3, 2}, true},
+		{"last page", AuditFilter{Before: 2, Limit: 2}, []int64{1}, false},
+		{"action", AuditFilter{Action: AuditUpdate}, []int64{3, 2}, false},
+		{"actor", AuditFilter{Actor: "bob"}, []int64{4, 2}, false},
+		{"code", AuditFilter{Code: "code"}, []int64{5, 4, 3, 2, 1}, false},
+		{"other code", AuditFilter{Code: "OTHER"}, nil, false},
+		{"since", AuditFilter{Since: middle}, []int64{5, 4, 3}, false},
+		{"future", AuditFilter{Since: time.Now().Add(time.Hour)}, nil, false},
+		{"until", AuditFilter{Until: middle}, []int64{2, 1}, false},
+		{"range", AuditFilter{Since: l.entries[1].Time, Until: l.entries[3].Time}, []int64{3, 2}, false},
+		{"past", AuditFilter{Until: base}, nil, false},
+	}
+
+	for _, test := range tests {
+		entries, more := l.Entries(test.filter)
+		var ids []int64
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if len(ids) != len(test.ids) || more != test.more {
+			t.Errorf("%s: wanted %v (more %v) but got %v (more %v)", test.name, test.ids, test.more, ids, more)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.ids[i] {
+				t.Errorf("%s: wanted %v but got %v", test.name, test.ids, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log [ERR: %s]", err)
+	}
+	item := &models.Product{Code: "CODE", Name: "Waffles", Price: models.NewMoney(450, "USD"), Version: 1}
+	l.Record(AuditCreate, item.Code, Actor{Name: "tester"}, nil, item)
+	l.Record(AuditDelete, item.Code, Actor{Name: "tester"}, item, nil)
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close audit log [ERR: %s]", err)
+	}
+
+	l, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to reopen audit log [ERR: %s]", err)
+	}
+	defer l.Close()
+
+	history := l.History("CODE")
+	if len(history) != 2 || history[1].After == nil || history[1].After.Name != "Waffles" {
+		t.Fatalf("wanted both entries back but got %v", history)
+	}
+
+	entry, _ := l.Record(AuditRestore, item.Code, Actor{Name: "tester"}, nil, item)
+	if entry.ID != 3 {
+		t.Errorf("wanted IDs to carry on at 3 but got %d", entry.ID)
+	}
+}
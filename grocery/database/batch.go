@@ -0,0 +1,183 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"grocery/models"
+	"grocery/shared"
+)
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+
+	BatchOK     = "ok"
+	BatchFailed = "failed"
+	// BatchRolledBack marks an operation that would have succeeded but was
+	// undone because another in the batch failed.
+	BatchRolledBack = "rolled_back"
+
+	MaxBatchOps = 1000
+)
+
+var (
+	ErrBatchFailed  = errors.New("batch rolled back")
+	ErrInvalidBatch = errors.New("invalid batch")
+)
+
+type (
+	// BatchOp is one operation in a batch. Create takes a Product, update a
+	// Code and Product, delete a Code. Version works as it does for Update
+	// and Del: non-zero makes the operation conditional on it.
+	BatchOp struct {
+		Op      string          `json:"op"`
+		Code    string          `json:"code,omitempty"`
+		Version int64           `json:"version,omitempty"`
+		Product *models.Product `json:"product,omitempty"`
+	}
+
+	// BatchResult is the outcome of one operation, in the order given.
+	// Product is the stored product afterwards; a delete moves it to the
+	// trash.
+	BatchResult struct {
+		Op      string          `json:"op"`
+		Status  string          `json:"status"`
+		Code    string          `json:"code,omitempty"`
+		Product *models.Product `json:"product,omitempty"`
+		Field   string          `json:"field,omitempty"`
+		Error   string          `json:"error,omitempty"`
+	}
+)
+
+// stageBatch works out the effect of ops in order, looking products up with
+// get and seeing the effect of earlier operations in the batch, without
+// changing anything. Creates and updates are staged on copies, so the ops'
+// products are only normalized, never given codes. Every operation is
+// checked even after one fails so all failures are reported; if any fails
+// the rest are marked rolled back and the error wraps ErrBatchFailed and
+// the first failure.
+func stageBatch(get func(code string) *models.Product, ops []*BatchOp) ([]*BatchResult, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrInvalidBatch)
+	}
+	if len(ops) > MaxBatchOps {
+		return nil, fmt.Errorf("%w: more than %d operations", ErrInvalidBatch, MaxBatchOps)
+	}
+
+	var (
+		results = make([]*BatchResult, len(ops))
+		// staged holds products written earlier in the batch, nil once deleted
+		staged = make(map[string]*models.Product)
+		first  error
+	)
+
+	lookup := func(code string) *models.Product {
+		if item, ok := staged[strings.ToUpper(code)]; ok {
+			return item
+		}
+		return get(code)
+	}
+
+	for i, op := range ops {
+		result, err := stageOp(lookup, op)
+		if err != nil {
+			result = &BatchResult{Op: op.Op, Status: BatchFailed, Code: op.Code, Error: err.Error()}
+
+			var fieldErr *FieldError
+			if errors.As(err, &fieldErr) {
+				result.Field = fieldErr.Field
+				result.Error = fieldErr.Message
+			}
+			if first == nil {
+				first = fmt.Errorf("%w: operation %d: %w", ErrBatchFailed, i, err)
+			}
+		} else if result.Product.DeletedAt != nil {
+			staged[strings.ToUpper(result.Code)] = nil
+		} else {
+			staged[strings.ToUpper(result.Code)] = result.Product
+		}
+		results[i] = result
+	}
+
+	if first != nil {
+		for _, result := range results {
+			if result.Status == BatchOK {
+				result.Status = BatchRolledBack
+				result.Product = nil
+				if result.Op == BatchCreate {
+					result.Code = ""
+				}
+			}
+		}
+		return results, first
+	}
+
+	return results, nil
+}
+
+func stageOp(lookup func(string) *models.Product, op *BatchOp) (*BatchResult, error) {
+	if op == nil {
+		return nil, &FieldError{Field: "op", Message: "is required", err: ErrInvalidBatch}
+	}
+
+	switch op.Op {
+	case BatchCreate:
+		if op.Product == nil {
+			return nil, &FieldError{Field: "product", Message: "is required", err: ErrInvalidBatch}
+		}
+
+		item := *op.Product
+		if err := validate(&item); err != nil {
+			return nil, err
+		}
+		item.Code = shared.GenProductCode()
+		item.Version = 1
+
+		return &BatchResult{Op: op.Op, Status: BatchOK, Code: item.Code, Product: &item}, nil
+	case BatchUpdate:
+		if op.Code == "" {
+			return nil, &FieldError{Field: "code", Message: "is required", err: ErrInvalidBatch}
+		}
+		if op.Product == nil {
+			return nil, &FieldError{Field: "product", Message: "is required", err: ErrInvalidBatch}
+		}
+
+		item := *op.Product
+		if err := validate(&item); err != nil {
+			return nil, err
+		}
+
+		existing := lookup(op.Code)
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		if err := checkVersion(existing, op.Version); err != nil {
+			return nil, err
+		}
+		item.Code = existing.Code
+		item.Version = existing.Version + 1
+
+		return &BatchResult{Op: op.Op, Status: BatchOK, Code: item.Code, Product: &item}, nil
+	case BatchDelete:
+		if op.Code == "" {
+			return nil, &FieldError{Field: "code", Message: "is required", err: ErrInvalidBatch}
+		}
+
+		existing := lookup(op.Code)
+		if existing == nil {
+			return nil, ErrNotFound
+		}
+		if err := checkVersion(existing, op.Version); err != nil {
+			return nil, err
+		}
+
+		item := trashed(existing)
+
+		return &BatchResult{Op: op.Op, Status: BatchOK, Code: item.Code, Product: item}, nil
+	}
+
+	return nil, &FieldError{Field: "op", Message: fmt.Sprintf("%q is not create, update or delete", op.Op), err: ErrInvalidBatch}
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"grocery/models"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	fileStore := openTestFileStore(t, t.TempDir())
+	t.Cleanup(func() { fileStore.Close() })
+	sqlStore := openTestSQLite(t, filepath.Join(t.TempDir(), "grocery.db"))
+	t.Cleanup(func() { sqlStore.Close() })
+
+	return map[string]Store{
+		"memory": NewDatabase(),
+		"file":   fileStore,
+		"sqlite": sqlStore,
+	}
+}
+
+func TestBatch(t *testing.T) {
+	for name, store := range testStores(t) {
+		seeded, errs := store.Put(
+			&models.Product{Name: "Lettuce", Price: models.NewMoney(346, "USD")},
+			&models.Product{Name: "Peach", Price: models.NewMoney(299, "USD")},
+		)
+		if len(errs) > 0 {
+			t.Fatalf("%s: failed to seed [ERR: %s]", name, errs)
+		}
+		lettuce, peach := seeded[0], seeded[1]
+
+		results, err := store.Batch(
+			&BatchOp{Op: BatchCreate, Product: &models.Product{Name: "Basil", Price: models.NewMoney(249, "USD")}},
+			&BatchOp{Op: BatchUpdate, Code: lettuce.Code, Version: 1, Product: &models.Product{Name: "Romaine", Price: models.NewMoney(399, "USD")}},
+			&BatchOp{Op: BatchUpdate, Code: lettuce.Code, Version: 2, Product: &models.Product{Name: "Romaine Hearts", Price: models.NewMoney(449, "USD")}},
+			&BatchOp{Op: BatchDelete, Code: peach.Code},
+		)
+		if err != nil {
+			t.Fatalf("%s: batch failed [ERR: %s]", name, err)
+		}
+
+		for i, result := range results {
+			if result.Status != BatchOK {
+				t.Errorf("%s: operation %d: wanted ok but got %+v", name, i, result)
+			}
+		}
+		if basil := store.Get(results[0].Code); basil == nil || basil.Name != "Basil" {
+			t.Errorf("%s: wanted Basil created but got %v", name, basil)
+		}
+		if romaine := store.Get(lettuce.Code); romaine == nil || romaine.Name != "Romaine Hearts" || romaine.Version != 3 {
+			t.Errorf("%s: wanted Romaine Hearts at version 3 but got %v", name, romaine)
+		}
+		if store.Get(peach.Code) != nil {
+			t.Errorf("%s: wanted Peach deleted", name)
+		}
+		if matches := store.Search("romaine"); len(matches) != 1 {
+			t.Errorf("%s: wanted the index updated but got %d matches", name, len(matches))
+		}
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	for name, store := range testStores(t) {
+		seeded, errs := store.Put(&models.Product{Name: "Lettuce", Price: models.NewMoney(346, "USD")})
+		if len(errs) > 0 {
+			t.Fatalf("%s: failed to seed [ERR: %s]", name, errs)
+		}
+		lettuce := seeded[0]
+
+		basil := &models.Product{Name: "Basil", Price: models.NewMoney(249, "USD")}
+		results, err := store.Batch(
+			&BatchOp{Op: BatchCreate, Product: basil},
+			&BatchOp{Op: BatchDelete, Code: lettuce.Code},
+			&BatchOp{Op: BatchUpdate, Code: lettuce.Code, Product: &models.Product{Name: "Romaine"}},
+			&BatchOp{Op: BatchCreate, Product: &models.Product{Name: ""}},
+			&BatchOp{Op: "upsert"},
+		)
+		if !errors.Is(err, ErrBatchFailed) || !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: wanted ErrBatchFailed wrapping ErrNotFound but got %v", name, err)
+		}
+
+		var statusTable = []struct {
+			status string
+			field  string
+		}{
+			{BatchRolledBack, ""},
+			{BatchRolledBack, ""},
+			{BatchFailed, ""},
+			{BatchFailed, "name"},
+			{BatchFailed, "op"},
+		}
+
+		for i, tc := range statusTable {
+			if results[i].Status != tc.status || results[i].Field != tc.field {
+				t.Errorf("%s: operation %d: wanted %s %q but got %+v", name, i, tc.status, tc.field, results[i])
+			}
+		}
+
+		if basil.Code != "" || results[0].Code != "" {
+			t.Errorf("%s: wanted the rolled back create to have no code but got %q", name, basil.Code)
+		}
+		if items := store.List(); len(items) != 1 || items[0].Name != "Lettuce" || items[0].Version != 1 {
+			t.Errorf("%s: wanted the catalog untouched but got %v", name, items)
+		}
+	}
+}
+
+func TestBatchReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	f := openTestFileStore(t, dir)
+	results, err := f.Batch(
+		&BatchOp{Op: BatchCreate, Product: &models.Product{Name: "Basil", Price: models.NewMoney(249, "USD")}},
+		&BatchOp{Op: BatchCreate, Product: &models.Product{Name: "Chives", Price: models.NewMoney(199, "USD")}},
+	)
+	if err != nil {
+		t.Fatalf("batch failed [ERR: %s]", err)
+	}
+	if _, err := f.Batch(&BatchOp{Op: BatchDelete, Code: results[0].Code}); err != nil {
+		t.Fatalf("batch failed [ERR: %s]", err)
+	}
+	f.wal.Close() // crash: no snapshot
+
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+
+	if items := f.List(); len(items) != 1 || items[0].Name != "Chives" {
+		t.Errorf("wanted only Chives after replay but got %v", items)
+	}
+}
+
+func TestPutLeavesCodesOnFailure(t *testing.T) {
+	valid := &models.Product{Name: "Basil", Price: models.NewMoney(249, "USD")}
+
+	if _, errs := NewDatabase().Put(valid, &models.Product{Name: ""}); len(errs) == 0 {
+		t.Fatal("wanted the invalid product rejected")
+	}
+	if valid.Code != "" || valid.Version != 0 {
+		t.Errorf("wanted no code on a rejected put but got %q v%d", valid.Code, valid.Version)
+	}
+}
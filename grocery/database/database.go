@@ -2,57 +2,99 @@ package database
 
 import (
 	"errors"
-	"fmt"
 	"log"
 	"strings"
 	"sync"
+	"time"
 
+	"grocery/config"
 	"grocery/models"
-	"grocery/shared"
 )
 
 var (
-	DB *Database
+	DB Store
 
 	DummyData = []*models.Product{
-		{"A12T-4GH7-QPL9-3N4M", "Lettuce", 3.46},
-		{"E5T6-9UI3-TH15-QR88", "Peach", 2.99},
-		{"YRT6-72AS-K736-L4AR", "Green Pepper", 0.79},
-		{"TQ4C-VV6T-75ZX-1RMR", "Gala Apple", 3.59},
+		{Code: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", Price: models.NewMoney(346, "USD")},
+		{Code: "E5T6-9UI3-TH15-QR88", Name: "Peach", Price: models.NewMoney(299, "USD")},
+		{Code: "YRT6-72AS-K736-L4AR", Name: "Green Pepper", Price: models.NewMoney(79, "USD")},
+		{Code: "TQ4C-VV6T-75ZX-1RMR", Name: "Gala Apple", Price: models.NewMoney(359, "USD")},
 	}
 )
 
 type (
+	// Database is the in-memory storage driver, registered as "memory".
 	Database struct {
 		sync.RWMutex
 
 		Items []*models.Product
-	}
-)
+		// Deleted holds the trashed products, oldest deletion first.
+		Deleted []*models.Product
 
-func Connect() *Database {
-	if DB == nil {
-		DB = new(Database)
-		loadDummyData(DB)
+		index *Index
 	}
+)
 
-	return DB
+func init() {
+	Register("memory", func() (Store, error) {
+		d := NewDatabase()
+		loadDummyData(d)
+		return d, nil
+	})
 }
 
-func (d *Database) Search(name string) (found []*models.Product) {
-	d.RLock()
+func NewDatabase() *Database {
+	return &Database{index: NewIndex()}
+}
 
-	for _, item := range d.Items {
-		iName := strings.ToLower(item.Name)
-		name = strings.ToLower(name)
-		if strings.Contains(iName, name) {
-			found = append(found, item)
+// Connect opens the driver named by config.DBDRIVER, the stock ledger, the
+// store directory, the price schedule and the audit log the first time it is
+// called and returns the shared store from then on. The ledgers are kept in
+// the driver's Journal when it has one.
+func Connect() Store {
+	if DB == nil {
+		store, err := Open(config.DBDRIVER)
+		if err != nil {
+			log.Fatalf("connecting to database [ERR: %s]", err)
 		}
+		DB = store
+	}
+	if Inventory == nil {
+		stock, err := OpenStock(journalOf(DB), _stockKind, config.BACKORDERS)
+		if err != nil {
+			log.Fatalf("loading stock [ERR: %s]", err)
+		}
+		Inventory = stock
+	}
+	if Stores == nil {
+		stores, err := OpenStoreDirectory(journalOf(DB))
+		if err != nil {
+			log.Fatalf("loading stores [ERR: %s]", err)
+		}
+		Stores = stores
+	}
+	if Prices == nil {
+		prices, err := OpenPriceSchedule(journalOf(DB))
+		if err != nil {
+			log.Fatalf("loading price schedule [ERR: %s]", err)
+		}
+		Prices = prices
+	}
+	if Audit == nil {
+		Audit = openAudit()
 	}
 
-	d.RUnlock()
+	return DB
+}
 
-	return
+// Search ranks the products matching keyword by relevance.
+func (d *Database) Search(keyword string) []*Match {
+	return d.index.Search(keyword)
+}
+
+// Suggest completes a partly typed product name.
+func (d *Database) Suggest(prefix string, limit int) []string {
+	return d.index.Suggest(prefix, limit)
 }
 
 func (d *Database) Get(code string) *models.Product {
@@ -72,40 +114,257 @@ func (d *Database) Get(code string) *models.Product {
 	return nil
 }
 
-func (d *Database) Put(items ...*models.Product) (products []*models.Product, errs []error) {
-	for _, item := range items {
-		if !shared.IsAlphaNum(item.Name) {
-			errs = append(errs, fmt.Errorf("name %q is not alphanumeric", item.Name))
-		}
-		if len(errs) > 0 {
-			return
+func (d *Database) List() []*models.Product {
+	d.RLock()
+	items := make([]*models.Product, len(d.Items))
+	copy(items, d.Items)
+	d.RUnlock()
+
+	return items
+}
+
+// Each calls fn on a copy of the product list so fn runs without the lock
+// held; the products themselves are already in memory.
+func (d *Database) Each(fn func(*models.Product) error) error {
+	for _, item := range d.List() {
+		if err := fn(item); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		item.Code = shared.GenProductCode()
-		item.Price = shared.RoundFloat(item.Price, 2)
+func (d *Database) Put(items ...*models.Product) (products []*models.Product, errs []error) {
+	if errs = prepare(items); len(errs) > 0 {
+		return
 	}
 
-	d.Lock()
-	d.Items = append(d.Items, items...)
-	d.Unlock()
+	d.insert(items...)
 
 	return items, nil
 }
 
-func (d *Database) Del(code string) (err error) {
+func (d *Database) Update(code string, item *models.Product, version int64) (*models.Product, error) {
+	if code == "" {
+		return nil, errors.New("invalid product code")
+	}
+	if err := validate(item); err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	i := d.find(code)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	existing := d.Items[i]
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+
+	item.Code = existing.Code
+	item.Version = existing.Version + 1
+	d.Items[i] = item
+	d.index.Add(item)
+
+	return item, nil
+}
+
+func (d *Database) Del(code string, version int64) (err error) {
 	if code == "" {
 		return errors.New("invalid product code")
 	}
 
-	for i, item := range d.Items {
+	d.Lock()
+	defer d.Unlock()
+
+	existing := d.get(code)
+	if existing == nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+	d.set(trashed(existing))
+
+	return nil
+}
+
+// Trash returns the trashed products, most recently deleted first.
+func (d *Database) Trash() []*models.Product {
+	d.RLock()
+	items := make([]*models.Product, len(d.Deleted))
+	for i, item := range d.Deleted {
+		items[len(items)-1-i] = item
+	}
+	d.RUnlock()
+
+	return items
+}
+
+func (d *Database) Restore(code string, version int64) (*models.Product, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	i := d.findDeleted(code)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(d.Deleted[i], version); err != nil {
+		return nil, err
+	}
+
+	item := restored(d.Deleted[i])
+	d.set(item)
+
+	return item, nil
+}
+
+func (d *Database) Purge(before time.Time) (codes []string, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, code := range d.expired(before) {
+		d.drop(code)
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// Batch applies ops atomically under the lock.
+func (d *Database) Batch(ops ...*BatchOp) ([]*BatchResult, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	results, err := stageBatch(d.get, ops)
+	if err != nil {
+		return results, err
+	}
+	d.apply(results)
+
+	return results, nil
+}
+
+// apply makes the changes of a staged batch. Callers hold the lock.
+func (d *Database) apply(results []*BatchResult) {
+	for _, result := range results {
+		d.set(result.Product)
+	}
+}
+
+// get is Get for callers holding the lock.
+func (d *Database) get(code string) *models.Product {
+	if i := d.find(code); i >= 0 {
+		return d.Items[i]
+	}
+
+	return nil
+}
+
+// expired returns the codes of the products trashed before the given time.
+// Callers hold the lock.
+func (d *Database) expired(before time.Time) (codes []string) {
+	for _, item := range d.Deleted {
+		if item.DeletedAt.Before(before) {
+			codes = append(codes, item.Code)
+		}
+	}
+
+	return
+}
+
+// find returns the position of the live product with the given code, or -1.
+// Callers hold the lock.
+func (d *Database) find(code string) int {
+	return findCode(d.Items, code)
+}
+
+// findDeleted is find for the trash.
+func (d *Database) findDeleted(code string) int {
+	return findCode(d.Deleted, code)
+}
+
+func findCode(items []*models.Product, code string) int {
+	for i, item := range items {
 		if strings.EqualFold(item.Code, code) {
-			d.Lock()
+			return i
+		}
+	}
+
+	return -1
+}
+
+// set stores item in place of any product with the same code, live or
+// trashed, filing it in the trash when it has been deleted. A live product
+// keeps its place in the catalog. Callers hold the lock.
+func (d *Database) set(item *models.Product) {
+	if i := d.findDeleted(item.Code); i >= 0 {
+		d.Deleted = append(d.Deleted[:i], d.Deleted[i+1:]...)
+	}
+
+	i := d.find(item.Code)
+	switch {
+	case item.DeletedAt != nil:
+		if i >= 0 {
 			d.Items = append(d.Items[:i], d.Items[i+1:]...)
-			d.Unlock()
 		}
+		d.Deleted = append(d.Deleted, item)
+		d.index.Remove(item.Code)
+		return
+	case i >= 0:
+		d.Items[i] = item
+	default:
+		d.Items = append(d.Items, item)
 	}
+	d.index.Add(item)
+}
 
-	return nil
+// drop permanently removes the product with the given code, live or
+// trashed, reporting whether one was found. Callers hold the lock.
+func (d *Database) drop(code string) bool {
+	found := false
+	if i := d.find(code); i >= 0 {
+		d.Items = append(d.Items[:i], d.Items[i+1:]...)
+		d.index.Remove(code)
+		found = true
+	}
+	if i := d.findDeleted(code); i >= 0 {
+		d.Deleted = append(d.Deleted[:i], d.Deleted[i+1:]...)
+		found = true
+	}
+
+	return found
+}
+
+// insert adds items to the catalog, or the trash, without validating them.
+func (d *Database) insert(items ...*models.Product) {
+	d.Lock()
+	for _, item := range items {
+		d.set(item)
+	}
+	d.Unlock()
+}
+
+// all returns the live products followed by the trashed ones.
+func (d *Database) all() []*models.Product {
+	d.RLock()
+	defer d.RUnlock()
+
+	items := make([]*models.Product, 0, len(d.Items)+len(d.Deleted))
+	return append(append(items, d.Items...), d.Deleted...)
+}
+
+// remove permanently drops the product with the given code, reporting
+// whether one was found.
+func (d *Database) remove(code string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.drop(code)
 }
 
 func loadDummyData(d *Database) {
@@ -114,5 +373,11 @@ func loadDummyData(d *Database) {
 		log.Print("dummy data loaded")
 	}()
 
-	d.Items = append(d.Items, DummyData...)
+	for _, item := range DummyData {
+		if item.Version == 0 {
+			item.Version = 1
+		}
+	}
+
+	d.insert(DummyData...)
 }
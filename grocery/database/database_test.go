@@ -1,6 +1,8 @@
 package database
 
 import (
+	"encoding/json"
+	"errors"
 	"grocery/models"
 	"testing"
 )
@@ -29,13 +31,15 @@ func TestGet(t *testing.T) {
 }
 
 func TestPut(t *testing.T) {
-	wantedPrice := 9.81
+	wantedPrice := models.NewMoney(981, "USD")
+
+	var item *models.Product
+	if err := json.Unmarshal([]byte(`{"name": "Waffles", "price": 9.8111111}`), &item); err != nil {
+		t.Fatalf("failed to decode product [ERR: %s]", err)
+	}
 
 	db := Connect()
-	items, errs := db.Put(&models.Product{
-		Name:  "Waffles",
-		Price: 9.8111111,
-	})
+	items, errs := db.Put(item)
 	if len(errs) > 0 {
 		t.Errorf("errors when creating product(s) [ERR: %s]", errs)
 	}
@@ -46,11 +50,129 @@ func TestPut(t *testing.T) {
 	}
 }
 
-func TestDel(t *testing.T) {
+func TestList(t *testing.T) {
+	db := Connect()
+	if items := db.List(); len(items) < len(DummyData) {
+		t.Errorf("wanted at least %d listed items but got %d", len(DummyData), len(items))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := Connect()
+	code := DummyData[1].Code
+
+	item, err := db.Update(code, &models.Product{Name: "White Peach", Price: models.NewMoney(333, "USD")}, 0)
+	if err != nil {
+		t.Fatalf("failed to update product [ERR: %s]", err)
+	}
+	if item.Code != code || item.Price.Amount != 333 {
+		t.Errorf("unexpected updated product %s", item)
+	}
+	if got := db.Get(code); got == nil || got.Name != "White Peach" {
+		t.Errorf("update not visible through Get; got %s", got)
+	}
+
+	if _, err := db.Update("this-isnt-real-code", &models.Product{Name: "Plum"}, 0); err != ErrNotFound {
+		t.Errorf("wanted ErrNotFound for missing product but got %v", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
 	db := Connect()
+
+	items, errs := db.Put(&models.Product{Name: "Pancakes", Price: models.NewMoney(500, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	code := items[0].Code
+	if items[0].Version != 1 {
+		t.Fatalf("wanted new product at version 1 but got %d", items[0].Version)
+	}
+
+	item, err := db.Update(code, &models.Product{Name: "Pancakes", Price: models.NewMoney(600, "USD")}, 1)
+	if err != nil {
+		t.Fatalf("failed to update product at current version [ERR: %s]", err)
+	}
+	if item.Version != 2 {
+		t.Errorf("wanted version 2 after update but got %d", item.Version)
+	}
+
+	if _, err := db.Update(code, &models.Product{Name: "Pancakes", Price: models.NewMoney(700, "USD")}, 1); err != ErrVersionMismatch {
+		t.Errorf("wanted ErrVersionMismatch updating stale version but got %v", err)
+	}
+	if err := db.Del(code, 1); err != ErrVersionMismatch {
+		t.Errorf("wanted ErrVersionMismatch deleting stale version but got %v", err)
+	}
+	if err := db.Del(code, 2); err != nil {
+		t.Errorf("failed to delete product at current version [ERR: %s]", err)
+	}
+	if db.Get(code) != nil {
+		t.Error("product still present after delete")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var fieldTable = map[string]*models.Product{
+		"name":        {Name: ""},
+		"price":       {Name: "Milk", Price: models.NewMoney(-100, "USD")},
+		"sku":         {Name: "Milk", SKU: "MLK_1"},
+		"barcode":     {Name: "Milk", Barcode: "036000291453"},
+		"description": {Name: "Milk", Description: string(make([]byte, _maxDescriptionLen+1))},
+		"unit":        {Name: "Milk", Unit: "gallon"},
+		"size":        {Name: "Milk", Unit: "oz", Size: -1},
+	}
+
+	for field, item := range fieldTable {
+		err := validate(item)
+
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != field {
+			t.Errorf("wanted error naming %q but got %v", field, err)
+		}
+		if !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("wanted %q error to be ErrInvalidProduct but got %v", field, err)
+		}
+	}
+
+	item := &models.Product{Name: " Whole Milk ", Barcode: "036000291452", Category: " Dairy", Unit: "OZ", Size: 64}
+	if err := validate(item); err != nil {
+		t.Fatalf("failed to validate product [ERR: %s]", err)
+	}
+	if item.Name != "Whole Milk" || item.Category != "dairy" || item.Unit != models.UnitOunce {
+		t.Errorf("product not normalized; got %s", item)
+	}
+}
+
+func TestSearchDetails(t *testing.T) {
+	db := Connect()
+
+	items, errs := db.Put(&models.Product{Name: "Cola", Brand: "Fizzco", Category: "beverages", Barcode: "4006381333931", SKU: "BEV-001"})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+
+	for _, keyword := range []string{"fizz", "Beverages", "4006381333931", "bev-001"} {
+		found := false
+		for _, item := range db.Search(keyword) {
+			found = found || item.Code == items[0].Code
+		}
+		if !found {
+			t.Errorf("search for %q failed to locate product", keyword)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("this-isnt-a-driver"); err == nil {
+		t.Error("expected error opening unknown driver")
+	}
+}
+
+func TestDel(t *testing.T) {
+	db := Connect().(*Database)
 	initialDBSize := len(db.Items)
 
-	db.Del("A12T-4GH7-QPL9-3N4M")
+	db.Del("A12T-4GH7-QPL9-3N4M", 0)
 
 	if len(db.Items) >= initialDBSize {
 		t.Error("failed to delete item from database")
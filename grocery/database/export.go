@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"grocery/models"
+)
+
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportJSON   = "json"
+
+	// _formulaStart are the characters a spreadsheet takes a cell starting
+	// with to be a formula.
+	_formulaStart = "=+-@"
+)
+
+var (
+	// ExportColumns are the CSV columns an export writes, in order. Import
+	// reads them back, ignoring code and version.
+	ExportColumns = []string{"code", "name", "price", "currency", "sku", "barcode", "brand", "category", "description", "unit", "size", "version"}
+)
+
+type (
+	// Products calls fn with each product in turn, stopping at and
+	// returning the first error fn returns. Store.Each is one.
+	Products func(fn func(*models.Product) error) error
+)
+
+// Export writes products to w in the given format (ExportCSV, ExportNDJSON
+// or ExportJSON), encoding each one as it is read, so only w's buffer is
+// held in memory.
+func Export(w io.Writer, format string, products Products) error {
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, products)
+	case ExportNDJSON:
+		return exportNDJSON(w, products)
+	case ExportJSON:
+		return exportJSON(w, products)
+	}
+
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// ProductsOf returns Products over items.
+func ProductsOf(items []*models.Product) Products {
+	return func(fn func(*models.Product) error) error {
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func exportCSV(w io.Writer, products Products) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ExportColumns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(ExportColumns))
+	err := products(func(item *models.Product) error {
+		record[0] = item.Code
+		record[1] = csvCell(item.Name)
+		record[2] = item.Price.String()
+		record[3] = item.Price.Currency
+		record[4] = csvCell(item.SKU)
+		record[5] = csvCell(item.Barcode)
+		record[6] = csvCell(item.Brand)
+		record[7] = csvCell(item.Category)
+		record[8] = csvCell(item.Description)
+		record[9] = csvCell(item.Unit)
+		record[10] = ""
+		if item.Size != 0 {
+			record[10] = strconv.FormatFloat(item.Size, 'f', -1, 64)
+		}
+		record[11] = strconv.FormatInt(item.Version, 10)
+
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell keeps a text cell from being read as a formula by a spreadsheet,
+// quoting it with a leading ' when it starts with =, +, - or @. Import takes
+// the quote off again.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune(_formulaStart, rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// fromCSVCell undoes csvCell.
+func fromCSVCell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(_formulaStart, rune(s[1])) {
+		return s[1:]
+	}
+
+	return s
+}
+
+func exportNDJSON(w io.Writer, products Products) error {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+
+	if err := products(func(item *models.Product) error { return encoder.Encode(item) }); err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
+
+// exportJSON writes a JSON array, encoding one product at a time.
+func exportJSON(w io.Writer, products Products) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString("[")
+
+	first := true
+	err := products(func(item *models.Product) error {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if !first {
+			buf.WriteString(",\n")
+		}
+		first = false
+		buf.Write(b)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	buf.WriteString("]\n")
+	return buf.Flush()
+}
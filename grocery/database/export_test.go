@@ -0,0 +1,142 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"grocery/models"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	items := queryTestProducts()
+
+	for _, format := range []string{ExportCSV, ExportNDJSON} {
+		var buf bytes.Buffer
+		if err := Export(&buf, format, ProductsOf(items)); err != nil {
+			t.Fatalf("%s: failed to export [ERR: %s]", format, err)
+		}
+
+		db := NewDatabase()
+		report, err := Import(db, &buf, format, ImportAtomic)
+		if err != nil {
+			t.Fatalf("%s: failed to import the export [ERR: %s]", format, err)
+		}
+		if report.Created != len(items) {
+			t.Fatalf("%s: wanted %d imported but got %+v", format, len(items), report.Rows)
+		}
+
+		for i, row := range report.Rows {
+			got, want := db.Get(row.Code), items[i]
+			if got.Name != want.Name || got.Price != want.Price || got.Unit != want.Unit || got.Size != want.Size {
+				t.Errorf("%s: wanted %s but got %s", format, want, got)
+			}
+		}
+	}
+}
+
+func TestStoreEach(t *testing.T) {
+	// more than a page, so the sqlite store has to read on past the first
+	items := make([]*models.Product, _eachPageSize+2)
+	for i := range items {
+		items[i] = &models.Product{Name: fmt.Sprintf("Apple %d", i), Price: models.NewMoney(100, "USD")}
+	}
+
+	for name, store := range testStores(t) {
+		if _, errs := store.Put(items...); len(errs) > 0 {
+			t.Fatalf("%s: failed to seed [ERR: %s]", name, errs)
+		}
+		if err := store.Del(items[1].Code, 0); err != nil {
+			t.Fatalf("%s: failed to delete [ERR: %s]", name, err)
+		}
+
+		var codes []string
+		err := store.Each(func(item *models.Product) error {
+			codes = append(codes, item.Code)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to read products [ERR: %s]", name, err)
+		}
+
+		list := store.List()
+		if len(codes) != len(list) {
+			t.Fatalf("%s: wanted the %d listed products but got %d", name, len(list), len(codes))
+		}
+		for i, item := range list {
+			if codes[i] != item.Code {
+				t.Errorf("%s: wanted %s at %d, as listed, but got %s", name, item.Code, i, codes[i])
+				break
+			}
+		}
+
+		stop := errors.New("stop")
+		var seen int
+		err = store.Each(func(*models.Product) error {
+			seen++
+			return stop
+		})
+		if err != stop || seen != 1 {
+			t.Errorf("%s: wanted Each to stop at the first error but got %v after %d products", name, err, seen)
+		}
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, ExportJSON, ProductsOf(queryTestProducts())); err != nil {
+		t.Fatalf("failed to export [ERR: %s]", err)
+	}
+
+	var items []*models.Product
+	if err := json.Unmarshal(buf.Bytes(), &items); err != nil {
+		t.Fatalf("export is not a JSON array [ERR: %s]", err)
+	}
+	if len(items) != len(queryTestProducts()) {
+		t.Errorf("wanted %d products but got %d", len(queryTestProducts()), len(items))
+	}
+
+	buf.Reset()
+	if err := Export(&buf, ExportJSON, ProductsOf(nil)); err != nil || strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("wanted an empty array but got %q [ERR: %v]", buf.String(), err)
+	}
+
+	if err := Export(&buf, "xlsx", ProductsOf(nil)); err == nil {
+		t.Error("wanted an error for an unknown format")
+	}
+}
+
+func TestExportCSVFormulas(t *testing.T) {
+	item := &models.Product{
+		Name:        "Lemons",
+		Price:       models.NewMoney(99, "USD"),
+		Brand:       `=HYPERLINK("http://example.com")`,
+		Category:    "@produce",
+		Description: "+2 for 1",
+		SKU:         "-44",
+		Unit:        "each",
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, ExportCSV, ProductsOf([]*models.Product{item})); err != nil {
+		t.Fatalf("failed to export [ERR: %s]", err)
+	}
+	for _, cell := range []string{`'=HYPERLINK`, "'@produce", "'+2 for 1", "'-44", ",Lemons,", ",each,"} {
+		if !strings.Contains(buf.String(), cell) {
+			t.Errorf("wanted %q in the export but got %q", cell, buf.String())
+		}
+	}
+
+	db := NewDatabase()
+	report, err := Import(db, &buf, ExportCSV, ImportAtomic)
+	if err != nil || report.Created != 1 {
+		t.Fatalf("failed to import the export: %+v [ERR: %v]", report, err)
+	}
+	got := db.Get(report.Rows[0].Code)
+	if got.Brand != item.Brand || got.Category != item.Category || got.Description != item.Description || got.SKU != item.SKU {
+		t.Errorf("wanted the quotes taken off on import but got %+v", got)
+	}
+}
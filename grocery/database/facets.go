@@ -0,0 +1,129 @@
+package database
+
+import (
+	"sort"
+	"strings"
+
+	"grocery/models"
+)
+
+var (
+	// PriceBands are the upper bounds, in major currency units, of the price
+	// facet's bands. A final open band counts everything above the last one.
+	PriceBands = []string{"1", "2", "5", "10", "20"}
+)
+
+type (
+	// Facets counts a set of products by category, brand and price band.
+	Facets struct {
+		Category []*FacetCount `json:"category"`
+		Brand    []*FacetCount `json:"brand"`
+		Price    []*PriceBand  `json:"price"`
+	}
+
+	FacetCount struct {
+		Value string `json:"value"`
+		Count int    `json:"count"`
+	}
+
+	// PriceBand counts the products priced from Min up to but excluding Max.
+	// Max is nil for the open top band.
+	PriceBand struct {
+		Label string        `json:"label"`
+		Min   models.Money  `json:"min"`
+		Max   *models.Money `json:"max,omitempty"`
+		Count int           `json:"count"`
+	}
+)
+
+// ComputeFacets counts items by category, brand and price band. Values are
+// ordered by count, most first; empty categories and brands are skipped.
+// Price bands are built per currency and only bands with products are kept.
+func ComputeFacets(items []*models.Product) *Facets {
+	var (
+		categories = map[string]int{}
+		brands     = map[string]int{}
+		bands      = map[string][]*PriceBand{}
+		currencies []string
+	)
+
+	for _, item := range items {
+		if item.Category != "" {
+			categories[item.Category]++
+		}
+		if item.Brand != "" {
+			brands[item.Brand]++
+		}
+
+		currency := item.Price.Currency
+		if _, ok := bands[currency]; !ok {
+			bands[currency] = priceBands(currency)
+			currencies = append(currencies, currency)
+		}
+		for _, band := range bands[currency] {
+			if !item.Price.Less(band.Min) && (band.Max == nil || item.Price.Less(*band.Max)) {
+				band.Count++
+				break
+			}
+		}
+	}
+
+	facets := &Facets{
+		Category: facetCounts(categories),
+		Brand:    facetCounts(brands),
+		Price:    []*PriceBand{},
+	}
+
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		for _, band := range bands[currency] {
+			if band.Count > 0 {
+				facets.Price = append(facets.Price, band)
+			}
+		}
+	}
+
+	return facets
+}
+
+func facetCounts(counts map[string]int) []*FacetCount {
+	facets := make([]*FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, &FacetCount{Value: value, Count: count})
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return strings.ToLower(facets[i].Value) < strings.ToLower(facets[j].Value)
+	})
+
+	return facets
+}
+
+// priceBands returns empty bands for currency following PriceBands.
+func priceBands(currency string) []*PriceBand {
+	var (
+		bands []*PriceBand
+		min   = models.NewMoney(0, currency)
+	)
+
+	for _, bound := range PriceBands {
+		max, err := models.ParseMoney(bound, currency)
+		if err != nil {
+			continue
+		}
+		bands = append(bands, &PriceBand{
+			Label: min.String() + "-" + max.String() + " " + currency,
+			Min:   min,
+			Max:   &max,
+		})
+		min = max
+	}
+
+	return append(bands, &PriceBand{
+		Label: min.String() + "+ " + currency,
+		Min:   min,
+	})
+}
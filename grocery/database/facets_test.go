@@ -0,0 +1,57 @@
+package database
+
+import (
+	"testing"
+
+	"grocery/models"
+)
+
+func TestComputeFacets(t *testing.T) {
+	facets := ComputeFacets(queryTestProducts())
+
+	var categoryTable = []struct {
+		value string
+		count int
+	}{
+		{"produce", 3},
+		{"beverages", 1},
+		{"candy", 1},
+	}
+
+	if len(facets.Category) != len(categoryTable) {
+		t.Fatalf("wanted %d category facets but got %d", len(categoryTable), len(facets.Category))
+	}
+	for i, tc := range categoryTable {
+		if got := facets.Category[i]; got.Value != tc.value || got.Count != tc.count {
+			t.Errorf("category facet %d: wanted %s (%d) but got %s (%d)", i, tc.value, tc.count, got.Value, got.Count)
+		}
+	}
+
+	if len(facets.Brand) != 1 || facets.Brand[0].Value != "Leafy" || facets.Brand[0].Count != 1 {
+		t.Errorf("wanted a single Leafy brand facet but got %+v", facets.Brand)
+	}
+
+	var priceTable = []struct {
+		label string
+		count int
+	}{
+		{"0.00-1.00 EUR", 1},
+		{"0.00-1.00 USD", 1},
+		{"1.00-2.00 USD", 1},
+		{"2.00-5.00 USD", 2},
+	}
+
+	if len(facets.Price) != len(priceTable) {
+		t.Fatalf("wanted %d price bands but got %d", len(priceTable), len(facets.Price))
+	}
+	for i, tc := range priceTable {
+		if got := facets.Price[i]; got.Label != tc.label || got.Count != tc.count {
+			t.Errorf("price band %d: wanted %s (%d) but got %s (%d)", i, tc.label, tc.count, got.Label, got.Count)
+		}
+	}
+
+	top := ComputeFacets([]*models.Product{{Name: "Saffron", Price: models.NewMoney(2500, "USD")}})
+	if len(top.Price) != 1 || top.Price[0].Label != "20.00+ USD" || top.Price[0].Max != nil {
+		t.Errorf("wanted the open top band but got %+v", top.Price)
+	}
+}
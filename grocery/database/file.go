@@ -0,0 +1,612 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	_walFile      = "products.wal"
+	_snapshotFile = "products.snapshot"
+	// _recordsFile is the snapshot of the journal records.
+	_recordsFile = "records.snapshot"
+
+	_opPut    = "put"
+	_opDel    = "del"
+	_opBatch  = "batch"
+	_opRecord = "record"
+
+	// each log record is a big-endian payload length and CRC-32 followed by
+	// the JSON payload itself
+	_recordHeaderLen = 8
+	_maxRecordLen    = 16 << 20
+)
+
+var (
+	DefaultSnapshotEvery = 1000
+
+	errTornRecord = errors.New("torn log record")
+)
+
+type (
+	// FileStore is the durable storage driver, registered as "file". Reads
+	// are served from an in-memory Database; every write, to the catalog or
+	// the journal, is appended to a write-ahead log before it is applied, and
+	// both are periodically snapshotted so the log stays short.
+	FileStore struct {
+		*Database
+
+		// SnapshotEvery is the number of logged writes between snapshots.
+		SnapshotEvery int
+
+		mu      sync.Mutex
+		dir     string
+		wal     *os.File
+		pending int
+		// records are the journal's, by kind and key.
+		records map[string]map[string]json.RawMessage
+	}
+
+	walRecord struct {
+		Op      string          `json:"op"`
+		Code    string          `json:"code,omitempty"`
+		Product *models.Product `json:"product,omitempty"`
+		// Batch holds the puts and deletes of a batch, logged as one record
+		// so a crash can't leave part of it applied.
+		Batch []*walRecord `json:"batch,omitempty"`
+		// Record is a journal record saved or, without a value, deleted.
+		Record *Record `json:"record,omitempty"`
+	}
+)
+
+func init() {
+	Register("file", func() (Store, error) {
+		return OpenFile(config.DBPATH)
+	})
+}
+
+// OpenFile opens (creating if needed) the file store kept in dir, loading the
+// latest snapshot and replaying the write-ahead log on top of it.
+func OpenFile(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		Database:      NewDatabase(),
+		SnapshotEvery: DefaultSnapshotEvery,
+		dir:           dir,
+		records:       make(map[string]map[string]json.RawMessage),
+	}
+
+	if err := f.loadSnapshot(); err != nil {
+		return nil, fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, _walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.wal = wal
+
+	if err := f.replay(); err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("replaying log: %w", err)
+	}
+
+	return f, nil
+}
+
+func (f *FileStore) Put(items ...*models.Product) (products []*models.Product, errs []error) {
+	if errs = prepare(items); len(errs) > 0 || len(items) == 0 {
+		return
+	}
+
+	// several items are logged as one batch so a crash can't keep only some
+	// of them
+	rec := &walRecord{Op: _opPut, Product: items[0]}
+	if len(items) > 1 {
+		rec = &walRecord{Op: _opBatch}
+		for _, item := range items {
+			rec.Batch = append(rec.Batch, &walRecord{Op: _opPut, Product: item})
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.append(rec); err != nil {
+		return nil, []error{err}
+	}
+	f.Database.insert(items...)
+	f.maybeSnapshot()
+
+	return items, nil
+}
+
+func (f *FileStore) Update(code string, item *models.Product, version int64) (*models.Product, error) {
+	if code == "" {
+		return nil, errors.New("invalid product code")
+	}
+	if err := validate(item); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing := f.Database.Get(code)
+	if existing == nil {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+	item.Code = existing.Code
+	item.Version = existing.Version + 1
+
+	if err := f.write(item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (f *FileStore) Del(code string, version int64) error {
+	if code == "" {
+		return errors.New("invalid product code")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing := f.Database.Get(code)
+	if existing == nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+
+	return f.write(trashed(existing))
+}
+
+func (f *FileStore) Restore(code string, version int64) (*models.Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Database.RLock()
+	var existing *models.Product
+	if i := f.Database.findDeleted(code); i >= 0 {
+		existing = f.Database.Deleted[i]
+	}
+	f.Database.RUnlock()
+
+	if existing == nil {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+
+	item := restored(existing)
+	if err := f.write(item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// Purge logs the removals as a single record.
+func (f *FileStore) Purge(before time.Time) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Database.RLock()
+	codes := f.Database.expired(before)
+	f.Database.RUnlock()
+
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	rec := &walRecord{Op: _opBatch}
+	for _, code := range codes {
+		rec.Batch = append(rec.Batch, &walRecord{Op: _opDel, Code: code})
+	}
+	if err := f.append(rec); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		f.Database.remove(code)
+	}
+	f.maybeSnapshot()
+
+	return codes, nil
+}
+
+// write logs and applies a single put. Callers hold f.mu.
+func (f *FileStore) write(item *models.Product) error {
+	if err := f.append(&walRecord{Op: _opPut, Product: item}); err != nil {
+		return err
+	}
+	f.Database.insert(item)
+	f.maybeSnapshot()
+
+	return nil
+}
+
+// Batch applies ops atomically, logging them as a single record.
+func (f *FileStore) Batch(ops ...*BatchOp) ([]*BatchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results, err := stageBatch(f.Database.Get, ops)
+	if err != nil {
+		return results, err
+	}
+
+	rec := &walRecord{Op: _opBatch}
+	for _, result := range results {
+		rec.Batch = append(rec.Batch, &walRecord{Op: _opPut, Product: result.Product})
+	}
+
+	if err := f.append(rec); err != nil {
+		return nil, err
+	}
+	f.Database.Lock()
+	f.Database.apply(results)
+	f.Database.Unlock()
+	f.maybeSnapshot()
+
+	return results, nil
+}
+
+// Save logs the journal records as a single log record and applies them.
+func (f *FileStore) Save(records ...*Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec := &walRecord{Op: _opBatch}
+	for _, r := range records {
+		rec.Batch = append(rec.Batch, &walRecord{Op: _opRecord, Record: r})
+	}
+
+	if err := f.append(rec); err != nil {
+		return err
+	}
+	for _, r := range records {
+		f.setRecord(r)
+	}
+	f.maybeSnapshot()
+
+	return nil
+}
+
+// Records returns the journal records of a kind, ordered by key.
+func (f *FileStore) Records(kind string) ([]*Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records := make([]*Record, 0, len(f.records[kind]))
+	for key, value := range f.records[kind] {
+		records = append(records, &Record{Kind: kind, Key: key, Value: value})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+
+	return records, nil
+}
+
+// setRecord applies a journal record in memory. Callers hold f.mu, or have
+// the store to themselves while opening it.
+func (f *FileStore) setRecord(r *Record) {
+	if r.Value == nil {
+		delete(f.records[r.Kind], r.Key)
+		return
+	}
+
+	if f.records[r.Kind] == nil {
+		f.records[r.Kind] = make(map[string]json.RawMessage)
+	}
+	f.records[r.Kind][r.Key] = r.Value
+}
+
+// Snapshot writes the full catalog to disk and truncates the log.
+func (f *FileStore) Snapshot() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.snapshot()
+}
+
+// Close snapshots the catalog and closes the log.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.wal == nil {
+		return nil
+	}
+
+	err := f.snapshot()
+	if cerr := f.wal.Close(); err == nil {
+		err = cerr
+	}
+	f.wal = nil
+
+	return err
+}
+
+// append writes and syncs records to the end of the log. Callers hold f.mu.
+func (f *FileStore) append(records ...*walRecord) error {
+	if f.wal == nil {
+		return errors.New("file store is closed")
+	}
+
+	var buf []byte
+	for _, rec := range records {
+		payload, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+
+		var header [_recordHeaderLen]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+
+		buf = append(buf, header[:]...)
+		buf = append(buf, payload...)
+	}
+
+	end, err := f.wal.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.wal.Write(buf)
+	if err == nil {
+		err = f.wal.Sync()
+	}
+	if err != nil {
+		// drop whatever part of the write landed so later records aren't
+		// stranded behind a torn one
+		f.wal.Truncate(end)
+		return err
+	}
+
+	f.pending += len(records)
+
+	return nil
+}
+
+// maybeSnapshot snapshots once enough writes have been logged. A failed
+// snapshot is only logged since the write itself is already durable.
+func (f *FileStore) maybeSnapshot() {
+	if f.SnapshotEvery <= 0 || f.pending < f.SnapshotEvery {
+		return
+	}
+
+	if err := f.snapshot(); err != nil {
+		log.Printf("snapshotting file store [ERR: %s]", err)
+	}
+}
+
+// snapshot is Snapshot without locking. The catalog and the journal
+// records are each written to a temporary file and renamed into place, so a
+// crash leaves either the old or the new one; replaying a log that a
+// snapshot already covers is harmless because puts are applied as upserts.
+func (f *FileStore) snapshot() error {
+	var records []*Record
+	for kind, values := range f.records {
+		for key, value := range values {
+			records = append(records, &Record{Kind: kind, Key: key, Value: value})
+		}
+	}
+
+	if err := f.writeSnapshot(_recordsFile, records); err != nil {
+		return err
+	}
+	if err := f.writeSnapshot(_snapshotFile, f.Database.all()); err != nil {
+		return err
+	}
+
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		return err
+	}
+
+	f.pending = 0
+
+	return nil
+}
+
+// writeSnapshot writes v to the named snapshot file.
+func (f *FileStore) writeSnapshot(name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(f.dir, name)); err != nil {
+		return err
+	}
+	syncDir(f.dir)
+
+	return nil
+}
+
+func (f *FileStore) loadSnapshot() error {
+	var items []*models.Product
+	if err := readSnapshot(filepath.Join(f.dir, _snapshotFile), &items); err != nil {
+		return err
+	}
+	f.Database.insert(items...)
+
+	var records []*Record
+	if err := readSnapshot(filepath.Join(f.dir, _recordsFile), &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		f.setRecord(r)
+	}
+
+	return nil
+}
+
+// readSnapshot decodes the snapshot at path into v, leaving v alone if there
+// is no snapshot yet.
+func readSnapshot(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// replay applies every intact record in the log. A torn or corrupt record
+// can only be the result of a crash mid-append, so the log is truncated back
+// to the last good record and everything after it is discarded.
+func (f *FileStore) replay() error {
+	if _, err := f.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var (
+		r      = bufio.NewReader(f.wal)
+		offset int64
+	)
+
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !errors.Is(err, errTornRecord) {
+				return err
+			}
+
+			log.Printf("truncating file store log at offset %d [ERR: %s]", offset, err)
+			if err := f.wal.Truncate(offset); err != nil {
+				return err
+			}
+			if err := f.wal.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+
+		f.redo(rec)
+
+		offset += n
+		f.pending++
+	}
+
+	return nil
+}
+
+// redo applies a logged record to the in-memory catalog and journal.
+func (f *FileStore) redo(rec *walRecord) {
+	switch rec.Op {
+	case _opPut:
+		if rec.Product != nil {
+			f.Database.insert(rec.Product)
+		}
+	case _opDel:
+		f.Database.remove(rec.Code)
+	case _opBatch:
+		for _, r := range rec.Batch {
+			f.redo(r)
+		}
+	case _opRecord:
+		if rec.Record != nil {
+			f.setRecord(rec.Record)
+		}
+	}
+}
+
+// readRecord reads one record from r, returning it along with the number of
+// bytes it took up. io.EOF is only returned on a clean record boundary.
+func readRecord(r io.Reader) (*walRecord, int64, error) {
+	var header [_recordHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("%w: short header", errTornRecord)
+		}
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	sum := binary.BigEndian.Uint32(header[4:])
+	if size > _maxRecordLen {
+		return nil, 0, fmt.Errorf("%w: record length %d", errTornRecord, size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("%w: short payload", errTornRecord)
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", errTornRecord)
+	}
+
+	var rec walRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errTornRecord, err)
+	}
+
+	return &rec, int64(_recordHeaderLen) + int64(size), nil
+}
+
+// syncDir flushes a directory entry change such as a rename; not every
+// platform supports it, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grocery/models"
+)
+
+func openTestFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+
+	f, err := OpenFile(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store [ERR: %s]", err)
+	}
+
+	return f
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	f := openTestFileStore(t, dir)
+
+	items, errs := f.Put(
+		&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")},
+		&models.Product{Name: "Syrup", Price: models.NewMoney(625, "USD")},
+		&models.Product{Name: "Butter", Price: models.NewMoney(210, "USD")},
+	)
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	if _, err := f.Update(items[0].Code, &models.Product{Name: "Belgian Waffles", Price: models.NewMoney(500, "USD")}, 0); err != nil {
+		t.Fatalf("failed to update product [ERR: %s]", err)
+	}
+	if err := f.Del(items[2].Code, 0); err != nil {
+		t.Fatalf("failed to delete product [ERR: %s]", err)
+	}
+
+	// reopen without closing so the state has to come from the log alone
+	f.wal.Close()
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+
+	if got := len(f.List()); got != 2 {
+		t.Fatalf("wanted 2 products after replay but got %d", got)
+	}
+	if got := f.Get(items[0].Code); got == nil || got.Name != "Belgian Waffles" || got.Version != 2 {
+		t.Errorf("update not replayed; got %s", got)
+	}
+	if f.Get(items[2].Code) != nil {
+		t.Error("delete not replayed")
+	}
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	f := openTestFileStore(t, dir)
+	f.SnapshotEvery = 2
+
+	for _, name := range []string{"Milk", "Eggs", "Bread"} {
+		if _, errs := f.Put(&models.Product{Name: name, Price: models.NewMoney(100, "USD")}); len(errs) > 0 {
+			t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, _snapshotFile)); err != nil {
+		t.Fatalf("expected snapshot to be written [ERR: %s]", err)
+	}
+	if f.pending != 1 {
+		t.Errorf("wanted 1 record logged since the snapshot but got %d", f.pending)
+	}
+
+	f.wal.Close()
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+
+	if got := len(f.List()); got != 3 {
+		t.Errorf("wanted 3 products from snapshot and log but got %d", got)
+	}
+}
+
+func TestFileStoreTornRecord(t *testing.T) {
+	tornTable := map[string][]byte{
+		"short header":  {0, 0},
+		"short payload": {0, 0, 0, 40, 1, 2, 3, 4, '{', '"'},
+		"bad checksum":  {0, 0, 0, 2, 1, 2, 3, 4, '{', '}'},
+	}
+
+	for name, tail := range tornTable {
+		dir := t.TempDir()
+		f := openTestFileStore(t, dir)
+		if _, errs := f.Put(&models.Product{Name: "Milk", Price: models.NewMoney(100, "USD")}, &models.Product{Name: "Eggs", Price: models.NewMoney(200, "USD")}); len(errs) > 0 {
+			t.Fatalf("%s: errors when creating product(s) [ERR: %s]", name, errs)
+		}
+		f.wal.Close()
+
+		walPath := filepath.Join(dir, _walFile)
+		info, err := os.Stat(walPath)
+		if err != nil {
+			t.Fatalf("%s: failed to stat log [ERR: %s]", name, err)
+		}
+		goodSize := info.Size()
+
+		wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("%s: failed to open log [ERR: %s]", name, err)
+		}
+		wal.Write(tail)
+		wal.Close()
+
+		f = openTestFileStore(t, dir)
+		if got := len(f.List()); got != 2 {
+			t.Errorf("%s: wanted 2 products after recovery but got %d", name, got)
+		}
+		if info, _ := os.Stat(walPath); info.Size() != goodSize {
+			t.Errorf("%s: wanted log truncated to %d bytes but is %d", name, goodSize, info.Size())
+		}
+
+		// writes after recovery must survive another restart
+		if _, errs := f.Put(&models.Product{Name: "Bread", Price: models.NewMoney(300, "USD")}); len(errs) > 0 {
+			t.Fatalf("%s: errors when creating product(s) [ERR: %s]", name, errs)
+		}
+		f.wal.Close()
+
+		f = openTestFileStore(t, dir)
+		if got := len(f.List()); got != 3 {
+			t.Errorf("%s: wanted 3 products after second restart but got %d", name, got)
+		}
+		f.Close()
+	}
+}
+
+func TestFileStoreTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	f := openTestFileStore(t, dir)
+	if _, errs := f.Put(&models.Product{Name: "Milk", Price: models.NewMoney(100, "USD")}, &models.Product{Name: "Eggs", Price: models.NewMoney(200, "USD")}); len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+
+	walPath := filepath.Join(dir, _walFile)
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("failed to stat log [ERR: %s]", err)
+	}
+	goodSize := info.Size()
+
+	if _, errs := f.Put(&models.Product{Name: "Bread", Price: models.NewMoney(300, "USD")}, &models.Product{Name: "Butter", Price: models.NewMoney(400, "USD")}); len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	f.wal.Close()
+
+	// cut the second put off partway through, as a crash mid-write would
+	info, err = os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("failed to stat log [ERR: %s]", err)
+	}
+	if err := os.Truncate(walPath, goodSize+(info.Size()-goodSize)*3/4); err != nil {
+		t.Fatalf("failed to truncate log [ERR: %s]", err)
+	}
+
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+
+	products := f.List()
+	if len(products) != 2 {
+		t.Fatalf("wanted the 2 products of the first put after recovery but got %d", len(products))
+	}
+	for _, p := range products {
+		if p.Name != "Milk" && p.Name != "Eggs" {
+			t.Errorf("wanted no product of the torn put but got %q", p.Name)
+		}
+	}
+	if info, _ := os.Stat(walPath); info.Size() != goodSize {
+		t.Errorf("wanted log truncated to %d bytes but is %d", goodSize, info.Size())
+	}
+}
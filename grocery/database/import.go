@@ -0,0 +1,353 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+
+	// ImportAtomic stores every row or, if any is rejected, none of them.
+	ImportAtomic = "atomic"
+	// ImportBestEffort stores the valid rows and reports the rest.
+	ImportBestEffort = "best-effort"
+
+	// ImportBatchSize is how many valid rows a best-effort import hands to
+	// Put at a time.
+	ImportBatchSize = 500
+
+	ImportCreated  = "created"
+	ImportRejected = "rejected"
+	// ImportSkipped marks a valid row left out because an atomic import
+	// had rejected rows or couldn't read all of its input.
+	ImportSkipped = "skipped"
+)
+
+var (
+	ErrInvalidImport = errors.New("invalid import")
+
+	// ImportMaxRows caps the rows an import report details so the report
+	// stays small however large the input. The counts cover every row.
+	ImportMaxRows = 1000
+
+	// csvColumns are the CSV header names an import understands besides
+	// price, mapped to the product field each one sets. Only name is
+	// required.
+	csvColumns = map[string]func(*models.Product, string) error{
+		// code and version come with an export; the database assigns its own
+		"code":    func(p *models.Product, v string) error { return nil },
+		"version": func(p *models.Product, v string) error { return nil },
+		"name":    func(p *models.Product, v string) error { p.Name = v; return nil },
+		"currency": func(p *models.Product, v string) error {
+			p.Price.Currency = strings.ToUpper(strings.TrimSpace(v))
+			return nil
+		},
+		"sku":         func(p *models.Product, v string) error { p.SKU = v; return nil },
+		"barcode":     func(p *models.Product, v string) error { p.Barcode = v; return nil },
+		"brand":       func(p *models.Product, v string) error { p.Brand = v; return nil },
+		"category":    func(p *models.Product, v string) error { p.Category = v; return nil },
+		"description": func(p *models.Product, v string) error { p.Description = v; return nil },
+		"unit":        func(p *models.Product, v string) error { p.Unit = v; return nil },
+		"size": func(p *models.Product, v string) error {
+			if strings.TrimSpace(v) == "" {
+				return nil
+			}
+			size, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return &FieldError{Field: "size", Message: fmt.Sprintf("%q is not a number", v)}
+			}
+			p.Size = size
+			return nil
+		},
+	}
+)
+
+type (
+	// ImportReport describes the outcome of an import, row by row for the
+	// first ImportMaxRows rows. Truncated is set when there were more.
+	ImportReport struct {
+		Mode      string       `json:"mode"`
+		Created   int          `json:"created"`
+		Rejected  int          `json:"rejected"`
+		Skipped   int          `json:"skipped"`
+		Rows      []*ImportRow `json:"rows"`
+		Truncated bool         `json:"truncated"`
+	}
+
+	// ImportRow is the outcome for one row. Row is the line the row starts
+	// on in the input.
+	ImportRow struct {
+		Row    int    `json:"row"`
+		Status string `json:"status"`
+		Code   string `json:"code,omitempty"`
+		Field  string `json:"field,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	// importRowReader yields products one row at a time. A non-nil product
+	// with a non-nil error is a rejected row; a nil product with an error
+	// ends the import.
+	importRowReader func() (line int, item *models.Product, err error)
+
+	pendingRow struct {
+		row  *ImportRow
+		item *models.Product
+	}
+)
+
+// Import reads products from r in the given format (ImportCSV or
+// ImportNDJSON), validates each row and stores the valid ones in store
+// according to mode. Rows are streamed, so input of any size can be
+// imported; an atomic import holds the valid rows in memory until the end.
+//
+// Rejected rows are reported rather than returned as errors. The error is
+// non-nil only when the input as a whole can't be read or the store fails,
+// in which case the report covers the rows handled so far. A best-effort
+// import keeps the rows stored before input stopped being readable.
+func Import(store Store, r io.Reader, format, mode string) (*ImportReport, error) {
+	if mode == "" {
+		mode = ImportBestEffort
+	}
+	if mode != ImportAtomic && mode != ImportBestEffort {
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidImport, mode)
+	}
+
+	var (
+		next importRowReader
+		err  error
+	)
+	switch format {
+	case ImportCSV:
+		next, err = csvRows(r)
+	case ImportNDJSON:
+		next = ndjsonRows(r)
+	default:
+		err = fmt.Errorf("%w: unknown format %q", ErrInvalidImport, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Mode: mode, Rows: []*ImportRow{}}
+	var pending []*pendingRow
+
+	for {
+		line, item, err := next()
+		if err == io.EOF {
+			break
+		}
+		if item == nil && err != nil {
+			return report, report.abort(store, pending, err)
+		}
+
+		row := &ImportRow{Row: line}
+		if len(report.Rows) < ImportMaxRows {
+			report.Rows = append(report.Rows, row)
+		} else {
+			report.Truncated = true
+		}
+
+		if err == nil {
+			err = validate(item)
+		}
+		if err != nil {
+			report.reject(row, err)
+			continue
+		}
+
+		pending = append(pending, &pendingRow{row: row, item: item})
+		if mode == ImportBestEffort && len(pending) == ImportBatchSize {
+			if err := report.store(store, pending); err != nil {
+				return report, err
+			}
+			pending = pending[:0]
+		}
+	}
+
+	if mode == ImportAtomic && report.Rejected > 0 {
+		report.skip(pending)
+		return report, nil
+	}
+
+	return report, report.store(store, pending)
+}
+
+// abort ends an import cut short by err. A best-effort import stores the
+// valid rows read so far; an atomic one skips them.
+func (report *ImportReport) abort(store Store, pending []*pendingRow, err error) error {
+	if report.Mode == ImportAtomic {
+		report.skip(pending)
+		return err
+	}
+
+	if storeErr := report.store(store, pending); storeErr != nil {
+		return storeErr
+	}
+
+	return err
+}
+
+func (report *ImportReport) skip(pending []*pendingRow) {
+	for _, p := range pending {
+		p.row.Status = ImportSkipped
+	}
+	report.Skipped += len(pending)
+}
+
+func (report *ImportReport) reject(row *ImportRow, err error) {
+	row.Status = ImportRejected
+	row.Error = err.Error()
+
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		row.Field = fieldErr.Field
+		row.Error = fieldErr.Message
+	}
+
+	report.Rejected++
+}
+
+// store puts the pending rows in one write and marks them created.
+func (report *ImportReport) store(store Store, pending []*pendingRow) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	items := make([]*models.Product, len(pending))
+	for i, p := range pending {
+		items[i] = p.item
+	}
+
+	created, errs := store.Put(items...)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	for i, p := range pending {
+		p.row.Status = ImportCreated
+		p.row.Code = created[i].Code
+	}
+	report.Created += len(created)
+
+	return nil
+}
+
+// csvRows reads a header row naming the columns, then one product per
+// record. Prices are decimal amounts in the row's currency column, or
+// config.CURRENCY when there isn't one.
+func csvRows(r io.Reader) (importRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing CSV header", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading CSV header: %s", ErrInvalidImport, err)
+	}
+
+	columns := make([]string, len(header))
+	var hasName bool
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := csvColumns[name]; !ok && name != "price" {
+			return nil, fmt.Errorf("%w: unknown CSV column %q", ErrInvalidImport, name)
+		}
+		hasName = hasName || name == "name"
+		columns[i] = name
+	}
+	if !hasName {
+		return nil, fmt.Errorf("%w: CSV header has no name column", ErrInvalidImport)
+	}
+
+	return func() (int, *models.Product, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, &models.Product{}, fmt.Errorf("%w: %s", ErrInvalidProduct, parseErr.Err)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		item := &models.Product{}
+
+		var price string
+		for i, value := range record {
+			value = fromCSVCell(value)
+			if columns[i] == "price" {
+				price = strings.TrimSpace(value)
+				continue
+			}
+			if err := csvColumns[columns[i]](item, value); err != nil {
+				return line, item, err
+			}
+		}
+
+		if price != "" {
+			currency := item.Price.Currency
+			if currency == "" {
+				currency = config.CURRENCY
+			}
+			if !models.ValidCurrency(currency) {
+				return line, item, &FieldError{Field: "price", Message: fmt.Sprintf("currency %q is not supported", currency)}
+			}
+			money, err := models.ParseMoney(price, currency)
+			if err != nil {
+				return line, item, &FieldError{Field: "price", Message: fmt.Sprintf("%q is not a valid amount", price)}
+			}
+			item.Price = money
+		}
+
+		return line, item, nil
+	}, nil
+}
+
+// ndjsonRows reads one JSON product per line, skipping blank lines.
+func ndjsonRows(r io.Reader) importRowReader {
+	var (
+		reader = bufio.NewReader(r)
+		line   int
+	)
+
+	return func() (int, *models.Product, error) {
+		for {
+			b, err := reader.ReadBytes('\n')
+			if len(b) == 0 && err != nil {
+				return 0, nil, err
+			}
+			line++
+
+			b = bytes.TrimSpace(b)
+			if len(b) == 0 {
+				continue
+			}
+
+			item := &models.Product{}
+			if err := json.Unmarshal(b, item); err != nil {
+				return line, item, fmt.Errorf("%w: %s", ErrInvalidProduct, err)
+			}
+			item.Code, item.Version = "", 0
+
+			return line, item, nil
+		}
+	}
+}
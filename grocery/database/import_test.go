@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	input := "Name,Price,Currency,Category,Size,Unit\n" +
+		"Basil,2.49,,Produce,1,each\n" +
+		",1.00,,produce,,\n" +
+		"Brie,7.5,EUR,dairy,200,g\n" +
+		"Baguette,cheap,,bakery,,\n" +
+		"\"Oat Milk\",3.99,USD,dairy,64,oz\n" +
+		"Too,Many,Fields,Here,1,each,extra\n"
+
+	db := NewDatabase()
+
+	report, err := Import(db, strings.NewReader(input), ImportCSV, ImportBestEffort)
+	if err != nil {
+		t.Fatalf("failed to import [ERR: %s]", err)
+	}
+
+	var rowTable = []struct {
+		row    int
+		status string
+		field  string
+	}{
+		{2, ImportCreated, ""},
+		{3, ImportRejected, "name"},
+		{4, ImportRejected, "unit"},
+		{5, ImportRejected, "price"},
+		{6, ImportCreated, ""},
+		{7, ImportRejected, ""},
+	}
+
+	if len(report.Rows) != len(rowTable) {
+		t.Fatalf("wanted %d rows in the report but got %d", len(rowTable), len(report.Rows))
+	}
+	for i, tc := range rowTable {
+		row := report.Rows[i]
+		if row.Row != tc.row || row.Status != tc.status || row.Field != tc.field {
+			t.Errorf("row %d: wanted %d %s %q but got %+v", i, tc.row, tc.status, tc.field, row)
+		}
+		if row.Status == ImportCreated && db.Get(row.Code) == nil {
+			t.Errorf("row %d: created product %s is not stored", i, row.Code)
+		}
+		if row.Status == ImportRejected && row.Error == "" {
+			t.Errorf("row %d: rejected without a reason", i)
+		}
+	}
+
+	if report.Created != 2 || report.Rejected != 4 || len(db.List()) != 2 {
+		t.Errorf("wanted 2 created and 4 rejected but got %d and %d with %d stored", report.Created, report.Rejected, len(db.List()))
+	}
+	if basil := db.Get(report.Rows[0].Code); basil.Price.Amount != 249 || basil.Category != "produce" {
+		t.Errorf("wanted basil at 2.49 in produce but got %s", basil)
+	}
+}
+
+func TestImportNDJSONAtomic(t *testing.T) {
+	input := `{"name": "Basil", "price": "2.49"}` + "\n" +
+		"\n" +
+		`{"name": "Brie", "price": {"amount": 750, "currency": "EUR"}}` + "\n" +
+		`{"name": "Baguette", "price": -1}` + "\n" +
+		`{"name": "Oat Milk"`
+
+	db := NewDatabase()
+
+	report, err := Import(db, strings.NewReader(input), ImportNDJSON, ImportAtomic)
+	if err != nil {
+		t.Fatalf("failed to import [ERR: %s]", err)
+	}
+
+	var rowTable = []struct {
+		row    int
+		status string
+	}{
+		{1, ImportSkipped},
+		{3, ImportSkipped},
+		{4, ImportRejected},
+		{5, ImportRejected},
+	}
+
+	if len(report.Rows) != len(rowTable) {
+		t.Fatalf("wanted %d rows in the report but got %d", len(rowTable), len(report.Rows))
+	}
+	for i, tc := range rowTable {
+		if row := report.Rows[i]; row.Row != tc.row || row.Status != tc.status {
+			t.Errorf("row %d: wanted %d %s but got %+v", i, tc.row, tc.status, row)
+		}
+	}
+	if report.Created != 0 || len(db.List()) != 0 {
+		t.Errorf("wanted nothing stored by a failed atomic import but got %d", len(db.List()))
+	}
+	if report.Skipped != 2 || report.Rejected != 2 {
+		t.Errorf("wanted 2 skipped and 2 rejected but got %d and %d", report.Skipped, report.Rejected)
+	}
+
+	// the same rows without the bad ones go in together
+	valid := strings.Join(strings.Split(input, "\n")[:3], "\n")
+	report, err = Import(db, strings.NewReader(valid), ImportNDJSON, ImportAtomic)
+	if err != nil {
+		t.Fatalf("failed to import [ERR: %s]", err)
+	}
+	if report.Created != 2 || len(db.List()) != 2 {
+		t.Errorf("wanted 2 stored but got %d", len(db.List()))
+	}
+}
+
+func TestImportTruncatedReport(t *testing.T) {
+	defer func(max int) { ImportMaxRows = max }(ImportMaxRows)
+	ImportMaxRows = 2
+
+	input := "name,price\nBasil,2.49\nBrie,7.50\n,1.00\nBaguette,3.00\n"
+
+	db := NewDatabase()
+
+	report, err := Import(db, strings.NewReader(input), ImportCSV, ImportBestEffort)
+	if err != nil {
+		t.Fatalf("failed to import [ERR: %s]", err)
+	}
+
+	if len(report.Rows) != 2 || !report.Truncated {
+		t.Errorf("wanted 2 rows in a truncated report but got %d (truncated: %t)", len(report.Rows), report.Truncated)
+	}
+	if report.Created != 3 || report.Rejected != 1 || len(db.List()) != 3 {
+		t.Errorf("wanted 3 created and 1 rejected but got %d and %d with %d stored", report.Created, report.Rejected, len(db.List()))
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	var invalidTable = []struct {
+		input  string
+		format string
+		mode   string
+	}{
+		{"name\nBasil\n", "xml", ImportAtomic},
+		{"name\nBasil\n", ImportCSV, "sometimes"},
+		{"", ImportCSV, ImportAtomic},
+		{"name,colour\nBasil,green\n", ImportCSV, ImportAtomic},
+		{"price\n1.00\n", ImportCSV, ImportAtomic},
+	}
+
+	for _, tc := range invalidTable {
+		_, err := Import(NewDatabase(), strings.NewReader(tc.input), tc.format, tc.mode)
+		if !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%q as %s/%s: wanted ErrInvalidImport but got %v", tc.input, tc.format, tc.mode, err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"grocery/models"
+	"grocery/shared"
+)
+
+const (
+	// how strongly a query term matches an indexed term, by kind of match
+	_exactMatch     = 1.0
+	_prefixMatch    = 0.7
+	_fuzzyMatch     = 0.6
+	_substringMatch = 0.3
+)
+
+var (
+	// fieldWeights ranks a hit in the name above one in the description.
+	fieldWeights = []struct {
+		weight float64
+		value  func(*models.Product) string
+	}{
+		{3, func(p *models.Product) string { return p.Name }},
+		{2, func(p *models.Product) string { return p.Brand }},
+		{1.5, func(p *models.Product) string { return p.Category }},
+		{1, func(p *models.Product) string { return p.SKU }},
+		{1, func(p *models.Product) string { return p.Barcode }},
+		{1, func(p *models.Product) string { return p.Description }},
+	}
+)
+
+type (
+	// Match is a search hit and its relevance score.
+	Match struct {
+		*models.Product
+
+		Score float64 `json:"score"`
+	}
+
+	// Index is an inverted index over product text. Terms are tokenized,
+	// lowercased and stemmed, and queries match indexed terms exactly, by
+	// prefix, within a small edit distance, or as a substring, each scoring
+	// progressively lower.
+	Index struct {
+		sync.RWMutex
+
+		docs     map[string]*models.Product
+		terms    map[string][]string
+		postings map[string]map[string]float64
+		// lengths groups the indexed terms by their length in runes, so
+		// only terms close enough in length are measured for typos
+		lengths map[int]map[string]bool
+
+		// suggestions maps name prefixes to the names they complete
+		suggestions map[string]*suggestBucket
+	}
+)
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*models.Product),
+		terms:    make(map[string][]string),
+		postings: make(map[string]map[string]float64),
+		lengths:  make(map[int]map[string]bool),
+
+		suggestions: make(map[string]*suggestBucket),
+	}
+}
+
+// Add indexes item, replacing any earlier version with the same code.
+func (x *Index) Add(item *models.Product) {
+	code := strings.ToUpper(item.Code)
+
+	weights := make(map[string]float64)
+	for _, field := range fieldWeights {
+		for _, term := range tokenize(field.value(item)) {
+			weights[term] += field.weight
+		}
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	x.remove(code)
+
+	x.docs[code] = item
+	x.addSuggestions(code, item.Name)
+	for term, weight := range weights {
+		if x.postings[term] == nil {
+			x.postings[term] = make(map[string]float64)
+
+			n := utf8.RuneCountInString(term)
+			if x.lengths[n] == nil {
+				x.lengths[n] = make(map[string]bool)
+			}
+			x.lengths[n][term] = true
+		}
+		x.postings[term][code] = weight
+		x.terms[code] = append(x.terms[code], term)
+	}
+}
+
+// Remove drops the product with the given code from the index.
+func (x *Index) Remove(code string) {
+	x.Lock()
+	x.remove(strings.ToUpper(code))
+	x.Unlock()
+}
+
+func (x *Index) remove(code string) {
+	if item, ok := x.docs[code]; ok {
+		x.removeSuggestions(code, item.Name)
+	}
+
+	for _, term := range x.terms[code] {
+		delete(x.postings[term], code)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+
+			n := utf8.RuneCountInString(term)
+			delete(x.lengths[n], term)
+			if len(x.lengths[n]) == 0 {
+				delete(x.lengths, n)
+			}
+		}
+	}
+
+	delete(x.terms, code)
+	delete(x.docs, code)
+}
+
+// Search returns the products matching query, most relevant first.
+func (x *Index) Search(query string) []*Match {
+	queryTerms := tokenize(query)
+	if len(queryTerms) == 0 {
+		return nil
+	}
+
+	x.RLock()
+	defer x.RUnlock()
+
+	var (
+		total   = float64(len(x.docs))
+		scores  = make(map[string]float64)
+		matched = make(map[string]int)
+	)
+
+	for _, qt := range queryTerms {
+		// best contribution of this query term to each product, so a term
+		// that loosely matches several indexed terms isn't counted twice
+		best := make(map[string]float64)
+
+		for term, strength := range x.matchTerms(qt) {
+			docs := x.postings[term]
+			idf := math.Log(1 + total/float64(len(docs)))
+			for code, weight := range docs {
+				if score := strength * idf * weight; score > best[code] {
+					best[code] = score
+				}
+			}
+		}
+
+		for code, score := range best {
+			scores[code] += score
+			matched[code]++
+		}
+	}
+
+	matches := make([]*Match, 0, len(scores))
+	for code, score := range scores {
+		// favour products that match every term of the query
+		coverage := float64(matched[code]) / float64(len(queryTerms))
+		matches = append(matches, &Match{
+			Product: x.docs[code],
+			Score:   shared.RoundFloat(score*coverage, 4),
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return productLess(matches[i].Product, matches[j].Product, SortByName)
+	})
+
+	return matches
+}
+
+// matchTerms returns the indexed terms query term q matches and how strongly.
+// An exact match is looked up directly; prefixes and substrings are a cheap
+// comparison against each term, and the edit distance is only worked out
+// for terms whose length is within the typos q is allowed.
+func (x *Index) matchTerms(q string) map[string]float64 {
+	found := make(map[string]float64)
+	if _, ok := x.postings[q]; ok {
+		found[q] = _exactMatch
+	}
+
+	// codes and barcodes must match exactly or by prefix
+	digits := hasDigit(q)
+
+	for term := range x.postings {
+		switch {
+		case term == q:
+		case strings.HasPrefix(term, q):
+			found[term] = _prefixMatch
+		case !digits && strings.Contains(term, q):
+			found[term] = _substringMatch
+		}
+	}
+
+	max := maxEdits(q)
+	if digits || max == 0 {
+		return found
+	}
+
+	n := utf8.RuneCountInString(q)
+	for length := n - max; length <= n+max; length++ {
+		for term := range x.lengths[length] {
+			if term == q || strings.HasPrefix(term, q) {
+				continue
+			}
+			// a typo outranks a substring
+			if d := editDistance(q, term, max); d <= max {
+				found[term] = _fuzzyMatch / float64(d)
+			}
+		}
+	}
+
+	return found
+}
+
+// maxEdits is how many typos a query term of that length may contain.
+func maxEdits(term string) int {
+	switch n := len(term); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// tokenize splits s into lowercase, stemmed terms.
+func tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := fields[:0]
+	for _, field := range fields {
+		terms = append(terms, stem(field))
+	}
+
+	return terms
+}
+
+// stem reduces an English word to its singular form, which covers the
+// variation that matters for product names ("apples", "berries",
+// "peaches", "tomatoes").
+func stem(w string) string {
+	if len(w) <= 3 || hasDigit(w) {
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "oes"),
+		strings.HasSuffix(w, "ches"),
+		strings.HasSuffix(w, "shes"),
+		strings.HasSuffix(w, "xes"),
+		strings.HasSuffix(w, "zes"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "s") &&
+		!strings.HasSuffix(w, "ss") &&
+		!strings.HasSuffix(w, "us") &&
+		!strings.HasSuffix(w, "is"):
+		return w[:len(w)-1]
+	}
+
+	return w
+}
+
+// editDistance is the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and adjacent transpositions.
+// It gives up and returns max+1 once the distance must exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+
+			rowMin = min(rowMin, cur[j])
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[len(rb)]
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
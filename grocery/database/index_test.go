@@ -0,0 +1,115 @@
+package database
+
+import (
+	"testing"
+
+	"grocery/models"
+)
+
+func indexTestProducts() []*models.Product {
+	return []*models.Product{
+		{Code: "AAAA-0000-0000-0001", Name: "Gala Apple", Category: "produce"},
+		{Code: "AAAA-0000-0000-0002", Name: "Apple Juice", Brand: "Orchard Co", Category: "beverages"},
+		{Code: "AAAA-0000-0000-0003", Name: "Peaches", Category: "produce"},
+		{Code: "AAAA-0000-0000-0004", Name: "Strawberry Jam", Description: "made with real strawberries"},
+		{Code: "AAAA-0000-0000-0005", Name: "Cola", Barcode: "4006381333931"},
+		{Code: "AAAA-0000-0000-0006", Name: "Pineapple Chunks", Category: "canned"},
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	x := NewIndex()
+	for _, item := range indexTestProducts() {
+		x.Add(item)
+	}
+
+	var searchTable = []struct {
+		query string
+		want  []string
+	}{
+		{"apples", []string{"Apple Juice", "Gala Apple", "Pineapple Chunks"}}, // stemmed, ties by name, substring last
+		{"peach", []string{"Peaches"}},
+		{"strawberry", []string{"Strawberry Jam"}},
+		{"stawberry", []string{"Strawberry Jam"}},  // typo
+		{"strwaberry", []string{"Strawberry Jam"}}, // transposition
+		{"apple juice", []string{"Apple Juice", "Gala Apple", "Pineapple Chunks"}},
+		{"orchard", []string{"Apple Juice"}},
+		{"4006381333931", []string{"Cola"}},
+		{"4006381333932", nil},
+		{"!!", nil},
+	}
+
+	for _, tc := range searchTable {
+		matches := x.Search(tc.query)
+
+		var got []string
+		for _, match := range matches {
+			got = append(got, match.Name)
+		}
+
+		if len(got) != len(tc.want) {
+			t.Errorf("%q: wanted %v but got %v", tc.query, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q: wanted %v but got %v", tc.query, tc.want, got)
+				break
+			}
+		}
+		for i := 1; i < len(matches); i++ {
+			if matches[i].Score > matches[i-1].Score {
+				t.Errorf("%q: results not ordered by score", tc.query)
+			}
+		}
+	}
+}
+
+func TestIndexMaintained(t *testing.T) {
+	x := NewIndex()
+	items := indexTestProducts()
+	for _, item := range items {
+		x.Add(item)
+	}
+
+	renamed := *items[2]
+	renamed.Name = "Nectarines"
+	x.Add(&renamed)
+	if got := x.Search("peach"); len(got) != 0 {
+		t.Errorf("wanted old name gone from index but got %v", got)
+	}
+	if got := x.Search("nectarine"); len(got) != 1 {
+		t.Errorf("wanted new name indexed but got %v", got)
+	}
+
+	x.Remove(items[0].Code)
+	if got := x.Search("gala"); len(got) != 0 {
+		t.Errorf("wanted removed product gone from index but got %v", got)
+	}
+
+	for _, item := range items {
+		x.Remove(item.Code)
+	}
+	if len(x.postings) != 0 || len(x.lengths) != 0 {
+		t.Errorf("wanted an empty index but %d terms and %d term lengths are left", len(x.postings), len(x.lengths))
+	}
+}
+
+func TestStem(t *testing.T) {
+	var stemTable = map[string]string{
+		"apples":   "apple",
+		"berries":  "berry",
+		"peaches":  "peach",
+		"tomatoes": "tomato",
+		"glass":    "glass",
+		"hummus":   "hummus",
+		"eggs":     "egg",
+		"peas":     "pea",
+	}
+
+	for word, want := range stemTable {
+		if got := stem(word); got != want {
+			t.Errorf("stem(%q) = %q; wanted %q", word, got, want)
+		}
+	}
+}
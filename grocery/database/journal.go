@@ -0,0 +1,65 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type (
+	// Journal is implemented by the drivers that keep more than the catalog
+	// across restarts. The stock ledgers, the store directory and the price
+	// schedule save their state to it as records and load it back when the
+	// database connects; without one they are kept in memory only.
+	Journal interface {
+		// Save writes records atomically. A record without a Value deletes
+		// the one kept under its kind and key.
+		Save(records ...*Record) error
+		// Records returns every record of a kind, ordered by key.
+		Records(kind string) ([]*Record, error)
+	}
+
+	// Record is a JSON value kept under a kind, such as "stock", and a key
+	// unique within it.
+	Record struct {
+		Kind  string          `json:"kind"`
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+)
+
+// journalOf returns the store's journal, nil for drivers that only keep the
+// catalog.
+func journalOf(store Store) Journal {
+	j, _ := store.(Journal)
+	return j
+}
+
+// newRecord encodes v as the record kept under kind and key.
+func newRecord(kind, key string, v interface{}) (*Record, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{Kind: kind, Key: key, Value: b}, nil
+}
+
+// deleted is the record deleting the one kept under kind and key.
+func deleted(kind, key string) *Record {
+	return &Record{Kind: kind, Key: key}
+}
+
+// save writes records to j, doing nothing when there is no journal.
+func save(j Journal, records ...*Record) error {
+	if j == nil || len(records) == 0 {
+		return nil
+	}
+
+	for _, rec := range records {
+		if rec.Kind == "" || rec.Key == "" {
+			return errors.New("journal records need a kind and key")
+		}
+	}
+
+	return j.Save(records...)
+}
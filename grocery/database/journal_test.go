@@ -0,0 +1,96 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testJournals opens a journal of each driver that has one, along with a
+// func reopening it as a restart would.
+func testJournals(t *testing.T) map[string]func() Journal {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(t.TempDir(), "grocery.db")
+
+		file *FileStore
+		db   *SQLStore
+	)
+	t.Cleanup(func() {
+		if file != nil {
+			file.Close()
+		}
+		if db != nil {
+			db.Close()
+		}
+	})
+
+	return map[string]func() Journal{
+		"file": func() Journal {
+			if file != nil {
+				// drop the store without a snapshot so the log is replayed
+				file.wal.Close()
+			}
+			file = openTestFileStore(t, dir)
+			return file
+		},
+		"sqlite": func() Journal {
+			if db != nil {
+				db.Close()
+			}
+			db = openTestSQLite(t, path)
+			return db
+		},
+	}
+}
+
+func TestJournal(t *testing.T) {
+	for driver, open := range testJournals(t) {
+		j := open()
+
+		a, _ := newRecord("stock", "A", 1)
+		b, _ := newRecord("stock", "B", 2)
+		other, _ := newRecord("store", "A", "downtown")
+		if err := save(j, b, a, other); err != nil {
+			t.Fatalf("%s: failed to save records [ERR: %s]", driver, err)
+		}
+		a, _ = newRecord("stock", "A", 3)
+		if err := save(j, a, deleted("stock", "B")); err != nil {
+			t.Fatalf("%s: failed to save records [ERR: %s]", driver, err)
+		}
+		if err := save(j, &Record{Kind: "stock"}); err == nil {
+			t.Errorf("%s: wanted a record without a key refused", driver)
+		}
+
+		j = open()
+		records, err := j.Records("stock")
+		if err != nil {
+			t.Fatalf("%s: failed to load records [ERR: %s]", driver, err)
+		}
+		if len(records) != 1 || records[0].Key != "A" || string(records[0].Value) != "3" {
+			t.Errorf("%s: wanted only A at 3 after reopening but got %+v", driver, records)
+		}
+		if records, _ := j.Records("store"); len(records) != 1 {
+			t.Errorf("%s: wanted the other kind kept apart but got %+v", driver, records)
+		}
+	}
+}
+
+func TestFileStoreSnapshotsRecords(t *testing.T) {
+	dir := t.TempDir()
+	f := openTestFileStore(t, dir)
+
+	rec, _ := newRecord("stock", "A", 1)
+	if err := f.Save(rec); err != nil {
+		t.Fatalf("failed to save record [ERR: %s]", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to close file store [ERR: %s]", err)
+	}
+
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+
+	if records, _ := f.Records("stock"); len(records) != 1 || f.pending != 0 {
+		t.Errorf("wanted the record loaded from the snapshot but got %+v with %d pending", records, f.pending)
+	}
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"grocery/models"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+
+	SortByName  = "name"
+	SortByPrice = "price"
+	SortByCode  = "code"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type (
+	// ListOptions controls how a listing is ordered and which page of it is
+	// returned.
+	ListOptions struct {
+		// Limit is the page size; zero means DefaultListLimit.
+		Limit int
+		// After is the cursor returned with the previous page.
+		After string
+		// Sort is one of SortByName, SortByPrice or SortByCode; empty sorts
+		// by name. Prices sort by currency first, then amount.
+		Sort string
+		Desc bool
+	}
+
+	// cursor identifies the last product on a page. It carries the sort it
+	// was issued for so it can't be replayed against a different ordering.
+	cursor struct {
+		Sort  string       `json:"s"`
+		Desc  bool         `json:"d,omitempty"`
+		Name  string       `json:"n,omitempty"`
+		Price models.Money `json:"p"`
+		Code  string       `json:"c"`
+	}
+)
+
+// Paginate sorts items per opts and returns the page following opts.After
+// along with the cursor for the next page, which is empty on the last page.
+// Paging is keyed on the sort value and product code rather than an offset,
+// so products added or removed between requests don't shift later pages.
+func Paginate(items []*models.Product, opts ListOptions) (page []*models.Product, next string, err error) {
+	if opts.Sort == "" {
+		opts.Sort = SortByName
+	}
+	if opts.Sort != SortByName && opts.Sort != SortByPrice && opts.Sort != SortByCode {
+		return nil, "", fmt.Errorf("invalid sort %q", opts.Sort)
+	}
+	if opts.Limit == 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit < 0 || opts.Limit > MaxListLimit {
+		return nil, "", fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	before := func(a, b *models.Product) bool {
+		if opts.Desc {
+			return productLess(b, a, opts.Sort)
+		}
+		return productLess(a, b, opts.Sort)
+	}
+
+	sorted := make([]*models.Product, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return before(sorted[i], sorted[j])
+	})
+
+	start := 0
+	if opts.After != "" {
+		c, err := decodeCursor(opts.After)
+		if err != nil {
+			return nil, "", err
+		}
+		if c.Sort != opts.Sort || c.Desc != opts.Desc {
+			return nil, "", fmt.Errorf("%w: issued for a different sort", ErrInvalidCursor)
+		}
+
+		last := &models.Product{Code: c.Code, Name: c.Name, Price: c.Price}
+		start = sort.Search(len(sorted), func(i int) bool {
+			return before(last, sorted[i])
+		})
+	}
+
+	end := start + opts.Limit
+	if end >= len(sorted) {
+		return sorted[start:], "", nil
+	}
+
+	page = sorted[start:end]
+	last := page[len(page)-1]
+	next = encodeCursor(&cursor{
+		Sort:  opts.Sort,
+		Desc:  opts.Desc,
+		Name:  last.Name,
+		Price: last.Price,
+		Code:  last.Code,
+	})
+
+	return page, next, nil
+}
+
+// productLess orders products by the given field, breaking ties on code so
+// the order is total.
+func productLess(a, b *models.Product, field string) bool {
+	switch field {
+	case SortByName:
+		if an, bn := strings.ToLower(a.Name), strings.ToLower(b.Name); an != bn {
+			return an < bn
+		}
+	case SortByPrice:
+		if a.Price != b.Price {
+			return a.Price.Less(b.Price)
+		}
+	}
+
+	return strings.ToUpper(a.Code) < strings.ToUpper(b.Code)
+}
+
+func encodeCursor(c *cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Code == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
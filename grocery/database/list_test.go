@@ -0,0 +1,105 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"grocery/models"
+)
+
+func listTestItems() []*models.Product {
+	return []*models.Product{
+		{Code: "AAAA-0000-0000-0004", Name: "Peach", Price: models.NewMoney(299, "USD")},
+		{Code: "AAAA-0000-0000-0001", Name: "Lettuce", Price: models.NewMoney(346, "USD")},
+		{Code: "AAAA-0000-0000-0003", Name: "gala apple", Price: models.NewMoney(79, "USD")},
+		{Code: "AAAA-0000-0000-0002", Name: "Green Pepper", Price: models.NewMoney(79, "USD")},
+		{Code: "AAAA-0000-0000-0005", Name: "Kiwi", Price: models.NewMoney(50, "USD")},
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	var pageTable = []struct {
+		opts ListOptions
+		want string
+	}{
+		{ListOptions{Limit: 2}, "[gala apple Green Pepper] [Kiwi Lettuce] [Peach]"},
+		{ListOptions{Limit: 2, Sort: SortByPrice}, "[Kiwi Green Pepper] [gala apple Peach] [Lettuce]"},
+		{ListOptions{Limit: 3, Sort: SortByPrice, Desc: true}, "[Lettuce Peach gala apple] [Green Pepper Kiwi]"},
+		{ListOptions{Limit: 4, Sort: SortByCode}, "[Lettuce Green Pepper gala apple Peach] [Kiwi]"},
+		{ListOptions{}, "[gala apple Green Pepper Kiwi Lettuce Peach]"},
+	}
+
+	for _, tc := range pageTable {
+		var (
+			got   string
+			items = listTestItems()
+			opts  = tc.opts
+		)
+
+		for {
+			page, next, err := Paginate(items, opts)
+			if err != nil {
+				t.Fatalf("%+v: failed to paginate [ERR: %s]", tc.opts, err)
+			}
+
+			names := make([]string, len(page))
+			for i, item := range page {
+				names[i] = item.Name
+			}
+			if got != "" {
+				got += " "
+			}
+			got += fmt.Sprint(names)
+
+			if next == "" {
+				break
+			}
+			opts.After = next
+		}
+
+		if got != tc.want {
+			t.Errorf("%+v: wanted pages %s but got %s", tc.opts, tc.want, got)
+		}
+	}
+}
+
+func TestPaginateCursorSurvivesWrites(t *testing.T) {
+	items := listTestItems()
+
+	page, next, err := Paginate(items, ListOptions{Limit: 2})
+	if err != nil || len(page) != 2 {
+		t.Fatalf("failed to paginate [ERR: %v]", err)
+	}
+
+	// deleting the last product on the page and adding one before it must
+	// not skip or repeat anything on the next page
+	items = append(items[:3], items[4:]...)
+	items = append(items, &models.Product{Code: "AAAA-0000-0000-0006", Name: "Apricot"})
+
+	page, _, err = Paginate(items, ListOptions{Limit: 2, After: next})
+	if err != nil {
+		t.Fatalf("failed to paginate [ERR: %s]", err)
+	}
+	if len(page) != 2 || page[0].Name != "Kiwi" || page[1].Name != "Lettuce" {
+		t.Errorf("wanted second page [Kiwi Lettuce] but got %v", page)
+	}
+}
+
+func TestPaginateInvalid(t *testing.T) {
+	_, next, _ := Paginate(listTestItems(), ListOptions{Limit: 1})
+
+	var invalidTable = []ListOptions{
+		{Sort: "colour"},
+		{Limit: -1},
+		{Limit: MaxListLimit + 1},
+		{After: "not a cursor"},
+		{After: next, Sort: SortByPrice},
+		{After: next, Desc: true},
+	}
+
+	for _, opts := range invalidTable {
+		if _, _, err := Paginate(listTestItems(), opts); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+type (
+	// migration is one versioned step of the SQL schema. Versions must be
+	// unique and listed in ascending order; once released a migration is
+	// never edited, only followed by a new one.
+	migration struct {
+		Version int
+		Name    string
+		SQL     string
+	}
+)
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create products",
+		SQL: `CREATE TABLE products (
+			code  TEXT NOT NULL PRIMARY KEY COLLATE NOCASE,
+			name  TEXT NOT NULL,
+			price REAL NOT NULL
+		)`,
+	},
+	{
+		Version: 2,
+		Name:    "index product names",
+		SQL:     `CREATE INDEX products_name ON products (name COLLATE NOCASE)`,
+	},
+	{
+		Version: 3,
+		Name:    "add product versions",
+		SQL:     `ALTER TABLE products ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	},
+	{
+		Version: 4,
+		Name:    "add product details",
+		SQL: `ALTER TABLE products ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN barcode TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN brand TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN category TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN description TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN unit TEXT NOT NULL DEFAULT '';
+			ALTER TABLE products ADD COLUMN size REAL NOT NULL DEFAULT 0;
+			CREATE INDEX products_barcode ON products (barcode)`,
+	},
+	{
+		Version: 5,
+		Name:    "store prices as minor units",
+		SQL: `ALTER TABLE products ADD COLUMN price_amount INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+			UPDATE products SET price_amount = CAST(ROUND(price * 100) AS INTEGER);
+			ALTER TABLE products DROP COLUMN price`,
+	},
+	{
+		Version: 6,
+		Name:    "add product trash",
+		SQL: `ALTER TABLE products ADD COLUMN deleted_at INTEGER;
+			CREATE INDEX products_deleted_at ON products (deleted_at)`,
+	},
+	{
+		Version: 7,
+		Name:    "create journal records",
+		SQL: `CREATE TABLE records (
+			kind  TEXT NOT NULL,
+			key   TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (kind, key)
+		)`,
+	},
+}
+
+// migrate applies every migration newer than the schema's current version,
+// each in its own transaction, and returns the resulting version.
+func migrate(db *sql.DB, steps []migration) (int, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER NOT NULL PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, step := range steps {
+		if step.Version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return current, err
+		}
+		if _, err := tx.Exec(step.SQL); err != nil {
+			tx.Rollback()
+			return current, fmt.Errorf("migration %d %q: %w", step.Version, step.Name, err)
+		}
+		_, err = tx.Exec(
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			step.Version, step.Name, time.Now().UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			tx.Rollback()
+			return current, err
+		}
+		if err := tx.Commit(); err != nil {
+			return current, err
+		}
+
+		log.Printf("applied migration %d %q", step.Version, step.Name)
+		current = step.Version
+	}
+
+	return current, nil
+}
+
+func schemaVersion(db *sql.DB) (version int, err error) {
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return
+}
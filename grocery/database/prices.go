@@ -0,0 +1,504 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	PricePending   = "pending"
+	PriceActive    = "active"
+	PriceDone      = "done"
+	PriceCancelled = "cancelled"
+
+	// _priceKind is the journal kind of the scheduled prices, keyed by ID.
+	_priceKind = "scheduled_price"
+)
+
+var (
+	Prices *PriceSchedule
+
+	ErrPriceNotFound = errors.New("scheduled price not found")
+	ErrPriceOverlap  = errors.New("scheduled price overlaps another")
+	ErrPriceDone     = errors.New("scheduled price has already ended")
+)
+
+type (
+	// ScheduledPrice changes a product's price at Start and, when it has an
+	// End, puts the price it replaced back at End.
+	ScheduledPrice struct {
+		ID    int64        `json:"id"`
+		Code  string       `json:"code"`
+		Price models.Money `json:"price"`
+		Start time.Time    `json:"start"`
+		End   *time.Time   `json:"end,omitempty"`
+		// Status moves from pending to active while the price is in effect
+		// and on to done; a change without an End is done once applied.
+		Status string `json:"status"`
+		// Previous is the price the scheduled one replaced.
+		Previous *models.Money `json:"previous,omitempty"`
+	}
+
+	// PricePoint is a price a product had from one time until another. The
+	// current price has no Until; a price from before the audit log began
+	// has no From.
+	PricePoint struct {
+		Price models.Money `json:"price"`
+		From  *time.Time   `json:"from,omitempty"`
+		Until *time.Time   `json:"until,omitempty"`
+	}
+
+	// PriceSchedule holds the effective-dated prices waiting to be applied
+	// by the scheduler. Like the Inventory it is kept in memory and, when
+	// opened from a journal, saved to it before each change.
+	PriceSchedule struct {
+		sync.RWMutex
+
+		prices []*ScheduledPrice
+		nextID int64
+
+		// journal keeps a record per scheduled price; nil for none.
+		journal Journal
+	}
+)
+
+func NewPriceSchedule() *PriceSchedule {
+	return &PriceSchedule{}
+}
+
+// OpenPriceSchedule loads the scheduled prices kept in j, with their status
+// and the prices they replaced, and saves every change to it from then on.
+func OpenPriceSchedule(j Journal) (*PriceSchedule, error) {
+	s := NewPriceSchedule()
+	s.journal = j
+	if j == nil {
+		return s, nil
+	}
+
+	records, err := j.Records(_priceKind)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		p := new(ScheduledPrice)
+		if err := json.Unmarshal(r.Value, p); err != nil {
+			return nil, fmt.Errorf("loading scheduled price %s: %w", r.Key, err)
+		}
+		s.prices = append(s.prices, p)
+		s.nextID = max(s.nextID, p.ID)
+	}
+
+	return s, nil
+}
+
+// Schedule sets price for the product with the given code from start
+// until end, or for good without an end. The price must be in currency, the
+// product's, which it defaults to. A schedule may not overlap another for
+// the same product.
+func (s *PriceSchedule) Schedule(code, currency string, price models.Money, start time.Time, end *time.Time) (*ScheduledPrice, error) {
+	if err := validProductPrice(&price, currency); err != nil {
+		return nil, err
+	}
+	switch {
+	case start.IsZero():
+		return nil, &FieldError{Field: "start", Message: "is required"}
+	case end != nil && !end.After(start):
+		return nil, &FieldError{Field: "end", Message: "must be after start"}
+	case end != nil && !end.After(time.Now()):
+		return nil, &FieldError{Field: "end", Message: "must be in the future"}
+	}
+
+	p := &ScheduledPrice{
+		Code:   strings.ToUpper(code),
+		Price:  price,
+		Start:  start.UTC(),
+		Status: PricePending,
+	}
+	if end != nil {
+		t := end.UTC()
+		p.End = &t
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, other := range s.prices {
+		if other.Code == p.Code && other.live() && overlaps(p, other) {
+			return nil, fmt.Errorf("%w: %d", ErrPriceOverlap, other.ID)
+		}
+	}
+
+	p.ID = s.nextID + 1
+	if err := s.save(p); err != nil {
+		return nil, err
+	}
+	s.nextID = p.ID
+	s.prices = append(s.prices, p)
+
+	copied := *p
+	return &copied, nil
+}
+
+// Scheduled returns the schedules of the product with the given code,
+// earliest first.
+func (s *PriceSchedule) Scheduled(code string) []*ScheduledPrice {
+	code = strings.ToUpper(code)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	found := []*ScheduledPrice{}
+	for _, p := range s.prices {
+		if p.Code == code {
+			copied := *p
+			found = append(found, &copied)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Start.Before(found[j].Start)
+	})
+
+	return found
+}
+
+// Cancel drops a pending schedule. An active one is cut short instead, so
+// the scheduler puts the previous price back on its next run.
+func (s *PriceSchedule) Cancel(code string, id int64) error {
+	code = strings.ToUpper(code)
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, p := range s.prices {
+		if p.Code != code || p.ID != id {
+			continue
+		}
+
+		next := *p
+		switch p.Status {
+		case PricePending:
+			next.Status = PriceCancelled
+		case PriceActive:
+			now := time.Now().UTC()
+			next.End = &now
+		default:
+			return ErrPriceDone
+		}
+		return s.put(p, &next)
+	}
+
+	return ErrPriceNotFound
+}
+
+// RemoveProduct drops every schedule for a product code.
+func (s *PriceSchedule) RemoveProduct(code string) error {
+	code = strings.ToUpper(code)
+
+	s.Lock()
+	defer s.Unlock()
+
+	var records []*Record
+	for _, p := range s.prices {
+		if p.Code == code {
+			records = append(records, deleted(_priceKind, priceKey(p.ID)))
+		}
+	}
+	if err := save(s.journal, records...); err != nil {
+		return err
+	}
+
+	kept := s.prices[:0]
+	for _, p := range s.prices {
+		if p.Code != code {
+			kept = append(kept, p)
+		}
+	}
+	s.prices = kept
+
+	return nil
+}
+
+// PriceAt projects the price of the product with the given code at a
+// future time from its current price and the schedules still to run.
+func (s *PriceSchedule) PriceAt(code string, at time.Time, current models.Money) models.Money {
+	scheduled := s.Scheduled(code)
+
+	// base is the price outside any sale
+	base := current
+	for _, p := range scheduled {
+		if p.Status == PriceActive && p.Previous != nil {
+			base = *p.Previous
+		}
+	}
+
+	price := base
+	for _, p := range scheduled {
+		if !p.live() || p.Start.After(at) {
+			continue
+		}
+
+		switch {
+		case p.End == nil:
+			base = p.Price
+			price = base
+		case at.Before(*p.End):
+			price = p.Price
+		default:
+			price = base
+		}
+	}
+
+	return price
+}
+
+// Apply brings the prices in store in line with the schedule as of now:
+// ended prices are reverted before new ones start. A price that was
+// changed by hand while a scheduled one was in effect is left alone.
+// Schedules that fail to apply, say because the product was written at the
+// same moment, are retried on the next run; the first error is returned.
+func (s *PriceSchedule) Apply(store Store, now time.Time) (changed []*ScheduledPrice, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	note := func(p *ScheduledPrice, ok bool, e error) {
+		if e != nil && err == nil {
+			err = fmt.Errorf("applying scheduled price %d: %w", p.ID, e)
+		}
+		if ok {
+			copied := *p
+			changed = append(changed, &copied)
+		}
+	}
+
+	for _, p := range s.prices {
+		if p.Status == PriceActive && !now.Before(*p.End) {
+			ok, e := s.revert(p, store)
+			note(p, ok, e)
+		}
+	}
+
+	sort.SliceStable(s.prices, func(i, j int) bool {
+		return s.prices[i].Start.Before(s.prices[j].Start)
+	})
+	for _, p := range s.prices {
+		switch {
+		case p.Status != PricePending || now.Before(p.Start):
+		case p.End != nil && !now.Before(*p.End):
+			// missed entirely while the scheduler wasn't running
+			next := *p
+			next.Status = PriceDone
+			note(p, false, s.put(p, &next))
+		default:
+			ok, e := s.activate(p, store)
+			note(p, ok, e)
+		}
+	}
+
+	return changed, err
+}
+
+// activate puts the scheduled price in effect, reporting whether it did.
+// The schedule is saved as active, with the price it replaces, before the
+// product is written, so a restart in between can't take the scheduled
+// price for the one to go back to. Callers hold the lock.
+func (s *PriceSchedule) activate(p *ScheduledPrice, store Store) (bool, error) {
+	item := store.Get(p.Code)
+	if item == nil {
+		// trashed; it may yet be restored
+		return false, nil
+	}
+
+	next := *p
+	previous := item.Price
+	next.Previous = &previous
+	next.Status = PriceActive
+	if next.End == nil {
+		next.Status = PriceDone
+	}
+	if err := s.save(&next); err != nil {
+		return false, err
+	}
+
+	updated := *item
+	updated.Price = p.Price
+	if _, err := store.Update(item.Code, &updated, item.Version); err != nil {
+		if saveErr := s.save(p); saveErr != nil {
+			log.Printf("restoring scheduled price %d [ERR: %s]", p.ID, saveErr)
+		}
+		return false, err
+	}
+	*p = next
+
+	return true, nil
+}
+
+// revert puts the previous price back, reporting whether it did. Callers
+// hold the lock.
+func (s *PriceSchedule) revert(p *ScheduledPrice, store Store) (bool, error) {
+	next := *p
+	next.Status = PriceDone
+
+	item := store.Get(p.Code)
+	if item == nil || item.Price != p.Price {
+		return false, s.put(p, &next)
+	}
+
+	updated := *item
+	updated.Price = *p.Previous
+	if _, err := store.Update(item.Code, &updated, item.Version); err != nil {
+		return false, err
+	}
+
+	return true, s.put(p, &next)
+}
+
+// put saves next in place of p, then makes it p. Callers hold the lock.
+func (s *PriceSchedule) put(p, next *ScheduledPrice) error {
+	if err := s.save(next); err != nil {
+		return err
+	}
+	*p = *next
+
+	return nil
+}
+
+// save writes p to the journal, if there is one.
+func (s *PriceSchedule) save(p *ScheduledPrice) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	rec, err := newRecord(_priceKind, priceKey(p.ID), p)
+	if err != nil {
+		return err
+	}
+
+	return save(s.journal, rec)
+}
+
+// priceKey keys a scheduled price by its ID, padded so the journal orders
+// the keys as it does the IDs.
+func priceKey(id int64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// live reports whether the schedule is still to run or in effect.
+func (p *ScheduledPrice) live() bool {
+	return p.Status == PricePending || p.Status == PriceActive
+}
+
+// overlaps reports whether a and b would be in effect at the same time. A
+// schedule without an end takes effect in an instant.
+func overlaps(a, b *ScheduledPrice) bool {
+	switch {
+	case a.End == nil && b.End == nil:
+		return a.Start.Equal(b.Start)
+	case a.End == nil:
+		return !a.Start.Before(b.Start) && a.Start.Before(*b.End)
+	case b.End == nil:
+		return !b.Start.Before(a.Start) && b.Start.Before(*a.End)
+	}
+
+	return a.Start.Before(*b.End) && b.Start.Before(*a.End)
+}
+
+// validPrice checks a price set apart from its product, defaulting its
+// currency to config.CURRENCY.
+func validPrice(price *models.Money) error {
+	price.Currency = strings.ToUpper(price.Currency)
+	if price.Currency == "" {
+		price.Currency = config.CURRENCY
+	}
+
+	switch {
+	case !models.ValidCurrency(price.Currency):
+		return &FieldError{Field: "price", Message: fmt.Sprintf("currency %q is not supported", price.Currency)}
+	case price.Amount < 0:
+		return &FieldError{Field: "price", Message: "must not be negative"}
+	}
+
+	return nil
+}
+
+// validProductPrice is validPrice for a price of a product priced in
+// currency. A price without a currency takes the product's; one in any
+// other currency is refused.
+func validProductPrice(price *models.Money, currency string) error {
+	currency = strings.ToUpper(currency)
+	if price.Currency == "" {
+		price.Currency = currency
+	}
+	if err := validPrice(price); err != nil {
+		return err
+	}
+	if price.Currency != currency {
+		return &FieldError{Field: "price", Message: fmt.Sprintf("currency %s does not match the product's %s", price.Currency, currency)}
+	}
+
+	return nil
+}
+
+// PriceHistory returns the prices the product with the given code has had,
+// oldest first, going by the audit log.
+func PriceHistory(code string) []*PricePoint {
+	points := []*PricePoint{}
+	if Audit == nil {
+		return points
+	}
+
+	entries := Audit.History(code)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		t := entry.Time
+
+		var last *PricePoint
+		if n := len(points); n > 0 && points[n-1].Until == nil {
+			last = points[n-1]
+		}
+
+		switch {
+		case entry.After == nil:
+			if last != nil {
+				last.Until = &t
+			}
+		case last == nil:
+			points = append(points, &PricePoint{Price: entry.After.Price, From: &t})
+		case last.Price != entry.After.Price:
+			last.Until = &t
+			points = append(points, &PricePoint{Price: entry.After.Price, From: &t})
+		}
+	}
+
+	return points
+}
+
+// RunPriceScheduler applies the schedule to store every interval until done
+// is closed.
+func RunPriceScheduler(store Store, interval time.Duration, done <-chan int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			changed, err := Prices.Apply(store, now)
+			if err != nil {
+				log.Printf("applying scheduled prices [ERR: %s]", err)
+			}
+			if len(changed) > 0 {
+				log.Printf("applied %d scheduled price changes", len(changed))
+			}
+		}
+	}
+}
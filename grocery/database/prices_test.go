@@ -0,0 +1,301 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"grocery/models"
+)
+
+func TestSchedulePrice(t *testing.T) {
+	var (
+		s    = NewPriceSchedule()
+		now  = time.Now()
+		day  = 24 * time.Hour
+		at   = func(d time.Duration) time.Time { return now.Add(d) }
+		till = func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+		sale = models.NewMoney(199, "USD")
+	)
+
+	if _, err := s.Schedule("code", "USD", sale, at(day), till(8*day)); err != nil {
+		t.Fatalf("failed to schedule price [ERR: %s]", err)
+	}
+
+	var scheduleTable = []struct {
+		name    string
+		code    string
+		price   models.Money
+		start   time.Time
+		end     *time.Time
+		wantErr error
+		field   string
+	}{
+		{"no start", "code", sale, time.Time{}, nil, nil, "start"},
+		{"end before start", "code", sale, at(9 * day), till(8 * day), nil, "end"},
+		{"ended", "code", sale, at(-2 * day), till(-day), nil, "end"},
+		{"negative", "code", models.NewMoney(-1, "USD"), at(9 * day), nil, nil, "price"},
+		{"bad currency", "code", models.NewMoney(1, "XYZ"), at(9 * day), nil, nil, "price"},
+		{"other currency", "code", models.NewMoney(1, "EUR"), at(9 * day), nil, nil, "price"},
+		{"overlap", "code", sale, at(7 * day), till(9 * day), ErrPriceOverlap, ""},
+		{"change during", "code", sale, at(2 * day), nil, ErrPriceOverlap, ""},
+		{"after", "code", sale, at(8 * day), till(9 * day), nil, ""},
+		{"change at", "code", sale, at(10 * day), nil, nil, ""},
+		{"same change", "CODE", sale, at(10 * day), nil, ErrPriceOverlap, ""},
+		{"other product", "other", sale, at(day), till(8 * day), nil, ""},
+	}
+
+	for _, tc := range scheduleTable {
+		_, err := s.Schedule(tc.code, "USD", tc.price, tc.start, tc.end)
+
+		var fieldErr *FieldError
+		switch {
+		case tc.field != "":
+			if !errors.As(err, &fieldErr) || fieldErr.Field != tc.field {
+				t.Errorf("%s: wanted an error on %s but got %v", tc.name, tc.field, err)
+			}
+		case !errors.Is(err, tc.wantErr):
+			t.Errorf("%s: wanted %v but got %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	if n := len(s.Scheduled("Code")); n != 3 {
+		t.Errorf("wanted 3 schedules for the product but got %d", n)
+	}
+}
+
+func TestApplyPrices(t *testing.T) {
+	var (
+		store = NewDatabase()
+		s     = NewPriceSchedule()
+		now   = time.Now()
+		hour  = time.Hour
+	)
+
+	items, _ := store.Put(
+		&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")},
+		&models.Product{Name: "Syrup", Price: models.NewMoney(625, "USD")},
+	)
+	waffles, syrup := items[0].Code, items[1].Code
+
+	end := now.Add(2 * hour)
+	sale, _ := s.Schedule(waffles, "USD", models.NewMoney(399, "USD"), now.Add(hour), &end)
+	s.Schedule(waffles, "USD", models.NewMoney(475, "USD"), now.Add(3*hour), nil)
+	s.Schedule(syrup, "USD", models.NewMoney(599, "USD"), now.Add(hour), &end)
+
+	price := func(code string) int64 {
+		return store.Get(code).Price.Amount
+	}
+
+	if changed, err := s.Apply(store, now); err != nil || len(changed) != 0 {
+		t.Fatalf("wanted nothing applied yet but got %v [ERR: %v]", changed, err)
+	}
+
+	changed, err := s.Apply(store, now.Add(hour))
+	if err != nil || len(changed) != 2 {
+		t.Fatalf("wanted both sales applied but got %v [ERR: %v]", changed, err)
+	}
+	if price(waffles) != 399 || price(syrup) != 599 {
+		t.Errorf("wanted sale prices but got %d and %d", price(waffles), price(syrup))
+	}
+	if got := s.Scheduled(waffles)[0]; got.Status != PriceActive || got.Previous == nil || got.Previous.Amount != 450 {
+		t.Errorf("wanted the sale active over 4.50 but got %+v", got)
+	}
+
+	// repriced by hand during the sale
+	item := *store.Get(syrup)
+	item.Price = models.NewMoney(550, "USD")
+	if _, err := store.Update(syrup, &item, item.Version); err != nil {
+		t.Fatalf("failed to update product [ERR: %s]", err)
+	}
+
+	if _, err := s.Apply(store, end); err != nil {
+		t.Fatalf("failed to apply prices [ERR: %s]", err)
+	}
+	if price(waffles) != 450 || price(syrup) != 550 {
+		t.Errorf("wanted 4.50 reverted and 5.50 kept but got %d and %d", price(waffles), price(syrup))
+	}
+
+	if _, err := s.Apply(store, now.Add(3*hour)); err != nil {
+		t.Fatalf("failed to apply prices [ERR: %s]", err)
+	}
+	if price(waffles) != 475 {
+		t.Errorf("wanted the permanent change applied but got %d", price(waffles))
+	}
+	for _, p := range s.Scheduled(waffles) {
+		if p.Status != PriceDone {
+			t.Errorf("wanted schedule %d done but got %s", p.ID, p.Status)
+		}
+	}
+
+	if err := s.Cancel(waffles, sale.ID); err != ErrPriceDone {
+		t.Errorf("wanted ErrPriceDone cancelling a finished sale but got %v", err)
+	}
+	if err := s.Cancel(waffles, 99); err != ErrPriceNotFound {
+		t.Errorf("wanted ErrPriceNotFound but got %v", err)
+	}
+}
+
+func TestApplyPricesMissed(t *testing.T) {
+	var (
+		store = NewDatabase()
+		s     = NewPriceSchedule()
+		now   = time.Now()
+	)
+
+	items, _ := store.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")})
+	code := items[0].Code
+
+	end := now.Add(time.Hour)
+	s.Schedule(code, "USD", models.NewMoney(399, "USD"), now.Add(-time.Hour), &end)
+
+	// the scheduler wasn't running for the whole sale
+	if changed, _ := s.Apply(store, end.Add(time.Minute)); len(changed) != 0 {
+		t.Errorf("wanted the missed sale skipped but got %v", changed)
+	}
+	if got := store.Get(code).Price.Amount; got != 450 {
+		t.Errorf("wanted 4.50 untouched but got %d", got)
+	}
+
+	// cancelling an active sale ends it on the next run
+	later := now.Add(2 * time.Hour)
+	sale, _ := s.Schedule(code, "USD", models.NewMoney(349, "USD"), now, &later)
+	s.Apply(store, now)
+	if err := s.Cancel(code, sale.ID); err != nil {
+		t.Fatalf("failed to cancel sale [ERR: %s]", err)
+	}
+	s.Apply(store, time.Now())
+	if got := store.Get(code).Price.Amount; got != 450 {
+		t.Errorf("wanted 4.50 back after cancelling but got %d", got)
+	}
+}
+
+func TestOpenPriceSchedule(t *testing.T) {
+	for driver, open := range testJournals(t) {
+		var (
+			store = NewDatabase()
+			now   = time.Now()
+			hour  = time.Hour
+		)
+
+		items, _ := store.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")})
+		code := items[0].Code
+
+		s, err := OpenPriceSchedule(open())
+		if err != nil {
+			t.Fatalf("%s: failed to open price schedule [ERR: %s]", driver, err)
+		}
+		end := now.Add(2 * hour)
+		sale, _ := s.Schedule(code, "USD", models.NewMoney(399, "USD"), now.Add(hour), &end)
+		later, _ := s.Schedule(code, "USD", models.NewMoney(475, "USD"), now.Add(3*hour), nil)
+		s.Cancel(code, later.ID)
+		s.Schedule("other", "USD", models.NewMoney(100, "USD"), now.Add(hour), nil)
+		s.RemoveProduct("other")
+		if _, err := s.Apply(store, now.Add(hour)); err != nil {
+			t.Fatalf("%s: failed to apply prices [ERR: %s]", driver, err)
+		}
+
+		// a restart during the sale still knows the price to go back to
+		if s, err = OpenPriceSchedule(open()); err != nil {
+			t.Fatalf("%s: failed to reopen price schedule [ERR: %s]", driver, err)
+		}
+		scheduled := s.Scheduled(code)
+		if len(scheduled) != 2 || scheduled[0].Status != PriceActive || scheduled[0].Previous == nil || scheduled[1].Status != PriceCancelled {
+			t.Fatalf("%s: wanted the active sale and the cancelled change but got %+v", driver, scheduled)
+		}
+		if len(s.Scheduled("other")) != 0 {
+			t.Errorf("%s: wanted the removed product's schedule gone", driver)
+		}
+		if _, err := s.Apply(store, end); err != nil {
+			t.Fatalf("%s: failed to apply prices [ERR: %s]", driver, err)
+		}
+		if got := store.Get(code).Price.Amount; got != 450 {
+			t.Errorf("%s: wanted 4.50 back after the sale but got %d", driver, got)
+		}
+
+		// IDs carry on from the highest loaded
+		if s, err = OpenPriceSchedule(open()); err != nil {
+			t.Fatalf("%s: failed to reopen price schedule [ERR: %s]", driver, err)
+		}
+		if got := s.Scheduled(code)[0]; got.ID != sale.ID || got.Status != PriceDone {
+			t.Errorf("%s: wanted the sale done after reopening but got %+v", driver, got)
+		}
+		next, _ := s.Schedule(code, "USD", models.NewMoney(1, "USD"), now.Add(9*hour), nil)
+		if next == nil || next.ID != 3 {
+			t.Errorf("%s: wanted the next ID to be 3 but got %+v", driver, next)
+		}
+	}
+}
+
+func TestPriceAt(t *testing.T) {
+	var (
+		s       = NewPriceSchedule()
+		now     = time.Now()
+		day     = 24 * time.Hour
+		current = models.NewMoney(450, "USD")
+	)
+
+	end := now.Add(2 * day)
+	s.Schedule("code", "USD", models.NewMoney(399, "USD"), now.Add(day), &end)
+	s.Schedule("code", "USD", models.NewMoney(475, "USD"), now.Add(3*day), nil)
+	cancelled, _ := s.Schedule("code", "USD", models.NewMoney(1, "USD"), now.Add(4*day), nil)
+	s.Cancel("code", cancelled.ID)
+
+	var priceTable = []struct {
+		at   time.Duration
+		want int64
+	}{
+		{0, 450},
+		{day, 399},
+		{2 * day, 450},
+		{3 * day, 475},
+		{5 * day, 475},
+	}
+
+	for _, tc := range priceTable {
+		if got := s.PriceAt("code", now.Add(tc.at), current); got.Amount != tc.want {
+			t.Errorf("%s: wanted %d but got %d", tc.at, tc.want, got.Amount)
+		}
+	}
+}
+
+func TestPriceHistory(t *testing.T) {
+	withAudit(t, NewAuditLog())
+	store := Audited(NewDatabase(), Actor{Name: "tester"})
+
+	items, _ := store.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")})
+	code := items[0].Code
+
+	store.Update(code, &models.Product{Name: "Waffles", Brand: "Eggo", Price: models.NewMoney(450, "USD")}, 1)
+	store.Update(code, &models.Product{Name: "Waffles", Price: models.NewMoney(399, "USD")}, 2)
+	store.Del(code, 3)
+	store.Restore(code, 4)
+
+	// pin the times so lookups don't depend on the clock's resolution
+	entries := Audit.History(code)
+	for i, entry := range entries {
+		entry.Time = time.Now().Add(-time.Duration(i) * time.Minute)
+	}
+
+	history := PriceHistory(code)
+	var prices []int64
+	for _, point := range history {
+		prices = append(prices, point.Price.Amount)
+	}
+	if len(prices) != 3 || prices[0] != 450 || prices[1] != 399 || prices[2] != 399 {
+		t.Fatalf("wanted 4.50, 3.99 and 3.99 again after the restore but got %v", prices)
+	}
+	if history[1].Until == nil || history[2].Until != nil {
+		t.Error("wanted only the current price open-ended")
+	}
+
+	if item, ok := Audit.At(code, entries[len(entries)-1].Time); !ok || item == nil || item.Price.Amount != 450 {
+		t.Errorf("wanted the product as created but got %v", item)
+	}
+	if item, ok := Audit.At(code, entries[1].Time); !ok || item != nil {
+		t.Errorf("wanted the product gone while deleted but got %v", item)
+	}
+	if _, ok := Audit.At("this-isnt-real-code", time.Now()); ok {
+		t.Error("wanted no history for a missing product")
+	}
+}
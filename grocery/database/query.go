@@ -0,0 +1,495 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	_tokEOF = iota
+	_tokWord
+	_tokString
+	_tokOp
+	_tokLParen
+	_tokRParen
+)
+
+const (
+	// _maxQueryDepth is how deeply parentheses and NOTs can nest in a query.
+	_maxQueryDepth = 32
+)
+
+var (
+	// textFields and numberFields are the product fields a query can name.
+	textFields = map[string]func(*models.Product) string{
+		"name":        func(p *models.Product) string { return p.Name },
+		"brand":       func(p *models.Product) string { return p.Brand },
+		"category":    func(p *models.Product) string { return p.Category },
+		"sku":         func(p *models.Product) string { return p.SKU },
+		"barcode":     func(p *models.Product) string { return p.Barcode },
+		"description": func(p *models.Product) string { return p.Description },
+		"unit":        func(p *models.Product) string { return p.Unit },
+		"code":        func(p *models.Product) string { return p.Code },
+	}
+	numberFields = map[string]bool{
+		"price":   true,
+		"size":    true,
+		"version": true,
+		"stock":   true,
+	}
+)
+
+type (
+	// Query is a parsed filter expression such as
+	//
+	//	price<2.50 AND category:produce AND name:"green*"
+	//
+	// Terms are field comparisons or bare words, combined with AND, OR, NOT
+	// and parentheses; terms side by side are ANDed. On text fields ":"
+	// matches a case-insensitive substring, or the whole value as a glob
+	// when it contains "*", where "*" matches any run of characters and "?"
+	// any one, and "=" matches the whole value. Number fields
+	// (price, size, version, stock) take =, !=, <, <=, > and >=. A bare word
+	// matches the name, brand or category.
+	Query struct {
+		root queryNode
+	}
+
+	// QueryError points at the token a query failed to parse at.
+	QueryError struct {
+		// Column is the 1-based position of the token in the query.
+		Column  int    `json:"column"`
+		Token   string `json:"token"`
+		Message string `json:"message"`
+	}
+
+	queryToken struct {
+		kind  int
+		text  string
+		start int
+	}
+
+	queryParser struct {
+		tokens []queryToken
+		pos    int
+		// depth is how many parentheses and NOTs enclose the current term.
+		depth int
+	}
+
+	queryNode interface {
+		match(*models.Product) bool
+	}
+
+	andNode  struct{ left, right queryNode }
+	orNode   struct{ left, right queryNode }
+	notNode  struct{ node queryNode }
+	wordNode struct{ word string }
+
+	textNode struct {
+		value func(*models.Product) string
+		op    string
+		want  string
+	}
+
+	numberNode struct {
+		field string
+		op    string
+		want  float64
+		price models.Money
+	}
+)
+
+func (e *QueryError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("query error at column %d: %s", e.Column, e.Message)
+	}
+
+	return fmt.Sprintf("query error at column %d near %q: %s", e.Column, e.Token, e.Message)
+}
+
+// ParseQuery parses a filter expression. Errors are *QueryError.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+	if p.peek().kind == _tokEOF {
+		return nil, p.errorf(p.peek(), "empty query")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != _tokEOF {
+		return nil, p.errorf(tok, "unexpected token")
+	}
+
+	return &Query{root: root}, nil
+}
+
+// Match reports whether the product satisfies the query.
+func (q *Query) Match(item *models.Product) bool {
+	return q.root.match(item)
+}
+
+// Filter returns the products that satisfy the query, in their given order.
+func (q *Query) Filter(items []*models.Product) (matched []*models.Product) {
+	for _, item := range items {
+		if q.Match(item) {
+			matched = append(matched, item)
+		}
+	}
+
+	return
+}
+
+func lexQuery(s string) ([]queryToken, error) {
+	var (
+		tokens []queryToken
+		runes  = []rune(s)
+	)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{_tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{_tokRParen, ")", i})
+			i++
+		case r == ':' || r == '=' || r == '<' || r == '>' || r == '!':
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' && r != ':' && r != '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &QueryError{Column: start + 1, Token: op, Message: "expected !="}
+			}
+			tokens = append(tokens, queryToken{_tokOp, op, start})
+		case r == '"':
+			start := i
+			var b strings.Builder
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, &QueryError{Column: start + 1, Token: string(runes[start:]), Message: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, queryToken{_tokString, b.String(), start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()":=<>!`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{_tokWord, string(runes[start:i]), start})
+		}
+	}
+
+	return append(tokens, queryToken{_tokEOF, "", len(runes)}), nil
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != _tokEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *queryParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == _tokWord && tok.text == word
+}
+
+func (p *queryParser) errorf(tok queryToken, format string, args ...interface{}) *QueryError {
+	return &QueryError{
+		Column:  tok.start + 1,
+		Token:   tok.text,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// enter descends into the term tok opens, refusing to nest deeper than
+// _maxQueryDepth so a hostile query can't run the parser's stack up.
+func (p *queryParser) enter(tok queryToken) error {
+	if p.depth == _maxQueryDepth {
+		return p.errorf(tok, "query nested more than %d deep", _maxQueryDepth)
+	}
+	p.depth++
+
+	return nil
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: unary (["AND"] unary)*
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind == _tokEOF || tok.kind == _tokRParen || p.isKeyword("OR") {
+			return left, nil
+		}
+		if p.isKeyword("AND") {
+			p.next()
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+}
+
+// parseUnary parses: "NOT" unary | primary
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.isKeyword("NOT") {
+		if err := p.enter(p.next()); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return &notNode{node}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary parses: "(" or ")" | field op value | word | string
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case _tokLParen:
+		if err := p.enter(tok); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != _tokRParen {
+			return nil, p.errorf(closing, "expected )")
+		}
+		p.depth--
+		return node, nil
+	case _tokString:
+		return &wordNode{strings.ToLower(tok.text)}, nil
+	case _tokWord:
+		if tok.text == "AND" || tok.text == "OR" {
+			return nil, p.errorf(tok, "expected a term")
+		}
+		if p.peek().kind == _tokOp {
+			return p.parseComparison(tok)
+		}
+		return &wordNode{strings.ToLower(tok.text)}, nil
+	case _tokEOF:
+		return nil, p.errorf(tok, "unexpected end of query")
+	default:
+		return nil, p.errorf(tok, "expected a term")
+	}
+}
+
+func (p *queryParser) parseComparison(field queryToken) (queryNode, error) {
+	op := p.next()
+
+	value := p.next()
+	if value.kind != _tokWord && value.kind != _tokString {
+		return nil, p.errorf(value, "expected a value after %s", op.text)
+	}
+
+	name := strings.ToLower(field.text)
+
+	if get, ok := textFields[name]; ok {
+		if op.text != ":" && op.text != "=" && op.text != "!=" {
+			return nil, p.errorf(op, "%s can only be compared with :, = or !=", name)
+		}
+		return &textNode{value: get, op: op.text, want: strings.ToLower(value.text)}, nil
+	}
+
+	if numberFields[name] {
+		node := &numberNode{field: name, op: op.text}
+		if node.op == ":" {
+			node.op = "="
+		}
+
+		if name == "price" {
+			price, err := models.ParseMoney(value.text, config.CURRENCY)
+			if err != nil {
+				return nil, p.errorf(value, "invalid price")
+			}
+			node.price = price
+		} else {
+			n, err := strconv.ParseFloat(value.text, 64)
+			if err != nil {
+				return nil, p.errorf(value, "invalid number")
+			}
+			node.want = n
+		}
+		return node, nil
+	}
+
+	return nil, p.errorf(field, "unknown field")
+}
+
+func (n *andNode) match(item *models.Product) bool {
+	return n.left.match(item) && n.right.match(item)
+}
+
+func (n *orNode) match(item *models.Product) bool {
+	return n.left.match(item) || n.right.match(item)
+}
+
+func (n *notNode) match(item *models.Product) bool {
+	return !n.node.match(item)
+}
+
+func (n *wordNode) match(item *models.Product) bool {
+	for _, field := range []string{item.Name, item.Brand, item.Category} {
+		if strings.Contains(strings.ToLower(field), n.word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *textNode) match(item *models.Product) bool {
+	value := strings.ToLower(n.value(item))
+
+	var matched bool
+	switch {
+	case strings.Contains(n.want, "*"):
+		matched = globMatch(n.want, value)
+	case n.op == ":":
+		matched = strings.Contains(value, n.want)
+	default:
+		matched = value == n.want
+	}
+
+	if n.op == "!=" {
+		return !matched
+	}
+
+	return matched
+}
+
+// globMatch reports whether s matches pattern as a whole, where "*" matches
+// any run of runes and "?" any single one. Every other rune, "/" and "["
+// included, matches only itself.
+func globMatch(pattern, s string) bool {
+	pat, str := []rune(pattern), []rune(s)
+
+	// star is the pattern position of the last "*" seen and resume the
+	// position in s it is next tried against, so a mismatch after it
+	// backtracks to let the "*" take one more rune
+	var p, i int
+	star, resume := -1, 0
+	for i < len(str) {
+		switch {
+		case p < len(pat) && pat[p] == '*':
+			star, resume = p, i
+			p++
+		case p < len(pat) && (pat[p] == '?' || pat[p] == str[i]):
+			p++
+			i++
+		case star >= 0:
+			resume++
+			p, i = star+1, resume
+		default:
+			return false
+		}
+	}
+
+	for p < len(pat) && pat[p] == '*' {
+		p++
+	}
+
+	return p == len(pat)
+}
+
+func (n *numberNode) match(item *models.Product) bool {
+	var have, want float64
+
+	switch n.field {
+	case "price":
+		if item.Price.Currency != n.price.Currency {
+			return false
+		}
+		have, want = float64(item.Price.Amount), float64(n.price.Amount)
+	case "size":
+		have, want = item.Size, n.want
+	case "version":
+		have, want = float64(item.Version), n.want
+	case "stock":
+		if Inventory == nil {
+			return false
+		}
+		have, want = float64(Inventory.Level(item.Code).OnHand), n.want
+	}
+
+	switch n.op {
+	case "=":
+		return have == want
+	case "!=":
+		return have != want
+	case "<":
+		return have < want
+	case "<=":
+		return have <= want
+	case ">":
+		return have > want
+	case ">=":
+		return have >= want
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"grocery/models"
+)
+
+func queryTestProducts() []*models.Product {
+	return []*models.Product{
+		{Code: "AAAA-0000-0000-0001", Name: "Green Pepper", Category: "produce", Price: models.NewMoney(79, "USD")},
+		{Code: "AAAA-0000-0000-0002", Name: "Green Tea", Brand: "Leafy", Category: "beverages", Price: models.NewMoney(449, "USD"), Unit: "oz", Size: 16},
+		{Code: "AAAA-0000-0000-0003", Name: "Gala Apple", Category: "produce", Price: models.NewMoney(359, "USD")},
+		{Code: "AAAA-0000-0000-0004", Name: "Evergreen Mints", Category: "candy", Price: models.NewMoney(199, "USD")},
+		{Code: "AAAA-0000-0000-0005", Name: "Kiwi", Category: "produce", Price: models.NewMoney(50, "EUR")},
+	}
+}
+
+func TestQuery(t *testing.T) {
+	var queryTable = []struct {
+		query string
+		want  []string
+	}{
+		{`price<2.50 AND category:produce AND name:"green*"`, []string{"Green Pepper"}},
+		{`name:green`, []string{"Green Pepper", "Green Tea", "Evergreen Mints"}},
+		{`name="gala apple"`, []string{"Gala Apple"}},
+		{`category:produce price>=1`, []string{"Gala Apple"}},
+		{`category=candy OR brand:leafy`, []string{"Green Tea", "Evergreen Mints"}},
+		{`NOT category:produce`, []string{"Green Tea", "Evergreen Mints"}},
+		{`category:produce AND NOT (name:green OR name:kiwi)`, []string{"Gala Apple"}},
+		{`size>10 unit=oz`, []string{"Green Tea"}},
+		{`apple`, []string{"Gala Apple"}},
+		{`price!=0.79 category:produce`, []string{"Gala Apple"}},
+		{`name:"*tea" OR name:*mint*`, []string{"Green Tea", "Evergreen Mints"}},
+	}
+
+	for _, tc := range queryTable {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("%s: failed to parse [ERR: %s]", tc.query, err)
+			continue
+		}
+
+		var got []string
+		for _, item := range q.Filter(queryTestProducts()) {
+			got = append(got, item.Name)
+		}
+
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: wanted %v but got %v", tc.query, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: wanted %v but got %v", tc.query, tc.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	var errorTable = []struct {
+		query  string
+		column int
+		token  string
+	}{
+		{`price<2.50 AND colour:red`, 16, "colour"},
+		{`price<cheap`, 7, "cheap"},
+		{`category<produce`, 9, "<"},
+		{`name:"green`, 6, `"green`},
+		{`(name:green`, 12, ""},
+		{`name:green AND`, 15, ""},
+		{`name:green)`, 11, ")"},
+		{`price !5`, 7, "!"},
+		{`name:`, 6, ""},
+		{``, 1, ""},
+		{strings.Repeat("(", 40) + "apple" + strings.Repeat(")", 40), 33, "("},
+		{strings.Repeat("NOT ", 40) + "apple", 129, "NOT"},
+	}
+
+	for _, tc := range errorTable {
+		_, err := ParseQuery(tc.query)
+
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("%s: wanted QueryError but got %v", tc.query, err)
+			continue
+		}
+		if qerr.Column != tc.column || qerr.Token != tc.token {
+			t.Errorf("%s: wanted error at column %d near %q but got %s", tc.query, tc.column, tc.token, qerr)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	var globTable = []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"1*", "1/2 gal milk", true},
+		{"*milk", "1/2 gal milk", true},
+		{"*gal*", "1/2 gal milk", true},
+		{"1?2*", "1/2 gal milk", true},
+		{"[x*", "[x] brand", true},
+		{"[x*", "x brand", false},
+		{"a\\*", "a\\b", true},
+		{"*é", "café", true},
+		{"caf?", "café", true},
+		{"*a*b", "aaab", true},
+		{"*a*b", "aaba", false},
+		{"green*", "evergreen", false},
+		{"*", "", true},
+		{"?", "", false},
+	}
+
+	for _, tc := range globTable {
+		if got := globMatch(tc.pattern, tc.value); got != tc.want {
+			t.Errorf("%q against %q: wanted %t but got %t", tc.pattern, tc.value, tc.want, got)
+		}
+	}
+
+	q, err := ParseQuery(`name:"[x*"`)
+	if err != nil {
+		t.Fatalf("wanted [ to be matched literally but failed to parse [ERR: %s]", err)
+	}
+	if !q.Match(&models.Product{Name: "[X] Brand Milk"}) {
+		t.Error("wanted [x* to match a name starting with [x")
+	}
+}
@@ -0,0 +1,497 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"grocery/config"
+	"grocery/models"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	_productColumns = "code, name, price_amount, currency, version, sku, barcode, brand, category, description, unit, size, deleted_at"
+	_productParams  = "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"
+
+	_insertProduct = `INSERT INTO products (` + _productColumns + `) VALUES (` + _productParams + `)`
+	_updateProduct = `UPDATE products SET (` + _productColumns + `) = (` + _productParams + `) WHERE code = ?`
+	_selectLive    = `SELECT ` + _productColumns + ` FROM products WHERE code = ? AND deleted_at IS NULL`
+	_selectTrashed = `SELECT ` + _productColumns + ` FROM products WHERE code = ? AND deleted_at IS NOT NULL`
+
+	// _eachPageSize is how many products Each reads per query.
+	_eachPageSize = 500
+)
+
+type (
+	// SQLStore is the SQLite storage driver, registered as "sqlite". Search
+	// is served from an in-memory index built when the store is opened.
+	SQLStore struct {
+		// mu keeps the index in step with the table by serializing writes
+		mu    sync.Mutex
+		db    *sql.DB
+		index *Index
+	}
+)
+
+func init() {
+	Register("sqlite", func() (Store, error) {
+		return OpenSQLite(filepath.Join(config.DBPATH, config.DBNAME))
+	})
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path and
+// migrates it to the latest schema.
+func OpenSQLite(path string) (*SQLStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer; one connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if _, err := migrate(db, migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLStore{db: db, index: NewIndex()}
+	for _, item := range s.List() {
+		s.index.Add(item)
+	}
+
+	return s, nil
+}
+
+// Search ranks the products matching keyword by relevance.
+func (s *SQLStore) Search(keyword string) []*Match {
+	return s.index.Search(keyword)
+}
+
+// Suggest completes a partly typed product name.
+func (s *SQLStore) Suggest(prefix string, limit int) []string {
+	return s.index.Suggest(prefix, limit)
+}
+
+func (s *SQLStore) Get(code string) *models.Product {
+	if code == "" {
+		return nil
+	}
+
+	item, err := scanProduct(s.db.QueryRow(_selectLive, code))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("getting product %q [ERR: %s]", code, err)
+		}
+		return nil
+	}
+
+	return item
+}
+
+func (s *SQLStore) List() []*models.Product {
+	return s.query(`SELECT ` + _productColumns + ` FROM products WHERE deleted_at IS NULL ORDER BY rowid`)
+}
+
+// Each reads the products a page at a time, so neither the catalog nor the
+// store's only connection is held for as long as fn takes.
+func (s *SQLStore) Each(fn func(*models.Product) error) error {
+	var after int64
+	for {
+		rows, err := s.db.Query(`SELECT rowid, `+_productColumns+` FROM products WHERE deleted_at IS NULL AND rowid > ? ORDER BY rowid LIMIT ?`, after, _eachPageSize)
+		if err != nil {
+			return err
+		}
+
+		page := make([]*models.Product, 0, _eachPageSize)
+		for rows.Next() {
+			item, err := scanProduct(rowidScanner{rows, &after})
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(page) < _eachPageSize {
+			return nil
+		}
+	}
+}
+
+// Trash returns the trashed products, most recently deleted first.
+func (s *SQLStore) Trash() []*models.Product {
+	return s.query(`SELECT ` + _productColumns + ` FROM products WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`)
+}
+
+func (s *SQLStore) Put(items ...*models.Product) (products []*models.Product, errs []error) {
+	if errs = prepare(items); len(errs) > 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	for _, item := range items {
+		_, err := tx.Exec(_insertProduct, productValues(item)...)
+		if err != nil {
+			tx.Rollback()
+			return nil, []error{err}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, []error{err}
+	}
+
+	for _, item := range items {
+		s.index.Add(item)
+	}
+
+	return items, nil
+}
+
+func (s *SQLStore) Update(code string, item *models.Product, version int64) (*models.Product, error) {
+	if code == "" {
+		return nil, errors.New("invalid product code")
+	}
+	if err := validate(item); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getRow(tx, _selectLive, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+
+	item.Code = existing.Code
+	item.Version = existing.Version + 1
+
+	if _, err := tx.Exec(_updateProduct, append(productValues(item), item.Code)...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.index.Add(item)
+
+	return item, nil
+}
+
+func (s *SQLStore) Del(code string, version int64) error {
+	if code == "" {
+		return errors.New("invalid product code")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.move(_selectLive, code, version, trashed); err != nil {
+		return err
+	}
+	s.index.Remove(code)
+
+	return nil
+}
+
+func (s *SQLStore) Restore(code string, version int64) (*models.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.move(_selectTrashed, code, version, restored)
+	if err != nil {
+		return nil, err
+	}
+	s.index.Add(item)
+
+	return item, nil
+}
+
+// move rewrites the product found by query as change makes it, for moving
+// products in and out of the trash. Callers hold s.mu.
+func (s *SQLStore) move(query, code string, version int64, change func(*models.Product) *models.Product) (*models.Product, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getRow(tx, query, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+
+	item := change(existing)
+	if _, err := tx.Exec(_updateProduct, append(productValues(item), item.Code)...); err != nil {
+		return nil, err
+	}
+
+	return item, tx.Commit()
+}
+
+func (s *SQLStore) Purge(before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT code FROM products WHERE deleted_at < ?`, before.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM products WHERE deleted_at < ?`, before.UnixNano()); err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// Batch applies ops atomically in one transaction.
+func (s *SQLStore) Batch(ops ...*BatchOp) ([]*BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var getErr error
+	get := func(code string) *models.Product {
+		item, err := scanProduct(tx.QueryRow(_selectLive, code))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) && getErr == nil {
+			getErr = err
+		}
+		return item
+	}
+
+	results, err := stageBatch(get, ops)
+	if getErr != nil {
+		return nil, getErr
+	}
+	if err != nil {
+		return results, err
+	}
+
+	for _, result := range results {
+		if result.Op == BatchCreate {
+			_, err = tx.Exec(_insertProduct, productValues(result.Product)...)
+		} else {
+			_, err = tx.Exec(_updateProduct, append(productValues(result.Product), result.Code)...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Product.DeletedAt != nil {
+			s.index.Remove(result.Code)
+		} else {
+			s.index.Add(result.Product)
+		}
+	}
+
+	return results, nil
+}
+
+// Save writes the journal records in one transaction.
+func (s *SQLStore) Save(records ...*Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if r.Value == nil {
+			_, err = tx.Exec(`DELETE FROM records WHERE kind = ? AND key = ?`, r.Kind, r.Key)
+		} else {
+			_, err = tx.Exec(
+				`INSERT INTO records (kind, key, value) VALUES (?, ?, ?)
+				ON CONFLICT (kind, key) DO UPDATE SET value = excluded.value`,
+				r.Kind, r.Key, string(r.Value),
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Records returns the journal records of a kind, ordered by key.
+func (s *SQLStore) Records(kind string) ([]*Record, error) {
+	rows, err := s.db.Query(`SELECT key, value FROM records WHERE kind = ? ORDER BY key`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*Record{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		records = append(records, &Record{Kind: kind, Key: key, Value: json.RawMessage(value)})
+	}
+
+	return records, rows.Err()
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (found []*models.Product) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("querying products [ERR: %s]", err)
+		return nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanProduct(rows)
+		if err != nil {
+			log.Printf("scanning product [ERR: %s]", err)
+			return
+		}
+		found = append(found, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("reading products [ERR: %s]", err)
+	}
+
+	return
+}
+
+type (
+	scanner interface {
+		Scan(dest ...interface{}) error
+	}
+
+	// rowidScanner scans a leading rowid column into rowid before handing
+	// the rest to the caller's destinations.
+	rowidScanner struct {
+		rows  *sql.Rows
+		rowid *int64
+	}
+)
+
+func (r rowidScanner) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append([]interface{}{r.rowid}, dest...)...)
+}
+
+func scanProduct(row scanner) (*models.Product, error) {
+	var (
+		item      = new(models.Product)
+		deletedAt sql.NullInt64
+	)
+	err := row.Scan(
+		&item.Code, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Version,
+		&item.SKU, &item.Barcode, &item.Brand, &item.Category, &item.Description, &item.Unit, &item.Size,
+		&deletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		t := time.Unix(0, deletedAt.Int64).UTC()
+		item.DeletedAt = &t
+	}
+
+	return item, nil
+}
+
+// getRow reads the product found by query, mapping no rows to ErrNotFound.
+func getRow(tx *sql.Tx, query, code string) (*models.Product, error) {
+	item, err := scanProduct(tx.QueryRow(query, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return item, err
+}
+
+// productValues returns the product's fields in _productColumns order.
+func productValues(item *models.Product) []interface{} {
+	var deletedAt sql.NullInt64
+	if item.DeletedAt != nil {
+		deletedAt = sql.NullInt64{Int64: item.DeletedAt.UnixNano(), Valid: true}
+	}
+
+	return []interface{}{
+		item.Code, item.Name, item.Price.Amount, item.Price.Currency, item.Version,
+		item.SKU, item.Barcode, item.Brand, item.Category, item.Description, item.Unit, item.Size,
+		deletedAt,
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"grocery/models"
+)
+
+func openTestSQLite(t *testing.T, path string) *SQLStore {
+	t.Helper()
+
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite store [ERR: %s]", err)
+	}
+
+	return s
+}
+
+func TestSQLStore(t *testing.T) {
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "grocery.db"))
+	defer s.Close()
+
+	items, errs := s.Put(
+		&models.Product{Name: "Waffles", Price: models.NewMoney(981, "USD")},
+		&models.Product{Name: "Syrup", Price: models.NewMoney(625, "USD"), Brand: "Maple Grove", Barcode: "036000291452", Unit: "oz", Size: 12},
+	)
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	if items[0].Price != models.NewMoney(981, "USD") {
+		t.Errorf("wanted price 9.81 USD but got %v", items[0].Price)
+	}
+
+	if _, errs := s.Put(&models.Product{Name: "Bad!Name"}); len(errs) == 0 {
+		t.Error("expected validation error for non-alphanumeric name")
+	}
+
+	if got := s.Search("WAFF"); len(got) != 1 || got[0].Code != items[0].Code {
+		t.Errorf("search failed to locate product; got %v", got)
+	}
+	if got := s.Search("036000291452"); len(got) != 1 || got[0].Brand != "Maple Grove" || got[0].Size != 12 {
+		t.Errorf("search by barcode failed to locate product; got %v", got)
+	}
+	if got := s.Search("grove"); len(got) != 1 || got[0].Code != items[1].Code {
+		t.Errorf("search by brand failed to locate product; got %v", got)
+	}
+	if got := s.Search("%"); len(got) != 0 {
+		t.Errorf("wanted LIKE wildcard to be matched literally but got %v", got)
+	}
+
+	updated, err := s.Update(items[1].Code, &models.Product{Name: "Maple Syrup", Price: models.NewMoney(700, "USD")}, 0)
+	if err != nil {
+		t.Fatalf("failed to update product [ERR: %s]", err)
+	}
+	if updated.Code != items[1].Code {
+		t.Errorf("update changed product code from %s to %s", items[1].Code, updated.Code)
+	}
+	if got := s.Get(items[1].Code); got == nil || got.Name != "Maple Syrup" {
+		t.Errorf("update not visible through Get; got %s", got)
+	}
+	if _, err := s.Update("this-isnt-real-code", &models.Product{Name: "Plum"}, 0); err != ErrNotFound {
+		t.Errorf("wanted ErrNotFound for missing product but got %v", err)
+	}
+
+	if updated.Version != 2 {
+		t.Errorf("wanted version 2 after update but got %d", updated.Version)
+	}
+	if _, err := s.Update(items[1].Code, &models.Product{Name: "Syrup", Price: models.NewMoney(700, "USD")}, 1); err != ErrVersionMismatch {
+		t.Errorf("wanted ErrVersionMismatch updating stale version but got %v", err)
+	}
+	if err := s.Del(items[1].Code, 1); err != ErrVersionMismatch {
+		t.Errorf("wanted ErrVersionMismatch deleting stale version but got %v", err)
+	}
+
+	if err := s.Del(items[0].Code, items[0].Version); err != nil {
+		t.Fatalf("failed to delete product [ERR: %s]", err)
+	}
+	if got := len(s.List()); got != 1 {
+		t.Errorf("wanted 1 product after delete but got %d", got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grocery.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database [ERR: %s]", err)
+	}
+
+	// start from an older schema and let OpenSQLite upgrade it
+	if version, err := migrate(db, migrations[:1]); err != nil || version != 1 {
+		t.Fatalf("wanted schema version 1 but got %d [ERR: %v]", version, err)
+	}
+	if _, err := db.Exec(`INSERT INTO products (code, name, price) VALUES ('A12T-4GH7-QPL9-3N4M', 'Lettuce', 3.46)`); err != nil {
+		t.Fatalf("failed to insert product [ERR: %s]", err)
+	}
+	db.Close()
+
+	s := openTestSQLite(t, path)
+	defer s.Close()
+
+	latest := migrations[len(migrations)-1].Version
+	if version, err := schemaVersion(s.db); err != nil || version != latest {
+		t.Fatalf("wanted schema version %d but got %d [ERR: %v]", latest, version, err)
+	}
+
+	if got := s.Get("A12T-4GH7-QPL9-3N4M"); got == nil || got.Price != models.NewMoney(346, "USD") || got.Version != 1 {
+		t.Errorf("existing product not carried through migrations; got %s", got)
+	}
+
+	// running again is a no-op
+	if version, err := migrate(s.db, migrations); err != nil || version != latest {
+		t.Errorf("wanted re-run to stay at version %d but got %d [ERR: %v]", latest, version, err)
+	}
+}
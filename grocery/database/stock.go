@@ -0,0 +1,240 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StockReceive = "receive"
+	StockSell    = "sell"
+	StockAdjust  = "adjust"
+
+	// _maxMovements is how many recent movements are kept per product.
+	_maxMovements = 50
+
+	// _stockKind is the journal kind of the chain-wide stock ledger.
+	_stockKind = "stock"
+)
+
+var (
+	Inventory *Stock
+
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrInvalidMovement   = errors.New("invalid stock movement")
+)
+
+type (
+	// Stock tracks the quantity on hand of each product code. Every change
+	// is recorded as a movement with a reason. It is kept in memory and,
+	// when opened from a journal, saved to it before each change.
+	Stock struct {
+		sync.RWMutex
+
+		// Backorders allows sales to take the quantity on hand below zero.
+		Backorders bool
+
+		levels    map[string]*StockLevel
+		movements map[string][]*StockMovement
+
+		// journal keeps a record of kind per product code; nil for none.
+		journal Journal
+		kind    string
+	}
+
+	StockLevel struct {
+		Code    string    `json:"code"`
+		OnHand  int64     `json:"on_hand"`
+		Updated time.Time `json:"updated,omitempty"`
+	}
+
+	StockMovement struct {
+		Kind     string    `json:"kind"`
+		Quantity int64     `json:"quantity"`
+		Reason   string    `json:"reason,omitempty"`
+		OnHand   int64     `json:"on_hand"`
+		At       time.Time `json:"at"`
+	}
+
+	// stockRecord is how a product's stock is kept in the journal.
+	stockRecord struct {
+		Level     *StockLevel      `json:"level"`
+		Movements []*StockMovement `json:"movements"`
+	}
+)
+
+func NewStock(backorders bool) *Stock {
+	return &Stock{
+		Backorders: backorders,
+		levels:     make(map[string]*StockLevel),
+		movements:  make(map[string][]*StockMovement),
+	}
+}
+
+// OpenStock loads the stock ledger kept in j as records of kind and saves
+// every change to it from then on.
+func OpenStock(j Journal, kind string, backorders bool) (*Stock, error) {
+	s := NewStock(backorders)
+	s.journal, s.kind = j, kind
+	if j == nil {
+		return s, nil
+	}
+
+	records, err := j.Records(kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		var rec stockRecord
+		if err := json.Unmarshal(r.Value, &rec); err != nil {
+			return nil, fmt.Errorf("loading stock of %s: %w", r.Key, err)
+		}
+		if rec.Level != nil {
+			s.levels[r.Key] = rec.Level
+		}
+		s.movements[r.Key] = rec.Movements
+	}
+
+	return s, nil
+}
+
+// Level returns the quantity on hand for code; products never stocked have
+// zero on hand.
+func (s *Stock) Level(code string) StockLevel {
+	code = strings.ToUpper(code)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if level, ok := s.levels[code]; ok {
+		return *level
+	}
+
+	return StockLevel{Code: code}
+}
+
+// InStock reports whether any of code is on hand.
+func (s *Stock) InStock(code string) bool {
+	return s.Level(code).OnHand > 0
+}
+
+// Movements returns the most recent movements for code, newest first.
+func (s *Stock) Movements(code string) []*StockMovement {
+	code = strings.ToUpper(code)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	history := s.movements[code]
+	recent := make([]*StockMovement, len(history))
+	for i, movement := range history {
+		recent[len(history)-1-i] = movement
+	}
+
+	return recent
+}
+
+// Receive adds quantity units of code to stock.
+func (s *Stock) Receive(code string, quantity int64, reason string) (StockLevel, error) {
+	if quantity <= 0 {
+		return StockLevel{}, fmt.Errorf("%w: quantity must be positive", ErrInvalidMovement)
+	}
+
+	return s.apply(code, StockReceive, quantity, reason)
+}
+
+// Sell removes quantity units of code from stock.
+func (s *Stock) Sell(code string, quantity int64, reason string) (StockLevel, error) {
+	if quantity <= 0 {
+		return StockLevel{}, fmt.Errorf("%w: quantity must be positive", ErrInvalidMovement)
+	}
+
+	return s.apply(code, StockSell, -quantity, reason)
+}
+
+// Adjust corrects the stock of code by delta, e.g. after a count or for
+// spoilage. A reason is required.
+func (s *Stock) Adjust(code string, delta int64, reason string) (StockLevel, error) {
+	if delta == 0 {
+		return StockLevel{}, fmt.Errorf("%w: quantity must not be zero", ErrInvalidMovement)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return StockLevel{}, fmt.Errorf("%w: reason required for adjustments", ErrInvalidMovement)
+	}
+
+	return s.apply(code, StockAdjust, delta, reason)
+}
+
+// Remove forgets all stock and history for code.
+func (s *Stock) Remove(code string) error {
+	code = strings.ToUpper(code)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := save(s.journal, deleted(s.kind, code)); err != nil {
+		return err
+	}
+	delete(s.levels, code)
+	delete(s.movements, code)
+
+	return nil
+}
+
+func (s *Stock) apply(code, kind string, delta int64, reason string) (StockLevel, error) {
+	if code == "" {
+		return StockLevel{}, errors.New("invalid product code")
+	}
+	code = strings.ToUpper(code)
+
+	s.Lock()
+	defer s.Unlock()
+
+	level := StockLevel{Code: code}
+	if current, ok := s.levels[code]; ok {
+		level = *current
+	}
+
+	onHand := level.OnHand + delta
+	switch {
+	case (delta > 0 && onHand < level.OnHand) || (delta < 0 && onHand > level.OnHand):
+		return level, fmt.Errorf("%w: quantity is out of range", ErrInvalidMovement)
+	case onHand < 0 && delta < 0 && !s.Backorders:
+		return level, fmt.Errorf("%w: %d on hand", ErrInsufficientStock, level.OnHand)
+	}
+
+	now := time.Now()
+	previous := level
+	level.OnHand = onHand
+	level.Updated = now
+
+	history := append(append([]*StockMovement(nil), s.movements[code]...), &StockMovement{
+		Kind:     kind,
+		Quantity: delta,
+		Reason:   strings.TrimSpace(reason),
+		OnHand:   onHand,
+		At:       now,
+	})
+	if len(history) > _maxMovements {
+		history = history[len(history)-_maxMovements:]
+	}
+
+	if s.journal != nil {
+		rec, err := newRecord(s.kind, code, &stockRecord{Level: &level, Movements: history})
+		if err == nil {
+			err = save(s.journal, rec)
+		}
+		if err != nil {
+			return previous, err
+		}
+	}
+
+	s.levels[code] = &level
+	s.movements[code] = history
+
+	return level, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestStock(t *testing.T) {
+	s := NewStock(false)
+	code := "A12T-4GH7-QPL9-3N4M"
+
+	if _, err := s.Receive(code, 10, "delivery"); err != nil {
+		t.Fatalf("failed to receive stock [ERR: %s]", err)
+	}
+	if _, err := s.Sell(code, 3, ""); err != nil {
+		t.Fatalf("failed to sell stock [ERR: %s]", err)
+	}
+	level, err := s.Adjust(code, -2, "spoiled")
+	if err != nil {
+		t.Fatalf("failed to adjust stock [ERR: %s]", err)
+	}
+	if level.OnHand != 5 {
+		t.Errorf("wanted 5 on hand but got %d", level.OnHand)
+	}
+
+	if _, err := s.Sell(code, 6, ""); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("wanted ErrInsufficientStock overselling but got %v", err)
+	}
+	if got := s.Level(code).OnHand; got != 5 {
+		t.Errorf("refused sale changed stock to %d", got)
+	}
+
+	var invalidTable = []func() (StockLevel, error){
+		func() (StockLevel, error) { return s.Receive(code, 0, "") },
+		func() (StockLevel, error) { return s.Sell(code, -1, "") },
+		func() (StockLevel, error) { return s.Adjust(code, 1, " ") },
+	}
+	for i, op := range invalidTable {
+		if _, err := op(); !errors.Is(err, ErrInvalidMovement) {
+			t.Errorf("case %d: wanted ErrInvalidMovement but got %v", i, err)
+		}
+	}
+
+	movements := s.Movements(code)
+	if len(movements) != 3 || movements[0].Kind != StockAdjust || movements[0].Reason != "spoiled" {
+		t.Errorf("unexpected movement history %+v", movements)
+	}
+}
+
+func TestStockBackorders(t *testing.T) {
+	s := NewStock(true)
+
+	level, err := s.Sell("E5T6-9UI3-TH15-QR88", 2, "")
+	if err != nil {
+		t.Fatalf("failed to back-order [ERR: %s]", err)
+	}
+	if level.OnHand != -2 || s.InStock("E5T6-9UI3-TH15-QR88") {
+		t.Errorf("wanted -2 on hand and out of stock but got %d", level.OnHand)
+	}
+}
+
+func TestStockConcurrentSales(t *testing.T) {
+	s := NewStock(false)
+	code := "YRT6-72AS-K736-L4AR"
+	s.Receive(code, 50, "")
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sold int
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Sell(code, 1, ""); err == nil {
+				mu.Lock()
+				sold++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sold != 50 || s.Level(code).OnHand != 0 {
+		t.Errorf("wanted 50 sold leaving 0 on hand but sold %d leaving %d", sold, s.Level(code).OnHand)
+	}
+}
+
+func TestStockOverflow(t *testing.T) {
+	s := NewStock(true)
+	code := "A12T-4GH7-QPL9-3N4M"
+	s.Receive(code, math.MaxInt64-1, "")
+
+	if _, err := s.Receive(code, 2, ""); !errors.Is(err, ErrInvalidMovement) {
+		t.Errorf("wanted ErrInvalidMovement overflowing but got %v", err)
+	}
+
+	s.Adjust(code, math.MinInt64+1, "recount")
+	s.Adjust(code, -1, "recount")
+	if _, err := s.Sell(code, math.MaxInt64, ""); !errors.Is(err, ErrInvalidMovement) {
+		t.Errorf("wanted ErrInvalidMovement underflowing but got %v", err)
+	}
+	if got := s.Level(code).OnHand; got != -2 {
+		t.Errorf("refused movements changed stock to %d", got)
+	}
+}
+
+func TestOpenStock(t *testing.T) {
+	for driver, open := range testJournals(t) {
+		s, err := OpenStock(open(), _stockKind, false)
+		if err != nil {
+			t.Fatalf("%s: failed to open stock [ERR: %s]", driver, err)
+		}
+		s.Receive("A12T-4GH7-QPL9-3N4M", 10, "delivery")
+		s.Sell("A12T-4GH7-QPL9-3N4M", 4, "")
+		s.Receive("E5T6-9UI3-TH15-QR88", 3, "")
+		s.Remove("E5T6-9UI3-TH15-QR88")
+
+		if s, err = OpenStock(open(), _stockKind, false); err != nil {
+			t.Fatalf("%s: failed to reopen stock [ERR: %s]", driver, err)
+		}
+		if got := s.Level("A12T-4GH7-QPL9-3N4M").OnHand; got != 6 {
+			t.Errorf("%s: wanted 6 on hand after reopening but got %d", driver, got)
+		}
+		if got := s.Movements("A12T-4GH7-QPL9-3N4M"); len(got) != 2 || got[1].Reason != "delivery" {
+			t.Errorf("%s: wanted the movements kept but got %+v", driver, got)
+		}
+		if s.InStock("E5T6-9UI3-TH15-QR88") {
+			t.Errorf("%s: wanted removed stock gone after reopening", driver)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"grocery/config"
+	"grocery/models"
+	"grocery/shared"
+)
+
+var (
+	ErrNotFound       = errors.New("product not found")
+	ErrInvalidProduct = errors.New("invalid product")
+	// ErrVersionMismatch is returned by conditional writes when the stored
+	// product has moved on from the version the caller expected.
+	ErrVersionMismatch = errors.New("product version mismatch")
+
+	drivers = map[string]func() (Store, error){}
+)
+
+const (
+	_maxNameLen        = 128
+	_maxDescriptionLen = 2048
+)
+
+type (
+	// FieldError is a validation failure for a single product field.
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+
+		// err is what the failure unwraps to; nil means ErrInvalidProduct.
+		err error
+	}
+
+	// Store is implemented by every storage backend the API can run against.
+	//
+	// Update, Del and Restore take the version the caller last saw; when it is
+	// non-zero and no longer matches the stored product the write is refused
+	// with ErrVersionMismatch. Zero writes unconditionally.
+	Store interface {
+		Search(keyword string) []*Match
+		Suggest(prefix string, limit int) []string
+		Get(code string) *models.Product
+		List() []*models.Product
+		// Each calls fn with the products List would return, in the same
+		// order, without holding them all in memory at once. It stops at
+		// and returns the first error fn returns.
+		Each(fn func(*models.Product) error) error
+		Put(items ...*models.Product) ([]*models.Product, []error)
+		Update(code string, item *models.Product, version int64) (*models.Product, error)
+		// Del moves a product to the trash, from where Restore brings it
+		// back until Purge removes it for good. Trashed products are left
+		// out of Search, Get and List.
+		Del(code string, version int64) error
+		Trash() []*models.Product
+		Restore(code string, version int64) (*models.Product, error)
+		// Purge permanently removes the products trashed before the given
+		// time and returns their codes.
+		Purge(before time.Time) ([]string, error)
+		// Batch applies a mix of creates, updates and deletes atomically:
+		// all of them take effect or, if any fails, none do.
+		Batch(ops ...*BatchOp) ([]*BatchResult, error)
+	}
+)
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Unwrap(), e.Field, e.Message)
+}
+
+func (e *FieldError) Unwrap() error {
+	if e.err == nil {
+		return ErrInvalidProduct
+	}
+
+	return e.err
+}
+
+// Register makes a storage driver available to Open under the given name.
+func Register(name string, open func() (Store, error)) {
+	if open == nil {
+		panic("database: Register open func is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("database: Register called twice for driver " + name)
+	}
+
+	drivers[name] = open
+}
+
+// Drivers returns the sorted names of the registered storage drivers.
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Open opens the storage backend registered under driver.
+func Open(driver string) (Store, error) {
+	open, ok := drivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	return open()
+}
+
+// validate checks that a product is fit to be stored and normalizes its
+// fields. The first problem found is returned as a *FieldError.
+func validate(item *models.Product) error {
+	if item == nil {
+		return fmt.Errorf("%w: product required", ErrInvalidProduct)
+	}
+
+	item.Name = strings.TrimSpace(item.Name)
+	item.SKU = strings.TrimSpace(item.SKU)
+	item.Barcode = strings.TrimSpace(item.Barcode)
+	item.Brand = strings.TrimSpace(item.Brand)
+	item.Category = strings.ToLower(strings.TrimSpace(item.Category))
+	item.Description = strings.TrimSpace(item.Description)
+	item.Unit = strings.ToLower(strings.TrimSpace(item.Unit))
+	item.Price.Currency = strings.ToUpper(item.Price.Currency)
+	if item.Price.Currency == "" {
+		item.Price.Currency = config.CURRENCY
+	}
+	// products only reach the trash through Del
+	item.DeletedAt = nil
+
+	switch {
+	case item.Name == "":
+		return &FieldError{Field: "name", Message: "is required"}
+	case !shared.IsAlphaNum(item.Name):
+		return &FieldError{Field: "name", Message: fmt.Sprintf("%q is not alphanumeric", item.Name)}
+	case len(item.Name) > _maxNameLen:
+		return &FieldError{Field: "name", Message: fmt.Sprintf("is longer than %d characters", _maxNameLen)}
+	case !models.ValidCurrency(item.Price.Currency):
+		return &FieldError{Field: "price", Message: fmt.Sprintf("currency %q is not supported", item.Price.Currency)}
+	case item.Price.Amount < 0:
+		return &FieldError{Field: "price", Message: "must not be negative"}
+	case item.SKU != "" && !shared.IsAlphaNum(strings.ReplaceAll(item.SKU, "-", "")):
+		return &FieldError{Field: "sku", Message: fmt.Sprintf("%q may only contain letters, digits and dashes", item.SKU)}
+	case item.Barcode != "" && !models.ValidBarcode(item.Barcode):
+		return &FieldError{Field: "barcode", Message: fmt.Sprintf("%q is not a valid UPC-A or EAN-13 barcode", item.Barcode)}
+	case len(item.Brand) > _maxNameLen:
+		return &FieldError{Field: "brand", Message: fmt.Sprintf("is longer than %d characters", _maxNameLen)}
+	case len(item.Category) > _maxNameLen:
+		return &FieldError{Field: "category", Message: fmt.Sprintf("is longer than %d characters", _maxNameLen)}
+	case len(item.Description) > _maxDescriptionLen:
+		return &FieldError{Field: "description", Message: fmt.Sprintf("is longer than %d characters", _maxDescriptionLen)}
+	case item.Unit != "" && !models.ValidUnit(item.Unit):
+		return &FieldError{Field: "unit", Message: fmt.Sprintf("%q is not one of %s", item.Unit, strings.Join(models.Units, ", "))}
+	case item.Size < 0:
+		return &FieldError{Field: "size", Message: "must not be negative"}
+	case item.Size > 0 && item.Unit == "":
+		return &FieldError{Field: "unit", Message: "is required when size is set"}
+	}
+
+	return nil
+}
+
+// prepare validates every item before giving any of them a code and version,
+// so a rejected Put leaves the items it was given without codes.
+func prepare(items []*models.Product) []error {
+	for _, item := range items {
+		if err := validate(item); err != nil {
+			return []error{err}
+		}
+	}
+
+	for _, item := range items {
+		item.Code = shared.GenProductCode()
+		item.Version = 1
+	}
+
+	return nil
+}
+
+// trashed returns a copy of item moved to the trash as a new version.
+func trashed(item *models.Product) *models.Product {
+	deleted := *item
+	now := time.Now().UTC()
+	deleted.DeletedAt = &now
+	deleted.Version++
+
+	return &deleted
+}
+
+// restored returns a copy of a trashed item brought back as a new version.
+func restored(item *models.Product) *models.Product {
+	live := *item
+	live.DeletedAt = nil
+	live.Version++
+
+	return &live
+}
+
+// checkVersion reports whether a conditional write against existing may go
+// ahead. existing is nil when the product isn't stored.
+func checkVersion(existing *models.Product, version int64) error {
+	if version != 0 && (existing == nil || existing.Version != version) {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"grocery/config"
+	"grocery/models"
+)
+
+const (
+	// _storeKind and _storePriceKind are the journal kinds of the stores,
+	// keyed by ID, and of their price overrides, keyed by "id/CODE".
+	_storeKind      = "store"
+	_storePriceKind = "store_price"
+)
+
+var (
+	Stores *StoreDirectory
+
+	ErrStoreNotFound      = errors.New("store not found")
+	ErrStoreExists        = errors.New("store already exists")
+	ErrStorePriceNotFound = errors.New("store price not found")
+	ErrInvalidStore       = errors.New("invalid store")
+
+	_storeID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+)
+
+type (
+	// StoreDirectory holds the chain's stores along with each store's price
+	// overrides and stock. It is kept in memory and, when opened from a
+	// journal, saved to it before each change.
+	StoreDirectory struct {
+		sync.RWMutex
+
+		stores map[string]*models.Store
+		prices map[string]map[string]models.Money
+		stock  map[string]*Stock
+
+		// journal keeps the stores, prices and stock; nil for none.
+		journal Journal
+	}
+)
+
+func NewStoreDirectory() *StoreDirectory {
+	return &StoreDirectory{
+		stores: make(map[string]*models.Store),
+		prices: make(map[string]map[string]models.Money),
+		stock:  make(map[string]*Stock),
+	}
+}
+
+// OpenStoreDirectory loads the stores, their prices and their stock kept in
+// j and saves every change to it from then on.
+func OpenStoreDirectory(j Journal) (*StoreDirectory, error) {
+	d := NewStoreDirectory()
+	d.journal = j
+	if j == nil {
+		return d, nil
+	}
+
+	records, err := j.Records(_storeKind)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		var store models.Store
+		if err := json.Unmarshal(r.Value, &store); err != nil {
+			return nil, fmt.Errorf("loading store %s: %w", r.Key, err)
+		}
+		stock, err := OpenStock(j, storeStockKind(r.Key), config.BACKORDERS)
+		if err != nil {
+			return nil, fmt.Errorf("loading stock of store %s: %w", r.Key, err)
+		}
+		d.stores[r.Key] = &store
+		d.stock[r.Key] = stock
+	}
+
+	if records, err = j.Records(_storePriceKind); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		id, code, _ := strings.Cut(r.Key, "/")
+		if _, ok := d.stores[id]; !ok {
+			continue
+		}
+		var price models.Money
+		if err := json.Unmarshal(r.Value, &price); err != nil {
+			return nil, fmt.Errorf("loading price of %s at store %s: %w", code, id, err)
+		}
+		if d.prices[id] == nil {
+			d.prices[id] = make(map[string]models.Money)
+		}
+		d.prices[id][code] = price
+	}
+
+	return d, nil
+}
+
+// CreateStore adds a store, failing with ErrStoreExists if its ID is taken.
+func (d *StoreDirectory) CreateStore(store *models.Store) error {
+	if err := validStore(store); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.stores[store.ID]; ok {
+		return ErrStoreExists
+	}
+
+	return d.putStore(store)
+}
+
+// PutStore adds or renames a store. IDs are lowercase slugs such as
+// "downtown-2".
+func (d *StoreDirectory) PutStore(store *models.Store) error {
+	if err := validStore(store); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	return d.putStore(store)
+}
+
+// putStore saves and keeps a valid store, opening its stock the first time.
+// Callers hold the lock.
+func (d *StoreDirectory) putStore(store *models.Store) error {
+	rec, err := newRecord(_storeKind, store.ID, store)
+	if err != nil {
+		return err
+	}
+
+	stock, ok := d.stock[store.ID]
+	if !ok {
+		if stock, err = OpenStock(d.journal, storeStockKind(store.ID), config.BACKORDERS); err != nil {
+			return err
+		}
+	}
+	if err := save(d.journal, rec); err != nil {
+		return err
+	}
+
+	d.stores[store.ID] = store
+	d.stock[store.ID] = stock
+
+	return nil
+}
+
+func validStore(store *models.Store) error {
+	if store == nil {
+		return fmt.Errorf("%w: store required", ErrInvalidStore)
+	}
+
+	store.ID = strings.ToLower(strings.TrimSpace(store.ID))
+	store.Name = strings.TrimSpace(store.Name)
+	store.Address = strings.TrimSpace(store.Address)
+
+	switch {
+	case !_storeID.MatchString(store.ID):
+		return &FieldError{Field: "id", Message: fmt.Sprintf("%q must be a lowercase slug of letters, digits and dashes", store.ID), err: ErrInvalidStore}
+	case store.Name == "":
+		return &FieldError{Field: "name", Message: "is required", err: ErrInvalidStore}
+	}
+
+	return nil
+}
+
+func (d *StoreDirectory) GetStore(id string) *models.Store {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.stores[strings.ToLower(id)]
+}
+
+// ListStores returns every store ordered by ID.
+func (d *StoreDirectory) ListStores() []*models.Store {
+	d.RLock()
+	stores := make([]*models.Store, 0, len(d.stores))
+	for _, store := range d.stores {
+		stores = append(stores, store)
+	}
+	d.RUnlock()
+
+	sort.Slice(stores, func(i, j int) bool {
+		return stores[i].ID < stores[j].ID
+	})
+
+	return stores
+}
+
+// DelStore removes a store along with its prices and stock.
+func (d *StoreDirectory) DelStore(id string) error {
+	id = strings.ToLower(id)
+
+	d.Lock()
+	defer d.Unlock()
+
+	stock, ok := d.stock[id]
+	if !ok {
+		return ErrStoreNotFound
+	}
+
+	// holding the ledger keeps movements from saving it while it is deleted
+	stock.Lock()
+	defer stock.Unlock()
+
+	records := []*Record{deleted(_storeKind, id)}
+	for code := range d.prices[id] {
+		records = append(records, deleted(_storePriceKind, storePriceKey(id, code)))
+	}
+	for code := range stock.levels {
+		records = append(records, deleted(stock.kind, code))
+	}
+	if err := save(d.journal, records...); err != nil {
+		return err
+	}
+	stock.journal = nil
+
+	delete(d.stores, id)
+	delete(d.prices, id)
+	delete(d.stock, id)
+
+	return nil
+}
+
+// Stock returns the stock ledger of a store, or nil if there is no such
+// store.
+func (d *StoreDirectory) Stock(id string) *Stock {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.stock[strings.ToLower(id)]
+}
+
+// SetPrice overrides the price of a product at one store. currency is the
+// product's, which the price must be in.
+func (d *StoreDirectory) SetPrice(id, code, currency string, price models.Money) error {
+	id, code = strings.ToLower(id), strings.ToUpper(code)
+
+	if err := validProductPrice(&price, currency); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.stores[id]; !ok {
+		return ErrStoreNotFound
+	}
+	rec, err := newRecord(_storePriceKind, storePriceKey(id, code), price)
+	if err != nil {
+		return err
+	}
+	if err := save(d.journal, rec); err != nil {
+		return err
+	}
+	if d.prices[id] == nil {
+		d.prices[id] = make(map[string]models.Money)
+	}
+	d.prices[id][code] = price
+
+	return nil
+}
+
+// ClearPrice drops a store's price override so the base price applies again.
+func (d *StoreDirectory) ClearPrice(id, code string) error {
+	id, code = strings.ToLower(id), strings.ToUpper(code)
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.stores[id]; !ok {
+		return ErrStoreNotFound
+	}
+	if _, ok := d.prices[id][code]; !ok {
+		return ErrStorePriceNotFound
+	}
+	if err := save(d.journal, deleted(_storePriceKind, storePriceKey(id, code))); err != nil {
+		return err
+	}
+	delete(d.prices[id], code)
+
+	return nil
+}
+
+// RemoveProduct drops every store's price and stock for a product code.
+func (d *StoreDirectory) RemoveProduct(code string) error {
+	code = strings.ToUpper(code)
+
+	d.Lock()
+	defer d.Unlock()
+
+	var records []*Record
+	for id := range d.stores {
+		if _, ok := d.prices[id][code]; ok {
+			records = append(records, deleted(_storePriceKind, storePriceKey(id, code)))
+		}
+	}
+	if err := save(d.journal, records...); err != nil {
+		return err
+	}
+
+	var errs []error
+	for id := range d.stores {
+		delete(d.prices[id], code)
+		if err := d.stock[id].Remove(code); err != nil {
+			errs = append(errs, fmt.Errorf("store %s: %w", id, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// storeStockKind is the journal kind of a store's stock ledger.
+func storeStockKind(id string) string {
+	return _stockKind + "/" + id
+}
+
+func storePriceKey(id, code string) string {
+	return id + "/" + code
+}
+
+// Product returns product as seen from a store, falling back to the
+// product's base price when the store hasn't set its own.
+func (d *StoreDirectory) Product(id string, product *models.Product) (*models.StoreProduct, error) {
+	id = strings.ToLower(id)
+
+	d.RLock()
+	_, ok := d.stores[id]
+	price, override := d.prices[id][strings.ToUpper(product.Code)]
+	stock := d.stock[id]
+	d.RUnlock()
+
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+
+	view := *product
+	if override {
+		view.Price = price
+	}
+
+	return &models.StoreProduct{
+		Product:    &view,
+		StoreID:    id,
+		BasePrice:  product.Price,
+		StorePrice: override,
+		OnHand:     stock.Level(product.Code).OnHand,
+	}, nil
+}
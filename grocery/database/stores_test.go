@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"grocery/models"
+)
+
+func TestStoreDirectory(t *testing.T) {
+	d := NewStoreDirectory()
+
+	if err := d.PutStore(&models.Store{ID: "Downtown-2", Name: "Downtown"}); err != nil {
+		t.Fatalf("failed to create store [ERR: %s]", err)
+	}
+	if err := d.PutStore(&models.Store{ID: "bad id", Name: "Uptown"}); !errors.Is(err, ErrInvalidStore) {
+		t.Errorf("wanted ErrInvalidStore for bad id but got %v", err)
+	}
+	if store := d.GetStore("downtown-2"); store == nil || store.Name != "Downtown" {
+		t.Fatalf("failed to get store; got %s", store)
+	}
+
+	product := &models.Product{Code: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", Price: models.NewMoney(346, "USD")}
+
+	view, err := d.Product("downtown-2", product)
+	if err != nil {
+		t.Fatalf("failed to get store product [ERR: %s]", err)
+	}
+	if view.StorePrice || view.Price != product.Price {
+		t.Errorf("wanted base price without override but got %+v", view)
+	}
+
+	if err := d.SetPrice("downtown-2", product.Code, product.Price.Currency, models.NewMoney(299, "")); err != nil {
+		t.Fatalf("failed to set store price [ERR: %s]", err)
+	}
+	d.Stock("downtown-2").Receive(product.Code, 4, "")
+
+	view, _ = d.Product("downtown-2", product)
+	if !view.StorePrice || view.Price != models.NewMoney(299, "USD") || view.BasePrice != product.Price || view.OnHand != 4 {
+		t.Errorf("wanted store price 2.99 with 4 on hand but got %+v", view)
+	}
+	if product.Price.Amount != 346 {
+		t.Error("store price leaked into the base product")
+	}
+
+	var fieldErr *FieldError
+	if err := d.SetPrice("downtown-2", product.Code, product.Price.Currency, models.NewMoney(299, "EUR")); !errors.As(err, &fieldErr) || fieldErr.Field != "price" {
+		t.Errorf("wanted a price FieldError for a price in another currency but got %v", err)
+	}
+	if view, _ := d.Product("downtown-2", product); view.Price != models.NewMoney(299, "USD") {
+		t.Errorf("wanted the store price kept at 2.99 USD but got %s", view.Price)
+	}
+
+	if err := d.SetPrice("nowhere", product.Code, product.Price.Currency, models.NewMoney(1, "USD")); err != ErrStoreNotFound {
+		t.Errorf("wanted ErrStoreNotFound but got %v", err)
+	}
+	if _, err := d.Product("nowhere", product); err != ErrStoreNotFound {
+		t.Errorf("wanted ErrStoreNotFound but got %v", err)
+	}
+
+	if err := d.ClearPrice("downtown-2", product.Code); err != nil {
+		t.Errorf("failed to clear store price [ERR: %s]", err)
+	}
+	if err := d.ClearPrice("downtown-2", product.Code); err != ErrStorePriceNotFound {
+		t.Errorf("wanted ErrStorePriceNotFound clearing twice but got %v", err)
+	}
+	if view, _ := d.Product("downtown-2", product); view.StorePrice {
+		t.Error("store price still applied after clearing")
+	}
+
+	if err := d.CreateStore(&models.Store{ID: "downtown-2", Name: "Again"}); err != ErrStoreExists {
+		t.Errorf("wanted ErrStoreExists but got %v", err)
+	}
+
+	if err := d.DelStore("downtown-2"); err != nil {
+		t.Errorf("failed to delete store [ERR: %s]", err)
+	}
+	if d.GetStore("downtown-2") != nil || d.Stock("downtown-2") != nil {
+		t.Error("store still present after delete")
+	}
+	if err := d.DelStore("downtown-2"); err != ErrStoreNotFound {
+		t.Errorf("wanted ErrStoreNotFound deleting twice but got %v", err)
+	}
+}
+
+func TestOpenStoreDirectory(t *testing.T) {
+	code := "A12T-4GH7-QPL9-3N4M"
+
+	for driver, open := range testJournals(t) {
+		d, err := OpenStoreDirectory(open())
+		if err != nil {
+			t.Fatalf("%s: failed to open store directory [ERR: %s]", driver, err)
+		}
+		for _, id := range []string{"downtown", "uptown"} {
+			if err := d.CreateStore(&models.Store{ID: id, Name: id}); err != nil {
+				t.Fatalf("%s: failed to create store [ERR: %s]", driver, err)
+			}
+			d.SetPrice(id, code, "USD", models.NewMoney(299, "USD"))
+			d.Stock(id).Receive(code, 5, "")
+		}
+		if err := d.DelStore("uptown"); err != nil {
+			t.Fatalf("%s: failed to delete store [ERR: %s]", driver, err)
+		}
+		// movements after a delete don't bring the ledger back
+		d.Stock("downtown").Sell(code, 2, "")
+
+		if d, err = OpenStoreDirectory(open()); err != nil {
+			t.Fatalf("%s: failed to reopen store directory [ERR: %s]", driver, err)
+		}
+		if stores := d.ListStores(); len(stores) != 1 || stores[0].ID != "downtown" {
+			t.Fatalf("%s: wanted only downtown after reopening but got %+v", driver, stores)
+		}
+		view, _ := d.Product("downtown", &models.Product{Code: code, Price: models.NewMoney(346, "USD")})
+		if !view.StorePrice || view.Price.Amount != 299 || view.OnHand != 3 {
+			t.Errorf("%s: wanted the price and stock kept but got %+v", driver, view)
+		}
+
+		// a store made again under a deleted ID starts afresh
+		d.CreateStore(&models.Store{ID: "uptown", Name: "Uptown"})
+		if d, err = OpenStoreDirectory(open()); err != nil {
+			t.Fatalf("%s: failed to reopen store directory [ERR: %s]", driver, err)
+		}
+		view, _ = d.Product("uptown", &models.Product{Code: code, Price: models.NewMoney(346, "USD")})
+		if view == nil || view.StorePrice || view.OnHand != 0 {
+			t.Errorf("%s: wanted uptown without its old price and stock but got %+v", driver, view)
+		}
+	}
+}
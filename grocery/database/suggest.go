@@ -0,0 +1,225 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultSuggestLimit = 10
+	MaxSuggestLimit     = 50
+
+	// _suggestPrefixLen caps the length in runes of the prefixes names are
+	// filed under. Longer prefixes are looked up by their first
+	// _suggestPrefixLen runes and the names filtered.
+	_suggestPrefixLen = 16
+)
+
+type (
+	// suggestion is one way a name completes a prefix: the product name
+	// lowercased from the start of one of its words onward, so a prefix
+	// search finds names with any word starting with the prefix.
+	suggestion struct {
+		key  string
+		code string
+		name string
+		// word is true when key starts after the beginning of the name
+		word bool
+	}
+
+	// suggestEntry is a product name filed under a prefix.
+	suggestEntry struct {
+		code string
+		name string
+		// lower is name lowercased, the order entries are kept in
+		lower string
+	}
+
+	// suggestKey is a prefix a name is filed under and whether it is
+	// reached from a later word of the name.
+	suggestKey struct {
+		prefix string
+		word   bool
+	}
+
+	// suggestBucket holds the names completing one prefix, those starting
+	// with it and those with a later word starting with it, each kept in
+	// alphabetical order so a lookup reads no more than it returns.
+	suggestBucket struct {
+		starts, words []*suggestEntry
+	}
+)
+
+// Suggest returns up to limit distinct product names that start with prefix,
+// followed by names with a later word starting with it, each group in
+// alphabetical order. Matching ignores case and extra whitespace.
+func (x *Index) Suggest(prefix string, limit int) []string {
+	prefix = normalizeName(prefix)
+	if prefix == "" || limit <= 0 {
+		return nil
+	}
+
+	x.RLock()
+	defer x.RUnlock()
+
+	filed, long := suggestPrefix(prefix)
+	bucket := x.suggestions[filed]
+	if bucket == nil {
+		return nil
+	}
+
+	var (
+		names = make([]string, 0, limit)
+		seen  = make(map[string]bool)
+	)
+	for i, group := range [][]*suggestEntry{bucket.starts, bucket.words} {
+		for _, e := range group {
+			if len(names) == limit {
+				return names
+			}
+			if seen[e.lower] || (long && !completes(e.name, prefix, i > 0)) {
+				continue
+			}
+			seen[e.lower] = true
+			names = append(names, e.name)
+		}
+	}
+
+	return names
+}
+
+// addSuggestions files the name of the product with the given code under
+// the prefixes of each of its words. Callers hold the lock.
+func (x *Index) addSuggestions(code, name string) {
+	e := &suggestEntry{code: code, name: name, lower: strings.ToLower(name)}
+
+	for key := range suggestPrefixes(code, name) {
+		bucket := x.suggestions[key.prefix]
+		if bucket == nil {
+			bucket = new(suggestBucket)
+			x.suggestions[key.prefix] = bucket
+		}
+
+		group := bucket.group(key.word)
+		i := searchEntries(*group, e)
+		*group = append(*group, nil)
+		copy((*group)[i+1:], (*group)[i:])
+		(*group)[i] = e
+	}
+}
+
+// removeSuggestions drops the entries filed for the product with the given
+// code under name. Callers hold the lock.
+func (x *Index) removeSuggestions(code, name string) {
+	e := &suggestEntry{code: code, lower: strings.ToLower(name)}
+
+	for key := range suggestPrefixes(code, name) {
+		bucket := x.suggestions[key.prefix]
+		if bucket == nil {
+			continue
+		}
+
+		group := bucket.group(key.word)
+		if i := searchEntries(*group, e); i < len(*group) && (*group)[i].code == code {
+			*group = append((*group)[:i], (*group)[i+1:]...)
+		}
+		if len(bucket.starts) == 0 && len(bucket.words) == 0 {
+			delete(x.suggestions, key.prefix)
+		}
+	}
+}
+
+func (b *suggestBucket) group(word bool) *[]*suggestEntry {
+	if word {
+		return &b.words
+	}
+
+	return &b.starts
+}
+
+// searchEntries returns where e is, or belongs, in a sorted group.
+func searchEntries(group []*suggestEntry, e *suggestEntry) int {
+	return sort.Search(len(group), func(i int) bool {
+		g := group[i]
+		return g.lower > e.lower || (g.lower == e.lower && g.code >= e.code)
+	})
+}
+
+// suggestPrefixes returns the prefixes a product name is filed under, once
+// with the names starting with each and once with those having a later word
+// starting with it.
+func suggestPrefixes(code, name string) map[suggestKey]bool {
+	prefixes := make(map[suggestKey]bool)
+	for _, s := range suggestionsFor(code, name) {
+		var runes int
+		for i := range s.key {
+			if i > 0 {
+				prefixes[suggestKey{s.key[:i], s.word}] = true
+			}
+			if runes++; runes > _suggestPrefixLen {
+				break
+			}
+		}
+		if runes <= _suggestPrefixLen {
+			prefixes[suggestKey{s.key, s.word}] = true
+		}
+	}
+
+	return prefixes
+}
+
+// suggestPrefix returns the prefix a lookup is filed under and whether it
+// was cut short, leaving the names to be checked against the whole prefix.
+func suggestPrefix(prefix string) (string, bool) {
+	var runes int
+	for i := range prefix {
+		if runes == _suggestPrefixLen {
+			return prefix[:i], true
+		}
+		runes++
+	}
+
+	return prefix, false
+}
+
+// completes reports whether name starts with prefix or, for word, has a
+// later word starting with it.
+func completes(name, prefix string, word bool) bool {
+	for _, s := range suggestionsFor("", name) {
+		if s.word == word && strings.HasPrefix(s.key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func suggestionsFor(code, name string) (suggestions []suggestion) {
+	var (
+		normalized = normalizeName(name)
+		prev       rune
+	)
+
+	for i, r := range normalized {
+		if isWordRune(r) && (i == 0 || !isWordRune(prev)) {
+			suggestions = append(suggestions, suggestion{
+				key:  normalized[i:],
+				code: code,
+				name: name,
+				word: i > 0,
+			})
+		}
+		prev = r
+	}
+
+	return
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func normalizeName(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
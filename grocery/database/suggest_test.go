@@ -0,0 +1,69 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"grocery/models"
+)
+
+func TestSuggest(t *testing.T) {
+	x := NewIndex()
+	for _, item := range indexTestProducts() {
+		x.Add(item)
+	}
+
+	var suggestTable = []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"ap", 10, []string{"Apple Juice", "Gala Apple"}},
+		{"AP", 1, []string{"Apple Juice"}},
+		{"gala  a", 10, []string{"Gala Apple"}},
+		{"p", 10, []string{"Peaches", "Pineapple Chunks"}},
+		{"j", 10, []string{"Apple Juice", "Strawberry Jam"}},
+		{"pple", 10, nil},
+		{"  ", 10, nil},
+	}
+
+	for _, tc := range suggestTable {
+		got := x.Suggest(tc.prefix, tc.limit)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%q: wanted %v but got %v", tc.prefix, tc.want, got)
+		}
+	}
+
+	// renaming and removing keep suggestions in sync
+	x.Add(&models.Product{Code: "AAAA-0000-0000-0001", Name: "Granny Smith"})
+	x.Add(&models.Product{Code: "AAAA-0000-0000-0007", Name: "Gala Apple"})
+	x.Remove("aaaa-0000-0000-0002")
+
+	if got := x.Suggest("ap", 10); strings.Join(got, ",") != "Gala Apple" {
+		t.Errorf("wanted [Gala Apple] after changes but got %v", got)
+	}
+	if got := x.Suggest("gr", 10); strings.Join(got, ",") != "Granny Smith" {
+		t.Errorf("wanted [Granny Smith] after rename but got %v", got)
+	}
+
+	// prefixes longer than those filed are checked against the whole name
+	x.Add(&models.Product{Code: "AAAA-0000-0000-0008", Name: "Strawberry Jam Extra Fruit"})
+	x.Add(&models.Product{Code: "AAAA-0000-0000-0009", Name: "Strawberry Jam Extra Smooth"})
+
+	if got := x.Suggest("strawberry jam extra s", 10); strings.Join(got, ",") != "Strawberry Jam Extra Smooth" {
+		t.Errorf("wanted [Strawberry Jam Extra Smooth] for a long prefix but got %v", got)
+	}
+	if got := x.Suggest("jam extra fruit", 10); strings.Join(got, ",") != "Strawberry Jam Extra Fruit" {
+		t.Errorf("wanted [Strawberry Jam Extra Fruit] for a long word prefix but got %v", got)
+	}
+
+	for _, item := range indexTestProducts() {
+		x.Remove(item.Code)
+	}
+	for _, code := range []string{"AAAA-0000-0000-0001", "AAAA-0000-0000-0007", "AAAA-0000-0000-0008", "AAAA-0000-0000-0009"} {
+		x.Remove(code)
+	}
+	if len(x.suggestions) != 0 {
+		t.Errorf("wanted no prefixes left after removing every product but got %d", len(x.suggestions))
+	}
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// SweepTrash purges the products that have been in store's trash for longer
+// than retention, along with their stock, store prices and scheduled
+// prices.
+func SweepTrash(store Store, retention time.Duration) ([]string, error) {
+	codes, err := store.Purge(time.Now().Add(-retention))
+
+	for _, code := range codes {
+		if Inventory != nil {
+			if err := Inventory.Remove(code); err != nil {
+				log.Printf("removing stock of purged product %s [ERR: %s]", code, err)
+			}
+		}
+		if Stores != nil {
+			if err := Stores.RemoveProduct(code); err != nil {
+				log.Printf("removing store prices and stock of purged product %s [ERR: %s]", code, err)
+			}
+		}
+		if Prices != nil {
+			if err := Prices.RemoveProduct(code); err != nil {
+				log.Printf("removing scheduled prices of purged product %s [ERR: %s]", code, err)
+			}
+		}
+	}
+
+	return codes, err
+}
+
+// RunSweeper calls SweepTrash every interval until done is closed.
+func RunSweeper(store Store, retention, interval time.Duration, done <-chan int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			codes, err := SweepTrash(store, retention)
+			if err != nil {
+				log.Printf("sweeping trash [ERR: %s]", err)
+			}
+			if len(codes) > 0 {
+				log.Printf("purged %d products from the trash", len(codes))
+			}
+		}
+	}
+}
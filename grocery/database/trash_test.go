@@ -0,0 +1,135 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"grocery/models"
+)
+
+func TestTrash(t *testing.T) {
+	for name, store := range testStores(t) {
+		items, errs := store.Put(
+			&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")},
+			&models.Product{Name: "Syrup", Price: models.NewMoney(625, "USD")},
+		)
+		if len(errs) > 0 {
+			t.Fatalf("%s: errors when creating product(s) [ERR: %s]", name, errs)
+		}
+		code := items[0].Code
+
+		if err := store.Del(code, 0); err != nil {
+			t.Fatalf("%s: failed to delete product [ERR: %s]", name, err)
+		}
+		if store.Get(code) != nil || len(store.List()) != 1 || len(store.Search("waffles")) != 0 || len(store.Suggest("waf", 10)) != 0 {
+			t.Errorf("%s: wanted the deleted product hidden from Get, List, Search and Suggest", name)
+		}
+		if err := store.Del(code, 0); err != ErrNotFound {
+			t.Errorf("%s: wanted ErrNotFound deleting a trashed product but got %v", name, err)
+		}
+		if err := store.Del("this-isnt-real-code", 0); err != ErrNotFound {
+			t.Errorf("%s: wanted ErrNotFound deleting a missing product but got %v", name, err)
+		}
+
+		trash := store.Trash()
+		if len(trash) != 1 || trash[0].Code != code || trash[0].DeletedAt == nil || trash[0].Version != 2 {
+			t.Fatalf("%s: wanted the product in the trash at version 2 but got %v", name, trash)
+		}
+
+		if _, err := store.Restore(code, 1); err != ErrVersionMismatch {
+			t.Errorf("%s: wanted ErrVersionMismatch restoring a stale version but got %v", name, err)
+		}
+		restored, err := store.Restore(code, 2)
+		if err != nil {
+			t.Fatalf("%s: failed to restore product [ERR: %s]", name, err)
+		}
+		if restored.DeletedAt != nil || restored.Version != 3 {
+			t.Errorf("%s: wanted a live product at version 3 but got %v", name, restored)
+		}
+		if got := store.Get(code); got == nil || len(store.Search("waffles")) != 1 || len(store.Trash()) != 0 {
+			t.Errorf("%s: wanted the restored product back in the catalog", name)
+		}
+		if _, err := store.Restore(code, 0); err != ErrNotFound {
+			t.Errorf("%s: wanted ErrNotFound restoring a live product but got %v", name, err)
+		}
+
+		if err := store.Del(code, 3); err != nil {
+			t.Fatalf("%s: failed to delete product [ERR: %s]", name, err)
+		}
+		if codes, err := store.Purge(time.Now().Add(-time.Hour)); err != nil || len(codes) != 0 {
+			t.Errorf("%s: wanted nothing purged before the deletion but got %v [ERR: %v]", name, codes, err)
+		}
+		codes, err := store.Purge(time.Now().Add(time.Second))
+		if err != nil || len(codes) != 1 || codes[0] != code {
+			t.Errorf("%s: wanted %s purged but got %v [ERR: %v]", name, code, codes, err)
+		}
+		if len(store.Trash()) != 0 || len(store.List()) != 1 {
+			t.Errorf("%s: wanted the trash empty and the live product kept after purging", name)
+		}
+		if _, err := store.Restore(code, 0); err != ErrNotFound {
+			t.Errorf("%s: wanted ErrNotFound restoring a purged product but got %v", name, err)
+		}
+	}
+}
+
+func TestTrashReplay(t *testing.T) {
+	dir := t.TempDir()
+	f := openTestFileStore(t, dir)
+
+	items, errs := f.Put(
+		&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")},
+		&models.Product{Name: "Syrup", Price: models.NewMoney(625, "USD")},
+	)
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	if err := f.Del(items[0].Code, 0); err != nil {
+		t.Fatalf("failed to delete product [ERR: %s]", err)
+	}
+
+	// once from the log alone, then from a snapshot
+	f.wal.Close()
+	f = openTestFileStore(t, dir)
+	if trash := f.Trash(); len(trash) != 1 || trash[0].Code != items[0].Code || len(f.List()) != 1 {
+		t.Fatalf("wanted the trash replayed from the log but got %v", trash)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to close file store [ERR: %s]", err)
+	}
+
+	f = openTestFileStore(t, dir)
+	defer f.Close()
+	if trash := f.Trash(); len(trash) != 1 || trash[0].Code != items[0].Code || len(f.List()) != 1 {
+		t.Errorf("wanted the trash loaded from the snapshot but got %v", trash)
+	}
+}
+
+func TestSweepTrash(t *testing.T) {
+	db := NewDatabase()
+
+	items, errs := db.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD")})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	db.Del(items[0].Code, 0)
+
+	if codes, _ := SweepTrash(db, time.Hour); len(codes) != 0 {
+		t.Errorf("wanted nothing swept inside the retention window but got %v", codes)
+	}
+	if codes, _ := SweepTrash(db, -time.Second); len(codes) != 1 {
+		t.Errorf("wanted the product swept after the retention window but got %v", codes)
+	}
+}
+
+func TestPutIgnoresDeletedAt(t *testing.T) {
+	db := NewDatabase()
+
+	deletedAt := time.Now()
+	items, errs := db.Put(&models.Product{Name: "Waffles", Price: models.NewMoney(450, "USD"), DeletedAt: &deletedAt})
+	if len(errs) > 0 {
+		t.Fatalf("errors when creating product(s) [ERR: %s]", errs)
+	}
+	if db.Get(items[0].Code) == nil || len(db.Trash()) != 0 {
+		t.Error("wanted a product put with deleted_at to be live")
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+// ValidBarcode reports whether code is a well-formed UPC-A (12 digit) or
+// EAN-13 barcode with a correct check digit.
+func ValidBarcode(code string) bool {
+	if len(code) != 12 && len(code) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		c := code[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+
+		digit := int(c - '0')
+		// weights alternate 1, 3, 1, ... from the check digit leftwards
+		if (len(code)-1-i)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
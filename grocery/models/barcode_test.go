@@ -0,0 +1,21 @@
+package models
+
+import "testing"
+
+func TestValidBarcode(t *testing.T) {
+	var barcodeTable = map[string]bool{
+		"036000291452":  true,  // UPC-A
+		"4006381333931": true,  // EAN-13
+		"036000291453":  false, // bad check digit
+		"4006381333932": false,
+		"03600029145":   false, // too short
+		"03600029145A":  false,
+		"":              false,
+	}
+
+	for code, want := range barcodeTable {
+		if got := ValidBarcode(code); got != want {
+			t.Errorf("ValidBarcode(%q) = %v; wanted %v", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"grocery/config"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+
+	// decimalAmount is the only form an amount is read from: no fractions
+	// such as 1/3 and no exponents such as 2.5e1.
+	decimalAmount = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+	// minorUnits is the number of decimal places each supported ISO 4217
+	// currency is kept to.
+	minorUnits = map[string]int{
+		"AUD": 2, "BHD": 3, "CAD": 2, "CHF": 2, "CNY": 2, "EUR": 2, "GBP": 2,
+		"INR": 2, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2, "NZD": 2, "USD": 2,
+	}
+)
+
+type (
+	// Money is an exact amount of a currency, held as an integer count of
+	// the currency's minor unit (cents for USD) so sums never drift.
+	//
+	// Rounding policy: amounts given with more decimal places than the
+	// currency has are rounded to the nearest minor unit, with halves
+	// rounded away from zero (2.345 USD is 2.35, -2.345 USD is -2.35). That
+	// is the only place rounding happens; arithmetic on Money is exact.
+	Money struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+)
+
+// NewMoney returns amount minor units of currency.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal amount such as "3.46" in the given currency,
+// which defaults to config.CURRENCY.
+func ParseMoney(s, currency string) (Money, error) {
+	if currency == "" {
+		currency = config.CURRENCY
+	}
+	currency = strings.ToUpper(currency)
+
+	places, ok := minorUnits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	s = strings.TrimSpace(s)
+	if !decimalAmount.MatchString(s) {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)))
+
+	amount, err := roundHalfAway(r)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// ValidCurrency reports whether code is a supported ISO 4217 currency.
+func ValidCurrency(code string) bool {
+	_, ok := minorUnits[code]
+	return ok
+}
+
+// Add returns m + o; both must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m multiplied by n.
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// Less orders amounts by currency and then by amount.
+func (m Money) Less(o Money) bool {
+	if m.Currency != o.Currency {
+		return m.Currency < o.Currency
+	}
+
+	return m.Amount < o.Amount
+}
+
+// String formats m as a decimal amount, e.g. "3.46".
+func (m Money) String() string {
+	places := minorUnits[m.Currency]
+	if places == 0 {
+		return fmt.Sprintf("%d", m.Amount)
+	}
+
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	scale := int64(1)
+	for i := 0; i < places; i++ {
+		scale *= 10
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, places, amount%scale)
+}
+
+// UnmarshalJSON accepts a decimal string ("3.46"), a bare number (3.46) or
+// the object form {"amount":346,"currency":"USD"}. The first two are in
+// config.CURRENCY.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		return nil
+	case len(b) > 0 && b[0] == '{':
+		var obj struct {
+			Amount   *int64 `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return err
+		}
+		if obj.Amount == nil {
+			return errors.New("money amount required")
+		}
+
+		*m = NewMoney(*obj.Amount, obj.Currency)
+		if m.Currency == "" {
+			m.Currency = config.CURRENCY
+		}
+		return nil
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+
+		parsed, err := ParseMoney(s, "")
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	default:
+		// parse the literal itself rather than going through float64
+		parsed, err := ParseMoney(string(b), "")
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+}
+
+// roundHalfAway rounds r to the nearest integer, halves away from zero.
+func roundHalfAway(r *big.Rat) (int64, error) {
+	num, den := new(big.Int).Set(r.Num()), r.Denom()
+
+	neg := num.Sign() < 0
+	num.Abs(num)
+
+	// (2*num + den) / (2*den) is floor(num/den + 1/2)
+	num.Mul(num, big.NewInt(2)).Add(num, den)
+	q := num.Quo(num, new(big.Int).Mul(den, big.NewInt(2)))
+	if neg {
+		q.Neg(q)
+	}
+
+	if !q.IsInt64() {
+		return 0, errors.New("amount out of range")
+	}
+
+	return q.Int64(), nil
+}
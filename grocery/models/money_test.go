@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	var moneyTable = map[string]Money{
+		`"3.46"`:                          {346, "USD"},
+		`3.46`:                            {346, "USD"},
+		`9.8111111`:                       {981, "USD"},
+		`"2.345"`:                         {235, "USD"},
+		`"-2.345"`:                        {-235, "USD"},
+		`0.29`:                            {29, "USD"},
+		`{"amount":346,"currency":"usd"}`: {346, "USD"},
+		`{"amount":500,"currency":"JPY"}`: {500, "JPY"},
+		`{"amount":1234}`:                 {1234, "USD"},
+	}
+
+	for in, want := range moneyTable {
+		var got Money
+		if err := json.Unmarshal([]byte(in), &got); err != nil {
+			t.Errorf("failed to unmarshal %s [ERR: %s]", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("unmarshaling %s: wanted %+v but got %+v", in, want, got)
+		}
+	}
+
+	for _, in := range []string{`"abc"`, `{"currency":"USD"}`, `true`, `"1.5.2"`, `"1/3"`, `"2.5e1"`, `1e2`, `".5"`, `"+3"`} {
+		var got Money
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("expected error unmarshaling %s but got %+v", in, got)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	// adding ten cents a thousand times is exact, unlike float64
+	total := NewMoney(0, "USD")
+	for i := 0; i < 1000; i++ {
+		var err error
+		if total, err = total.Add(NewMoney(10, "USD")); err != nil {
+			t.Fatalf("failed to add [ERR: %s]", err)
+		}
+	}
+	if total.String() != "100.00" {
+		t.Errorf("wanted 100.00 but got %s", total)
+	}
+
+	if _, err := total.Add(NewMoney(1, "EUR")); err == nil {
+		t.Error("expected error adding different currencies")
+	}
+
+	var stringTable = map[Money]string{
+		{346, "USD"}:   "3.46",
+		{-5, "USD"}:    "-0.05",
+		{500, "JPY"}:   "500",
+		{12345, "KWD"}: "12.345",
+	}
+	for m, want := range stringTable {
+		if got := m.String(); got != want {
+			t.Errorf("wanted %+v to format as %s but got %s", m, want, got)
+		}
+	}
+
+	if got := NewMoney(299, "USD").Mul(3); got.Amount != 897 {
+		t.Errorf("wanted 897 but got %d", got.Amount)
+	}
+}
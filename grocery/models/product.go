@@ -1,17 +1,56 @@
 package models
 
 import (
+	"time"
+
 	"grocery/shared"
 )
 
+const (
+	UnitEach     = "each"
+	UnitPound    = "lb"
+	UnitKilogram = "kg"
+	UnitOunce    = "oz"
+)
+
+var (
+	// Units are the units of measure a product can be sold by.
+	Units = []string{UnitEach, UnitPound, UnitKilogram, UnitOunce}
+)
+
 type (
 	Product struct {
-		Code  string  `json:"code"`
-		Name  string  `json:"name"`
-		Price float64 `json:"price"`
+		Code  string `json:"code"`
+		Name  string `json:"name"`
+		Price Money  `json:"price"`
+		// SKU is the store's own stock keeping unit, distinct from Code.
+		SKU         string `json:"sku,omitempty"`
+		Barcode     string `json:"barcode,omitempty"`
+		Brand       string `json:"brand,omitempty"`
+		Category    string `json:"category,omitempty"`
+		Description string `json:"description,omitempty"`
+		// Unit and Size describe the package, e.g. 12 oz or 1 each.
+		Unit string  `json:"unit,omitempty"`
+		Size float64 `json:"size,omitempty"`
+		// Version is bumped by the database on every write and backs the
+		// API's ETags.
+		Version int64 `json:"version"`
+		// DeletedAt is set while the product is in the trash.
+		DeletedAt *time.Time `json:"deleted_at,omitempty"`
 	}
 )
 
 func (p *Product) String() string {
 	return shared.String(p)
 }
+
+// ValidUnit reports whether unit is one of Units.
+func ValidUnit(unit string) bool {
+	for _, u := range Units {
+		if u == unit {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"grocery/shared"
+)
+
+type (
+	// Store is one shop in the chain. Products are shared across the chain;
+	// prices and stock can be set per store.
+	Store struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Address string `json:"address,omitempty"`
+	}
+
+	// StoreProduct is a product as seen from one store: its price there and
+	// how many are on hand.
+	StoreProduct struct {
+		*Product
+
+		StoreID   string `json:"store_id"`
+		BasePrice Money  `json:"base_price"`
+		// StorePrice is true when Price is the store's own price rather
+		// than the product's base price.
+		StorePrice bool  `json:"store_price"`
+		OnHand     int64 `json:"on_hand"`
+	}
+)
+
+func (s *Store) String() string {
+	return shared.String(s)
+}
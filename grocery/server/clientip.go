@@ -0,0 +1,152 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	// _trustedProxies are the networks, from config.TRUSTEDPROXIES, whose
+	// forwarding headers are believed.
+	_trustedProxies []netip.Prefix
+	// _proxyHeader is the forwarding header, from config.PROXYHEADER, the
+	// trusted proxies write.
+	_proxyHeader string
+)
+
+// parseProxyHeader reads the name of the forwarding header the trusted
+// proxies write, Forwarded or X-Forwarded-For.
+func parseProxyHeader(name string) (string, error) {
+	switch name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name {
+	case "Forwarded", "X-Forwarded-For":
+		return name, nil
+	}
+
+	return "", fmt.Errorf("proxy header %q is neither Forwarded nor X-Forwarded-For", name)
+}
+
+// parseTrustedProxies reads CIDRs, or single addresses, of trusted proxies.
+func parseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// clientIP is the address a request came from. When it came through trusted
+// proxies that is the last address before them in proxyHeader, the
+// Forwarded or X-Forwarded-For header they write, read right to left since
+// only the proxies' own entries can be believed. The other header is
+// ignored, as only the client could have set it. Otherwise it is the peer's
+// address.
+func clientIP(remoteAddr string, header http.Header, trusted []netip.Prefix, proxyHeader string) string {
+	peer, ok := parseHost(remoteAddr)
+	if !ok {
+		return remoteAddr
+	}
+	if !isTrusted(peer, trusted) {
+		return peer.String()
+	}
+
+	hops := forwardedForLegacy(header)
+	if proxyHeader == "Forwarded" {
+		hops = forwardedFor(header)
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHost(hops[i])
+		if !ok {
+			// an obfuscated or unknown hop hides everything before it
+			break
+		}
+
+		client = addr
+		if !isTrusted(addr, trusted) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// forwardedFor lists the for parameters of the Forwarded headers (RFC 7239),
+// nearest the client first.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// forwardedForLegacy lists the addresses of the X-Forwarded-For headers,
+// nearest the client first.
+func forwardedForLegacy(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+// parseHost reads an address with or without a port, IPv6 ones in brackets
+// when they have one, as they must in Forwarded.
+func parseHost(host string) (netip.Addr, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap().WithZone(""), true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies [ERR: %s]", err)
+	}
+
+	var clientIPTable = []struct {
+		name        string
+		remoteAddr  string
+		header      http.Header
+		want        string
+		proxyHeader string
+	}{
+		{"direct", "203.0.113.7:5123", nil, "203.0.113.7", "X-Forwarded-For"},
+		{"direct IPv6", "[2001:db8::7]:5123", nil, "2001:db8::7", "X-Forwarded-For"},
+		{"mapped IPv4", "[::ffff:203.0.113.7]:5123", nil, "203.0.113.7", "X-Forwarded-For"},
+		{"no port", "203.0.113.7", nil, "203.0.113.7", "X-Forwarded-For"},
+		{"spoofed", "203.0.113.7:5123", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7", "X-Forwarded-For"},
+		{"proxied", "10.1.2.3:80", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"proxied without header", "10.1.2.3:80", nil, "10.1.2.3", "X-Forwarded-For"},
+		{"client prepends", "10.1.2.3:80", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"proxy chain", "192.0.2.10:80", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.9.9.9"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"repeated headers", "10.1.2.3:80", http.Header{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1, 10.9.9.9"}}, "198.51.100.1", "X-Forwarded-For"},
+		{"untrusted single address", "192.0.2.11:80", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.11", "X-Forwarded-For"},
+		{"all trusted", "10.1.2.3:80", http.Header{"X-Forwarded-For": {"10.4.4.4"}}, "10.4.4.4", "X-Forwarded-For"},
+		{"garbage", "10.1.2.3:80", http.Header{"X-Forwarded-For": {"not-an-ip"}}, "10.1.2.3", "X-Forwarded-For"},
+		{"forwarded", "10.1.2.3:80", http.Header{"Forwarded": {"for=198.51.100.1;proto=https"}}, "198.51.100.1", "Forwarded"},
+		{"forwarded IPv6", "[2001:db8:ffff::1]:80", http.Header{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17", "Forwarded"},
+		{"forwarded chain", "10.1.2.3:80", http.Header{"Forwarded": {"for=1.1.1.1, For=198.51.100.1;by=10.1.2.3", "for=10.9.9.9"}}, "198.51.100.1", "Forwarded"},
+		{"forwarded obfuscated", "10.1.2.3:80", http.Header{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.9.9.9"}}, "10.9.9.9", "Forwarded"},
+		{"forwarded unknown", "10.1.2.3:80", http.Header{"Forwarded": {"for=unknown"}}, "10.1.2.3", "Forwarded"},
+		{"legacy ignored", "10.1.2.3:80", http.Header{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.1", "Forwarded"},
+		{"forwarded ignored", "10.1.2.3:80", http.Header{"Forwarded": {"for=1.1.1.1"}, "X-Forwarded-For": {"198.51.100.2"}}, "198.51.100.2", "X-Forwarded-For"},
+		{"forwarded ignored without legacy", "10.1.2.3:80", http.Header{"Forwarded": {"for=1.1.1.1"}}, "10.1.2.3", "X-Forwarded-For"},
+	}
+
+	for _, tc := range clientIPTable {
+		if got := clientIP(tc.remoteAddr, tc.header, trusted, tc.proxyHeader); got != tc.want {
+			t.Errorf("%s: wanted %s but got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	for _, name := range []string{"forwarded", "x-forwarded-for", " X-Forwarded-For "} {
+		if _, err := parseProxyHeader(name); err != nil {
+			t.Errorf("wanted %q accepted [ERR: %s]", name, err)
+		}
+	}
+	for _, name := range []string{"", "X-Real-IP"} {
+		if _, err := parseProxyHeader(name); err == nil {
+			t.Errorf("wanted %q refused", name)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies([]string{"10.1.2.3/8", "::ffff:10.0.0.0/104", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies [ERR: %s]", err)
+	}
+
+	want := []string{"10.0.0.0/8", "10.0.0.0/8", "2001:db8::1/128"}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("%d: wanted %s but got %s", i, want[i], prefix)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "proxy.internal", ""} {
+		if _, err := parseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("wanted %q refused", bad)
+		}
+	}
+}
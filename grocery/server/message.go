@@ -10,6 +10,8 @@ type (
 		Code    int         `json:"code,omitempty"`
 		Message string      `json:"message,omitempty"`
 		Data    interface{} `json:"data,omitempty"`
+		// Next is the cursor for the following page of a paginated listing.
+		Next string `json:"next,omitempty"`
 	}
 )
 
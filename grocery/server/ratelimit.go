@@ -0,0 +1,192 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grocery/cache"
+	"grocery/config"
+
+	"github.com/gocraft/web"
+)
+
+var rateLimiter = newLimiter(config.RATELIMITCLIENTS)
+
+type (
+	// limiter hands out requests from token buckets, one per client and
+	// route policy, kept until they have filled back up and are no
+	// different from new ones.
+	limiter struct {
+		sync.Mutex
+
+		buckets *cache.Cache
+	}
+
+	bucket struct {
+		tokens  float64
+		updated time.Time
+		policy  config.RatePolicy
+	}
+)
+
+func newLimiter(maxClients int) *limiter {
+	return &limiter{buckets: cache.NewBoundedCache(maxClients, 0)}
+}
+
+// RateLimitCache is where the rate limiter keeps its buckets.
+func RateLimitCache() *cache.Cache {
+	return rateLimiter.buckets
+}
+
+// LimitAddress takes a token from the bucket of the address a request came
+// from before it is authenticated, so requests with missing, invalid or
+// forged credentials are limited too.
+func (ctx *Context) LimitAddress(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if ctx.limit(rw, "addr:"+ctx.ClientIP, config.ADDRESSRATELIMIT) {
+		next(rw, req)
+	}
+}
+
+// RateLimit takes a token from the bucket of the client and route, refusing
+// the request when there are none left. Clients are told their limit and
+// what remains of it and, when refused, how long to wait.
+func (ctx *Context) RateLimit(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	route, policy := ctx.ratePolicy(req)
+	if ctx.limit(rw, ctx.client()+" "+route, policy) {
+		next(rw, req)
+	}
+}
+
+// limit takes a token from the bucket at key, responding and returning
+// false when it is empty. A policy without a rate doesn't limit.
+func (ctx *Context) limit(rw web.ResponseWriter, key string, policy config.RatePolicy) bool {
+	if policy.Rate <= 0 {
+		return true
+	}
+
+	remaining, wait, ok := rateLimiter.take(key, policy, time.Now())
+
+	rw.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Burst))
+	rw.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	if !ok {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		ctx.Respond(rw, http.StatusTooManyRequests, "Request rate limit exceeded")
+		return false
+	}
+
+	return true
+}
+
+// client identifies who a request is limited as: its API key, its token's
+// subject or, without either, its address.
+func (ctx *Context) client() string {
+	switch {
+	case ctx.Principal == nil:
+		return "ip:" + ctx.ClientIP
+	case ctx.Principal.KeyID != "":
+		return "key:" + ctx.Principal.KeyID
+	}
+
+	return "sub:" + ctx.Principal.Name
+}
+
+// ratePolicy is the policy for a request: that of the longest route in
+// config.ROUTERATELIMITS it matches or else the client's, from
+// config.KEYRATELIMITS or config.RATELIMIT. The route is empty for the
+// client's own policy.
+func (ctx *Context) ratePolicy(req *web.Request) (route string, policy config.RatePolicy) {
+	if route, policy, ok := matchRoute(config.ROUTERATELIMITS, req.Method, req.URL.Path); ok {
+		return route, policy
+	}
+
+	if ctx.Principal != nil {
+		for _, id := range []string{ctx.Principal.KeyID, ctx.Principal.Name} {
+			if p, ok := config.KEYRATELIMITS[id]; ok && id != "" {
+				return "", p
+			}
+		}
+	}
+
+	return "", config.RATELIMIT
+}
+
+// matchRoute finds the longest of routes, paths optionally after a method as
+// in "POST /products/import", that the request's method and path fall under.
+func matchRoute[V any](routes map[string]V, method, path string) (route string, value V, ok bool) {
+	var longest int
+	for r, v := range routes {
+		m, p, hasMethod := strings.Cut(r, " ")
+		if !hasMethod {
+			m, p = "", r
+		}
+		if m != "" && m != method {
+			continue
+		}
+		if !matchesPath(path, p) || len(p) < longest {
+			continue
+		}
+		// a method breaks ties, so the match is the same whatever the map order
+		if ok && len(p) == longest && m == "" {
+			continue
+		}
+
+		route, value, longest, ok = r, v, len(p), true
+	}
+
+	return
+}
+
+// matchesPath reports whether path is prefix or below it.
+func matchesPath(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// take removes a token from the bucket at key, refilled at the policy's
+// rate since it was last used, returning how many are left or, if it was
+// empty, how long until the next one.
+func (l *limiter) take(key string, policy config.RatePolicy, now time.Time) (remaining int, wait time.Duration, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	value, _ := l.buckets.Value(key)
+	b, found := value.(*bucket)
+	if !found {
+		b = &bucket{tokens: float64(policy.Burst), updated: now}
+	}
+	b.policy = policy
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		remaining, ok = int(b.tokens), true
+	} else {
+		wait = b.until(1)
+	}
+
+	// a second's grace so the bucket isn't dropped before it's quite full
+	l.buckets.Set(key, b, b.until(float64(policy.Burst))+time.Second)
+
+	return remaining, wait, ok
+}
+
+// until is how long the bucket takes to fill to tokens.
+func (b *bucket) until(tokens float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+
+	return time.Duration((tokens - b.tokens) / b.policy.Rate * float64(time.Second))
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.policy.Burst), b.tokens+elapsed*b.policy.Rate)
+		b.updated = now
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"grocery/auth"
+	"grocery/config"
+
+	"github.com/gocraft/web"
+)
+
+func TestTake(t *testing.T) {
+	l := newLimiter(2)
+	policy := config.RatePolicy{Rate: 2, Burst: 3}
+	start := time.Now()
+
+	var takeTable = []struct {
+		after     time.Duration
+		remaining int
+		wait      time.Duration
+		ok        bool
+	}{
+		{0, 2, 0, true},
+		{0, 1, 0, true},
+		{0, 0, 0, true},
+		{0, 0, 500 * time.Millisecond, false},
+		{250 * time.Millisecond, 0, 250 * time.Millisecond, false},
+		{500 * time.Millisecond, 0, 0, true},
+		// idling refills no more than the burst
+		{time.Hour, 2, 0, true},
+	}
+
+	for i, tc := range takeTable {
+		remaining, wait, ok := l.take("ip:192.0.2.1", policy, start.Add(tc.after))
+		if remaining != tc.remaining || wait != tc.wait || ok != tc.ok {
+			t.Errorf("%d: wanted %d remaining, a wait of %s and %v but got %d, %s and %v", i, tc.remaining, tc.wait, tc.ok, remaining, wait, ok)
+		}
+	}
+
+	if _, _, ok := l.take("ip:192.0.2.2", policy, start.Add(time.Hour)); !ok {
+		t.Error("wanted another client to have its own bucket")
+	}
+
+	// a third client makes the limiter forget the least recently seen
+	l.take("ip:192.0.2.3", policy, start.Add(time.Hour))
+	if remaining, _, _ := l.take("ip:192.0.2.1", policy, start.Add(time.Hour)); remaining != 2 {
+		t.Errorf("wanted a forgotten client to start with a full bucket but has %d remaining", remaining)
+	}
+	if stats := l.buckets.Stats(); stats.Items != 2 || stats.Evictions != 2 {
+		t.Errorf("wanted 2 buckets kept and 2 evicted but got %+v", stats)
+	}
+}
+
+func TestRatePolicy(t *testing.T) {
+	routes, keys := config.ROUTERATELIMITS, config.KEYRATELIMITS
+	defer func() { config.ROUTERATELIMITS, config.KEYRATELIMITS = routes, keys }()
+
+	var (
+		products = config.RatePolicy{Rate: 5, Burst: 5}
+		imports  = config.RatePolicy{Rate: 1, Burst: 1}
+		bulk     = config.RatePolicy{Rate: 100, Burst: 200}
+	)
+	config.ROUTERATELIMITS = map[string]config.RatePolicy{
+		"/products":             products,
+		"POST /products/import": imports,
+	}
+	config.KEYRATELIMITS = map[string]config.RatePolicy{"k1": bulk, "pricing": bulk}
+
+	var policyTable = []struct {
+		method    string
+		path      string
+		principal *auth.Principal
+		route     string
+		policy    config.RatePolicy
+	}{
+		{http.MethodGet, "/stores", nil, "", config.RATELIMIT},
+		{http.MethodGet, "/stores", &auth.Principal{Name: "till", KeyID: "k1"}, "", bulk},
+		{http.MethodGet, "/stores", &auth.Principal{Name: "pricing"}, "", bulk},
+		{http.MethodGet, "/stores", &auth.Principal{Name: "till", KeyID: "k2"}, "", config.RATELIMIT},
+		{http.MethodGet, "/products", nil, "/products", products},
+		{http.MethodGet, "/products/import", nil, "/products", products},
+		{http.MethodPost, "/products/import", &auth.Principal{Name: "till", KeyID: "k1"}, "POST /products/import", imports},
+		{http.MethodGet, "/productsx", nil, "", config.RATELIMIT},
+	}
+
+	for _, tc := range policyTable {
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		ctx := &Context{Principal: tc.principal}
+
+		route, policy := ctx.ratePolicy(&web.Request{Request: req})
+		if route != tc.route || policy != tc.policy {
+			t.Errorf("%s %s: wanted %q %+v but got %q %+v", tc.method, tc.path, tc.route, tc.policy, route, policy)
+		}
+	}
+}
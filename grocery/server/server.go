@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net"
@@ -13,7 +15,8 @@ import (
 	"strings"
 	"time"
 
-	"grocery/cache"
+	"grocery/auth"
+	"grocery/config"
 	logger "grocery/log"
 	"grocery/sec"
 	"grocery/shared"
@@ -23,12 +26,13 @@ import (
 
 var (
 	_maxConnections = 100
-	_memoryLimit    = 10 << 20
-	_maxRPS         = 15.0
 	_connChan       = make(chan int, _maxConnections)
+	// _adminPrefix is where the routes needing the admin role live.
+	_adminPrefix = "/admin"
+	// _auditScope is the scope a token needs to read the audit trail.
+	_auditScope = "audit:" + auth.ScopeRead
 
-	rateCache = cache.NewCache()
-	Router    *web.Router
+	Router *web.Router
 )
 
 type (
@@ -42,6 +46,12 @@ type (
 
 		ReqStartTime time.Time `json:"-"`
 		Body         []byte    `json:"-"`
+		// RequestID identifies the request in logs and the audit trail.
+		RequestID string `json:"-"`
+		// ClientIP is the address the request came from.
+		ClientIP string `json:"-"`
+		// Principal is who the request's API key belongs to, nil without one.
+		Principal *auth.Principal `json:"-"`
 	}
 )
 
@@ -53,20 +63,32 @@ func NewServer(port int) *Server {
 		),
 	}
 
+	var err error
+	if _trustedProxies, err = parseTrustedProxies(config.TRUSTEDPROXIES); err != nil {
+		log.Fatalf("parsing trusted proxies [ERR: %s]", err)
+	}
+	if _proxyHeader, err = parseProxyHeader(config.PROXYHEADER); err != nil {
+		log.Fatalf("parsing proxy header [ERR: %s]", err)
+	}
+
 	for i := 0; i < _maxConnections; i++ {
 		_connChan <- i
 	}
 
 	Router = web.New(Context{}).
-		Middleware((*Context).InitLogger).
 		Middleware((*Context).InitStartTime).
+		Middleware((*Context).InitRequestID).
+		Middleware((*Context).InitLogger).
+		Middleware((*Context).LimitConnections).
+		Middleware((*Context).LimitAddress).
+		Middleware((*Context).Authenticate).
 		Middleware((*Context).RateLimit).
 		NotFound((*Context).NotFound).
 		OptionsHandler((*Context).OptionsHandler)
 
 	s.Server = &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
-		Handler:           http.MaxBytesHandler(Router, int64(_memoryLimit)),
+		Handler:           limitBody(Router),
 		ReadHeaderTimeout: 2 * time.Minute,
 		IdleTimeout:       2 * time.Minute,
 		WriteTimeout:      2 * time.Minute,
@@ -76,24 +98,54 @@ func NewServer(port int) *Server {
 	return s
 }
 
+// limitBody caps request bodies at config.MAXBODYBYTES, or the limit of the
+// route in config.ROUTEBODYLIMITS they are for.
+func limitBody(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		limit := config.MAXBODYBYTES
+		if _, n, ok := matchRoute(config.ROUTEBODYLIMITS, req.Method, req.URL.Path); ok {
+			limit = n
+		}
+
+		r := *req
+		r.Body = http.MaxBytesReader(rw, req.Body, limit)
+		h.ServeHTTP(rw, &r)
+	})
+}
+
 func (ctx *Context) InitStartTime(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 	ctx.ReqStartTime = time.Now()
 	next(rw, req)
 }
 
+// InitRequestID takes the request ID from the X-Request-ID header, or makes
+// one up when the header is missing or malformed, and echoes it back. It
+// also notes the client's address, as seen past any trusted proxies.
+func (ctx *Context) InitRequestID(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	ctx.ClientIP = clientIP(req.RemoteAddr, req.Header, _trustedProxies, _proxyHeader)
+
+	ctx.RequestID = req.Header.Get("X-Request-ID")
+	if !validRequestID(ctx.RequestID) {
+		ctx.RequestID = newRequestID()
+	}
+	rw.Header().Set("X-Request-ID", ctx.RequestID)
+
+	next(rw, req)
+}
+
 // InitLogger sets up the logger for the server context.
 func (ctx *Context) InitLogger(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 	ctx.Logger = log.New(
 		os.Stdout,
-		fmt.Sprintf("%s \"%s\" ", shared.MODE, req.URL.Path),
+		fmt.Sprintf("%s %s \"%s\" ", shared.MODE, ctx.ClientIP, req.URL.Path),
 		log.Lmsgprefix,
 	)
 
 	next(rw, req)
 }
 
-// RateLimit the API requests
-func (ctx *Context) RateLimit(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+// LimitConnections refuses requests beyond _maxConnections in flight.
+func (ctx *Context) LimitConnections(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
 	select {
 	case <-_connChan:
 	default:
@@ -106,44 +158,117 @@ func (ctx *Context) RateLimit(rw web.ResponseWriter, req *web.Request, next web.
 		_connChan <- 1
 	}()
 
-	var (
-		rps      float64
-		hash     string
-		clientIP string
-	)
+	next(rw, req)
+}
 
-	if len(req.RemoteAddr) > 0 {
-		clientIP = req.RemoteAddr[:strings.LastIndex(req.RemoteAddr, ":")]
-	}
+// Authenticate resolves the X-API-Key header, or a bearer token in the
+// Authorization header, to the principal making the request and checks they
+// hold the role, for a key, or scope, for a token, the request needs.
+func (ctx *Context) Authenticate(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	var bearer bool
 
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		hash = shared.HashSha1(fmt.Sprintf("%s_%s", clientIP, xff))
-	} else {
-		hash = shared.HashSha1(clientIP)
-	}
+	if secret := req.Header.Get("X-API-Key"); secret != "" {
+		if ctx.Principal = auth.Keys.Authenticate(secret); ctx.Principal == nil {
+			ctx.Respond(rw, http.StatusUnauthorized, auth.ErrInvalidKey.Error())
+			return
+		}
+	} else if token, ok := bearerToken(req); ok {
+		bearer = true
+		if auth.Tokens == nil {
+			ctx.Respond(rw, http.StatusUnauthorized, "bearer tokens are not accepted")
+			return
+		}
 
-	if !rateCache.Has(hash) {
-		_ = rateCache.Put(hash)
-	} else {
-		created, _, counter := rateCache.Inc(hash)
-
-		//get the total length of time this ip has been making requests
-		seenSecs := ctx.ReqStartTime.Sub(created).Seconds()
-		if seenSecs > 1.0 {
-			rps = counter / seenSecs
-			if rps >= _maxRPS {
-				ctx.Respond(rw, http.StatusTooManyRequests, "Request rate limit exceeded")
-				return
-			}
+		claims, err := auth.Tokens.Verify(token, time.Now())
+		if err != nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ctx.Respond(rw, http.StatusUnauthorized, err.Error())
+			return
 		}
+		ctx.Principal = claims.Principal()
+	}
 
+	role, scope := required(req)
+	switch {
+	case role == "":
+	case ctx.Principal == nil:
+		ctx.Respond(rw, http.StatusUnauthorized, "API key or bearer token required")
+		return
+	case !ctx.Principal.Permits(role, scope):
+		message := fmt.Sprintf("requires the %s role", role)
+		if bearer {
+			message = fmt.Sprintf("requires the %s scope", scope)
+			rw.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+		}
+		ctx.Respond(rw, http.StatusForbidden, message)
+		return
 	}
 
 	next(rw, req)
 }
 
+// required is what a request needs: the admin role for the admin routes,
+// manager for any other write and for reading the audit trail, and reader,
+// unless reads are public, for the rest; or the scope naming the route's
+// resource and access, such as products:write, with audit:read for the
+// audit trail. No role means anyone may make the request.
+func required(req *web.Request) (role, scope string) {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+
+	access := auth.ScopeWrite
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		access = auth.ScopeRead
+	}
+	scope = resource + ":" + access
+
+	switch {
+	case "/"+resource == _adminPrefix:
+		return auth.RoleAdmin, scope
+	case access == auth.ScopeWrite:
+		return auth.RoleManager, scope
+	case auditTrail(req.URL.Path):
+		return auth.RoleManager, _auditScope
+	case config.PUBLICREADS:
+		return "", scope
+	}
+
+	return auth.RoleReader, scope
+}
+
+// auditTrail reports whether path reads the audit trail: the audit log, a
+// product's history or the trash, which name who changed what and from
+// where.
+func auditTrail(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case parts[0] == "audit":
+		return true
+	case parts[0] != "products":
+		return false
+	case len(parts) == 2:
+		return parts[1] == "trash"
+	case len(parts) == 3:
+		return parts[2] == "history"
+	}
+
+	return false
+}
+
+// bearerToken returns the token of an Authorization header using the Bearer
+// scheme.
+func bearerToken(req *web.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
 func (ctx *Context) OptionsHandler(rw web.ResponseWriter, req *web.Request, methods []string) {
-	rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+	rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
 	rw.Header().Set("Access-Control-Max-Age", "86400")
 }
 
@@ -194,6 +319,21 @@ func (ctx *Context) Respond(rw web.ResponseWriter, code int, message string, dat
 		}
 	}
 
+	ctx.write(rw, msg)
+}
+
+// RespondPage responds with one page of a paginated listing and the cursor
+// for the next page.
+func (ctx *Context) RespondPage(rw web.ResponseWriter, code int, message string, next string, data interface{}) {
+	ctx.write(rw, &Message{
+		Code:    code,
+		Message: message,
+		Data:    data,
+		Next:    next,
+	})
+}
+
+func (ctx *Context) write(rw web.ResponseWriter, msg *Message) {
 	buf := bytes.Buffer{}
 	gz := gzip.NewWriter(&buf)
 	gz.Write(msg.Marshal())
@@ -203,10 +343,35 @@ func (ctx *Context) Respond(rw web.ResponseWriter, code int, message string, dat
 	rw.Header().Set("Content-Encoding", "gzip")
 	rw.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
 
-	rw.WriteHeader(code)
+	rw.WriteHeader(msg.Code)
 	rw.Write(buf.Bytes())
 }
 
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts up to 64 letters, digits, dashes and underscores,
+// so a client's ID can't smuggle anything into logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
 func listenAndServeTLS(srv *http.Server, certPEMBlock, keyPEMBlock []byte) error {
 	if srv.Addr == "" {
 		srv.Addr = ":https"
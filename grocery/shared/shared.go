@@ -30,7 +30,7 @@ var (
 func SetDebug() {
 	MODE = MODE_DEBUG
 	config.APIURL = config.DEVAPIURL
-	// set dbhost/dbname if connecting to a dev database
+	config.DBNAME = config.DEVDBNAME
 }
 
 func String(item interface{}) string {
@@ -74,3 +74,40 @@ func RoundFloat(number float64, places int) float64 {
 	f := math.Pow(10, float64(places))
 	return math.Round(number*f) / f
 }
+
+// MergePatch applies a JSON merge patch (RFC 7386) to doc and returns the
+// patched document.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+
+	for key, value := range changes {
+		if value == nil {
+			delete(doc, key)
+		} else {
+			doc[key] = mergePatch(doc[key], value)
+		}
+	}
+
+	return doc
+}
@@ -1,6 +1,10 @@
 package shared
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
 
 func TestGenProductCode(t *testing.T) {
 	wantedLength := 17
@@ -9,3 +13,30 @@ func TestGenProductCode(t *testing.T) {
 		t.Errorf("wanted product code length of %d but is of length %d", wantedLength, len(code))
 	}
 }
+
+func TestMergePatch(t *testing.T) {
+	var patchTable = []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[1]}`, `{"a":[2,3]}`, `{"a":[2,3]}`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+	}
+
+	for _, tc := range patchTable {
+		got, err := MergePatch([]byte(tc.doc), []byte(tc.patch))
+		if err != nil {
+			t.Fatalf("failed to merge %s into %s [ERR: %s]", tc.patch, tc.doc, err)
+		}
+
+		var gotV, wantV interface{}
+		json.Unmarshal(got, &gotV)
+		json.Unmarshal([]byte(tc.want), &wantV)
+		if !reflect.DeepEqual(gotV, wantV) {
+			t.Errorf("merging %s into %s: wanted %s but got %s", tc.patch, tc.doc, tc.want, got)
+		}
+	}
+}